@@ -1,19 +1,67 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"goftp/internal/config"
 	"goftp/internal/controller"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 func main() {
-	var stop = make(chan os.Signal, 1)
-	var goftp = controller.NewGoFTP()
+	cfg, err := config.Parse(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "goftp: %v\n", err)
+		os.Exit(2)
+	}
+
+	goftp, err := controller.NewGoFTP(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goftp: %v\n", err)
+		os.Exit(1)
+	}
 
+	var stop = make(chan os.Signal, 1)
+	var drain = make(chan os.Signal, 1)
+	var reload = make(chan os.Signal, 1)
 	go goftp.Start()
 	signal.Notify(stop, syscall.SIGQUIT, os.Interrupt)
+	signal.Notify(drain, syscall.SIGTERM)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	draining := false
+	for {
+		select {
+		case <-reload:
+			// rereads the file as well as the environment and flags it was started with
+			cfg, err := config.Parse(os.Args[1:], os.LookupEnv, io.Discard)
+			if err != nil {
+				goftp.Logger().Info(fmt.Sprintf("Reload rejected, keeping the running configuration: %v", err))
+				continue
+			}
 
-	<-stop
-	goftp.Stop()
+			if err := goftp.Reload(cfg); err != nil {
+				goftp.Logger().Info(fmt.Sprintf("Reload: %v", err))
+			}
+		case <-drain:
+			// a second signal while draining forces the shutdown
+			if draining {
+				goftp.Stop()
+				return
+			}
+			draining = true
+			go goftp.Drain()
+		case <-goftp.Drained():
+			return
+		case <-stop:
+			goftp.Stop()
+			return
+		}
+	}
 }
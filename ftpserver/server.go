@@ -0,0 +1,146 @@
+// Package ftpserver embeds goftp in other Go programs, as many servers as are needed can run
+// side by side in one process, each with its own filesystem, users and logger
+//
+//	server, err := ftpserver.New(
+//		ftpserver.WithAddress("127.0.0.1:2121"),
+//		ftpserver.WithFilesystem(ftpserver.NewMemoryFilesystem()),
+//		ftpserver.WithAuthenticator(ftpserver.NewUsers(&ftpserver.User{
+//			Name: "ci", Password: "secret", Permissions: ftpserver.All,
+//		})),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	go server.ListenAndServe()
+//	defer server.Shutdown(context.Background())
+package ftpserver
+
+import (
+	"context"
+	"errors"
+	"goftp/internal/config"
+	"goftp/internal/controller"
+	"net"
+)
+
+// ErrServerClosed is returned by ListenAndServe and Serve once the server has been shut down
+var ErrServerClosed = errors.New("ftpserver: server closed")
+
+// Server is an FTP server, built by New and started by ListenAndServe or Serve
+type Server struct {
+	config   *Config
+	address  string
+	injected bool
+	options  []controller.Options
+
+	goftp *controller.GoFTP
+}
+
+// WithConfig configures the server as a whole, starting from DefaultConfig or
+// LoadConfig, the other options take precedence over what it sets
+func WithConfig(cfg *Config) func(*Server) {
+	return func(s *Server) {
+		s.config = cfg
+	}
+}
+
+// WithAddress is the host:port ListenAndServe accepts connections on, in place of the configured listeners
+func WithAddress(addr string) func(*Server) {
+	return func(s *Server) {
+		s.address = addr
+	}
+}
+
+// WithFilesystem serves fs in place of the configured filesystem
+func WithFilesystem(fs Filesystem) func(*Server) {
+	return func(s *Server) {
+		s.injected = true
+		s.options = append(s.options, controller.WithFilesystem(fs))
+	}
+}
+
+// WithAuthenticator verifies credentials with a in place of the configured users
+func WithAuthenticator(a Authenticator) func(*Server) {
+	return func(s *Server) {
+		s.options = append(s.options, controller.WithAuthenticator(a))
+	}
+}
+
+// WithLogger logs to l in place of stdout
+func WithLogger(l Logger) func(*Server) {
+	return func(s *Server) {
+		s.options = append(s.options, controller.WithLogger(l))
+	}
+}
+
+// WithMiddleware runs every request through middleware, after the built in middleware
+func WithMiddleware(middleware ...Middleware) func(*Server) {
+	return func(s *Server) {
+		s.options = append(s.options, controller.WithMiddleware(middleware...))
+	}
+}
+
+// WithCommands replaces the commands clients can send, see DefaultCommands
+func WithCommands(commands *Commands) func(*Server) {
+	return func(s *Server) {
+		s.options = append(s.options, controller.WithCommands(commands))
+	}
+}
+
+type Options func(*Server)
+
+// New builds a server, the configuration is validated and every problem found is reported
+func New(options ...Options) (*Server, error) {
+	s := &Server{config: DefaultConfig()}
+	for _, option := range options {
+		option(s)
+	}
+
+	// copied so that the caller's configuration is left as it was
+	cfg := *s.config
+	if s.address != "" {
+		cfg.Listeners = []config.Listener{{Address: s.address}}
+	}
+	if s.injected {
+		// the configured filesystem isn't used, nor does it have to be there
+		cfg.Filesystem, cfg.Mounts = config.Filesystem{Backend: "memory"}, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	goftp, err := controller.NewGoFTP(&cfg, s.options...)
+	if err != nil {
+		return nil, err
+	}
+	s.goftp = goftp
+
+	return s, nil
+}
+
+// ListenAndServe accepts connections on the address given by WithAddress, or on the configured
+// listeners, until the server is shut down, it always returns a non nil error
+func (s *Server) ListenAndServe() error {
+	if err := s.goftp.ListenAndServe(); err != nil {
+		return err
+	}
+
+	return ErrServerClosed
+}
+
+// Serve accepts connections on l until the server is shut down, l is closed by then,
+// it always returns a non nil error
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.goftp.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	return ErrServerClosed
+}
+
+// Shutdown stops accepting connections, closes idle sessions and waits for transfers that
+// are under way to complete, until ctx is done at which point the rest are forced closed
+// and the error of ctx is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.goftp.Shutdown(ctx)
+}
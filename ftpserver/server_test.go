@@ -0,0 +1,279 @@
+package ftpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps what's logged to it
+type recorder struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (r *recorder) Info(msg string, args ...any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lines = append(r.lines, msg)
+}
+
+func (r *recorder) logged(msg string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, line := range r.lines {
+		if line == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// session logs in to addr as name, and sends every command, returning the replies
+func session(t *testing.T, addr, name string, commands ...string) []string {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+
+	var replies []string
+	for _, command := range append([]string{"USER " + name, "PASS secret"}, commands...) {
+		fmt.Fprintf(conn, "%s\r\n", command)
+		scanner.Scan()
+		replies = append(replies, scanner.Text())
+	}
+
+	return replies
+}
+
+func TestServers(t *testing.T) {
+	type running struct {
+		server *Server
+		fs     Filesystem
+		log    *recorder
+		addr   string
+		served chan error
+	}
+
+	var servers []*running
+	for _, name := range []string{"first", "second"} {
+		r := &running{fs: NewMemoryFilesystem(), log: new(recorder), served: make(chan error, 1)}
+		server, err := New(
+			WithFilesystem(r.fs),
+			WithAuthenticator(NewUsers(&User{Name: name, Password: "secret", Permissions: All})),
+			WithLogger(r.log),
+		)
+		if err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		r.server = server
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.addr = l.Addr().String()
+		go func() { r.served <- server.Serve(l) }()
+
+		servers = append(servers, r)
+	}
+
+	for _, r := range servers {
+		user := "first"
+		if r == servers[1] {
+			user = "second"
+		}
+
+		replies := session(t, r.addr, user, "MKD /"+user)
+		if expected := "230 User logged in, proceed"; replies[1] != expected {
+			t.Errorf("Expected: %s, but got %s", expected, replies[1])
+		}
+		if expected := fmt.Sprintf(`257 "/%s"`, user); replies[2] != expected {
+			t.Errorf("Expected: %s, but got %s", expected, replies[2])
+		}
+	}
+
+	// each server kept to its own filesystem and logger
+	if _, err := servers[0].fs.Stat("/second"); err == nil {
+		t.Errorf("Expected /second to only be on the second filesystem")
+	}
+	if _, err := servers[1].fs.Stat("/second"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if !servers[0].log.logged("MKD /first") || servers[1].log.logged("MKD /first") {
+		t.Errorf("Expected MKD /first to only be logged by the first server")
+	}
+
+	// shutting one down leaves the other running
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := servers[0].server.Shutdown(ctx); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if err := <-servers[0].served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected: %v, but got %v", ErrServerClosed, err)
+	}
+	if _, err := net.DialTimeout("tcp", servers[0].addr, time.Second); err == nil {
+		t.Errorf("Expected %s to no longer accept connections", servers[0].addr)
+	}
+
+	if replies := session(t, servers[1].addr, "second", "NOOP"); !strings.HasPrefix(replies[2], "200") {
+		t.Errorf("Expected: %s, but got %s", "200", replies[2])
+	}
+	servers[1].server.Shutdown(ctx)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Banner = ""
+
+	if _, err := New(WithConfig(cfg), WithAddress("127.0.0.1:0")); err == nil {
+		t.Errorf("Expected an error for an empty banner")
+	}
+
+	// the address given replaces the configured listeners, leaving cfg as it was
+	cfg.Banner = "Embedded"
+	if _, err := New(WithConfig(cfg), WithAddress("127.0.0.1:0")); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if cfg.Listeners[0].Address != ":2023" {
+		t.Errorf("Expected: %s, but got %s", ":2023", cfg.Listeners[0].Address)
+	}
+}
+
+func TestCustomTransfer(t *testing.T) {
+	fs := NewMemoryFilesystem()
+	file, _ := fs.Create("/hello.txt")
+	file.Write([]byte("hello"))
+	file.Close()
+
+	// XCAT sends a file the way RETR does, through what the Session offers embedders
+	commands := DefaultCommands()
+	commands.Register(Command{
+		Name:       "XCAT",
+		Permission: Download,
+		Sequence:   Transfer,
+		Help:       "XCAT <SP> <pathname>",
+		Handler: func(s Session, req *Request) (Response, error) {
+			fd, err := s.Filesystem().Open(req.Path())
+			if err != nil {
+				return FileNotFound, nil
+			}
+			return s.Transfer(func(conn io.ReadWriter) error {
+				defer fd.Close()
+				_, err := io.Copy(conn, fd)
+				return err
+			}), nil
+		},
+	})
+
+	cfg := DefaultConfig()
+	cfg.Timeouts = Timeouts{Idle: Duration(time.Minute), DataConnect: Duration(5 * time.Second)}
+	cfg.Throttle = Throttle{Download: Rates{Session: 1 << 20}}
+	server, err := New(
+		WithConfig(cfg),
+		WithFilesystem(fs),
+		WithAuthenticator(NewUsers(&User{Name: "reader", Password: "secret", Permissions: ReadOnly})),
+		WithCommands(commands),
+		WithLogger(new(recorder)),
+	)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+	reply := func(command string) string {
+		fmt.Fprintf(conn, "%s\r\n", command)
+		scanner.Scan()
+		return scanner.Text()
+	}
+
+	reply("USER reader")
+	reply("PASS secret")
+	if resp := reply("XCAT /hello.txt"); !strings.HasPrefix(resp, "503") {
+		t.Errorf("Expected: %s, but got %s", "503", resp)
+	}
+
+	var port int
+	if _, err := fmt.Sscanf(reply("EPSV"), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatal(err)
+	}
+	data, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+
+	if resp := reply("XCAT /hello.txt"); !strings.HasPrefix(resp, "125") {
+		t.Errorf("Expected: %s, but got %s", "125", resp)
+	}
+	received, _ := io.ReadAll(data)
+	if string(received) != "hello" {
+		t.Errorf("Expected: %s, but got %s", "hello", received)
+	}
+	scanner.Scan()
+	if resp := scanner.Text(); !strings.HasPrefix(resp, "250") {
+		t.Errorf("Expected: %s, but got %s", "250", resp)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	// refuses anything named .exe, with one of the replies embedders are given
+	deny := func(next Handler) Handler {
+		return func(req *Request) (Response, error) {
+			if strings.HasSuffix(req.Path(), ".exe") {
+				return FileNameNotAllowed, nil
+			}
+			return next(req)
+		}
+	}
+
+	server, err := New(
+		WithAuthenticator(NewUsers(&User{Name: "writer", Password: "secret", Permissions: All})),
+		WithMiddleware(deny),
+		WithLogger(new(recorder)),
+	)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Shutdown(context.Background())
+
+	replies := session(t, l.Addr().String(), "writer", "MKD setup.exe", "MKD setup")
+	if replies[2] != string(FileNameNotAllowed) {
+		t.Errorf("Expected: %s, but got %s", FileNameNotAllowed, replies[2])
+	}
+	if expected := `257 "/setup"`; replies[3] != expected {
+		t.Errorf("Expected: %s, but got %s", expected, replies[3])
+	}
+}
@@ -0,0 +1,164 @@
+package ftpserver
+
+import (
+	"goftp/internal/auth"
+	"goftp/internal/config"
+	"goftp/internal/logger"
+	"goftp/internal/vfs"
+	"goftp/internal/worker"
+)
+
+// the types servers are configured and extended with, so that they can be named outside of goftp
+type (
+	// Config is everything the goftp binary reads from its configuration file
+	Config = config.Config
+
+	// Filesystem is the storage served to clients, paths are absolute and use forward slashes
+	Filesystem = vfs.Filesystem
+	File       = vfs.File
+
+	// Authenticator verifies credentials and hands back the account they belong to
+	Authenticator = auth.Authenticator
+	User          = auth.User
+	Permission    = auth.Permission
+
+	// Users is an in memory Authenticator
+	Users = auth.Store
+
+	// Logger is where servers log to, a *slog.Logger is one
+	Logger = logger.Client
+
+	// Middleware wraps the Handler of every command, see WithMiddleware
+	Middleware = worker.Middleware
+	Handler    = worker.Handler
+	Request    = worker.Request
+	Response   = worker.Response
+
+	// Commands maps the verbs clients send onto how they're handled, see WithCommands
+	Commands = worker.Commands
+	Command  = worker.Command
+	Sequence = worker.Sequence
+
+	// Session is the control connection a Command is run on, its Handler works with the Filesystem
+	// the user sees, checks what they're Permitted and, for commands of Sequence Transfer, hands
+	// the data connection to a function with Transfer
+	Session = worker.Session
+)
+
+// the parts of Config, named after the settings they hold, FilesystemConfig and UserConfig
+// to keep them apart from the Filesystem and User embedders implement
+type (
+	Listener         = config.Listener
+	FilesystemConfig = config.Filesystem
+	Mount            = config.Mount
+	S3               = config.S3
+	Passive          = config.Passive
+	Active           = config.Active
+	TLS              = config.TLS
+	Auth             = config.Auth
+	UserConfig       = config.User
+	Limits           = config.Limits
+	Guard            = config.Guard
+	Log              = config.Log
+	Admin            = config.Admin
+	Drain            = config.Drain
+	Timeouts         = config.Timeouts
+	Uploads          = config.Uploads
+	Quotas           = config.Quotas
+	Quota            = config.Quota
+	Throttle         = config.Throttle
+	Rates            = config.Rates
+	Events           = config.Events
+	EventCommand     = config.EventCommand
+	EventWebhook     = config.EventWebhook
+	EventSpool       = config.EventSpool
+
+	// Duration is a time.Duration written as a string such as "5m" in configuration files
+	Duration = config.Duration
+)
+
+const (
+	List      = auth.List
+	Download  = auth.Download
+	Upload    = auth.Upload
+	Overwrite = auth.Overwrite
+	Append    = auth.Append
+	Delete    = auth.Delete
+	Rename    = auth.Rename
+	Mkdir     = auth.Mkdir
+	Rmd       = auth.Rmd
+	Site      = auth.Site
+
+	NoPermissions = auth.NoPermissions
+	ReadOnly      = auth.ReadOnly
+	DropBox       = auth.DropBox
+	All           = auth.All
+)
+
+const (
+	Anytime  = worker.Anytime
+	Idle     = worker.Idle
+	Transfer = worker.Transfer
+)
+
+// replies a Handler or Middleware commonly sends, any other can be given as its
+// code and text, such as Response("213 1024")
+const (
+	StartTransfer             = worker.StartTransfer
+	FileOKOpenDataConn        = worker.FileOKOpenDataConn
+	CommandOK                 = worker.CommandOK
+	TransferComplete          = worker.TransferComplete
+	PendingFurtherInfo        = worker.PendingFurtherInfo
+	ServiceNotAvailable       = worker.ServiceNotAvailable
+	CannotOpenDataConnection  = worker.CannotOpenDataConnection
+	TransferAborted           = worker.TransferAborted
+	FileActionNotTaken        = worker.FileActionNotTaken
+	LocalError                = worker.LocalError
+	SyntaxError1              = worker.SyntaxError1
+	SyntaxError2              = worker.SyntaxError2
+	CmdNotImplemented         = worker.CmdNotImplemented
+	BadSequence               = worker.BadSequence
+	CmdNotImplementedForParam = worker.CmdNotImplementedForParam
+	NotLoggedIn               = worker.NotLoggedIn
+	FileNotFound              = worker.FileNotFound
+	ExceededStorage           = worker.ExceededStorage
+	FileNameNotAllowed        = worker.FileNameNotAllowed
+)
+
+// ErrInvalidCredentials is what an Authenticator returns for a wrong username or password
+var ErrInvalidCredentials = auth.ErrInvalidCredentials
+
+// DefaultConfig is what the goftp binary runs with when it's given no configuration, other than
+// the admin interface which embedded servers leave disabled, and files which are kept in memory
+func DefaultConfig() *Config {
+	cfg := config.Default()
+	cfg.Admin.Address = ""
+	cfg.Filesystem = config.Filesystem{Backend: "memory"}
+	return cfg
+}
+
+// LoadConfig reads the JSON configuration file at pth the way the goftp binary
+// does, the admin interface included unless the file disables it
+func LoadConfig(pth string) (*Config, error) {
+	return config.Load(pth)
+}
+
+// NewUsers returns an Authenticator that knows of users
+func NewUsers(users ...*User) *Users {
+	return auth.NewStore(users...)
+}
+
+// NewOSFilesystem serves the directory dir
+func NewOSFilesystem(dir string) Filesystem {
+	return vfs.NewOS(dir)
+}
+
+// NewMemoryFilesystem serves an empty tree held in memory
+func NewMemoryFilesystem() Filesystem {
+	return vfs.NewMemory()
+}
+
+// DefaultCommands returns the built in commands, further ones can be registered with them
+func DefaultCommands() *Commands {
+	return worker.DefaultCommands()
+}
@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
+	"goftp/internal/logger"
+	"goftp/internal/throttle"
+	"net"
+	"net/http"
+	"time"
+)
+
+func WithLogger(l logger.Client) func(*Admin) {
+	return func(a *Admin) {
+		a.logger = l
+	}
+}
+
+// WithAddress sets where the admin interface listens, it has no
+// authentication of its own so should not be exposed publicly
+func WithAddress(addr string) func(*Admin) {
+	return func(a *Admin) {
+		a.addr = addr
+	}
+}
+
+func WithGuard(g *guard.Guard) func(*Admin) {
+	return func(a *Admin) {
+		a.guard = g
+	}
+}
+
+func WithLimits(t *limits.Tracker) func(*Admin) {
+	return func(a *Admin) {
+		a.limits = t
+	}
+}
+
+// WithThrottle lets operators change transfer rates through GET and PUT /throttle
+func WithThrottle(t *throttle.Throttle) func(*Admin) {
+	return func(a *Admin) {
+		a.throttle = t
+	}
+}
+
+// WithDrainer lets operators drain the server through POST /drain
+func WithDrainer(d interface{ Drain() }) func(*Admin) {
+	return func(a *Admin) {
+		a.drainer = d
+	}
+}
+
+type Options func(*Admin)
+
+// Admin exposes a small JSON over HTTP interface used by operators to
+// inspect and manage the running server
+type Admin struct {
+	logger logger.Client
+	addr   string
+	server *http.Server
+
+	guard interface {
+		Bans() []guard.Ban
+		Unban(string) bool
+	}
+
+	limits interface {
+		Stats() limits.Stats
+	}
+
+	// nil when transfer rates can't be changed
+	throttle interface {
+		Config() throttle.Config
+		SetConfig(throttle.Config)
+	}
+
+	// nil when draining isn't available
+	drainer interface {
+		Drain()
+	}
+}
+
+func New(options ...Options) *Admin {
+	a := &Admin{
+		logger: logger.NewStdStreamClient(),
+		addr:   "127.0.0.1:2024",
+		guard:  guard.New(guard.Config{}),
+		limits: limits.New(limits.Config{}),
+	}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	a.server = &http.Server{
+		Addr:              a.addr,
+		Handler:           a.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return a
+}
+
+func (a *Admin) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /bans", a.handleListBans)
+	mux.HandleFunc("DELETE /bans/{ip}", a.handleUnban)
+	mux.HandleFunc("GET /stats", a.handleStats)
+	mux.HandleFunc("GET /throttle", a.handleGetThrottle)
+	mux.HandleFunc("PUT /throttle", a.handleSetThrottle)
+	mux.HandleFunc("POST /drain", a.handleDrain)
+	return mux
+}
+
+// Start serves the admin interface until Stop is called
+func (a *Admin) Start() {
+	a.logger.Info(fmt.Sprintf("Admin interface listening on %s", a.addr))
+
+	listener, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		a.logger.Info(fmt.Sprintf("Admin interface unable to listen: %v", err))
+		return
+	}
+
+	if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		a.logger.Info(fmt.Sprintf("Admin interface error: %v", err))
+	}
+}
+
+func (a *Admin) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.server.Shutdown(ctx)
+}
+
+func (a *Admin) handleListBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.guard.Bans())
+}
+
+func (a *Admin) handleUnban(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	if !a.guard.Unban(ip) {
+		http.Error(w, fmt.Sprintf("%s is not banned", ip), http.StatusNotFound)
+		return
+	}
+
+	a.logger.Info(fmt.Sprintf("Admin lifted ban on %s", ip))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.limits.Stats())
+}
+
+func (a *Admin) handleGetThrottle(w http.ResponseWriter, r *http.Request) {
+	if a.throttle == nil {
+		http.Error(w, "throttling is not available", http.StatusNotImplemented)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.throttle.Config())
+}
+
+// handleSetThrottle replaces every rate, transfers that are under way pick them up
+// straight away, until the configuration is next reloaded
+func (a *Admin) handleSetThrottle(w http.ResponseWriter, r *http.Request) {
+	if a.throttle == nil {
+		http.Error(w, "throttling is not available", http.StatusNotImplemented)
+		return
+	}
+
+	var config throttle.Config
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, rates := range []throttle.Rates{config.Upload, config.Download} {
+		if rates.Session < 0 || rates.User < 0 || rates.Global < 0 {
+			http.Error(w, "rates can't be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	a.throttle.SetConfig(config)
+	a.logger.Info(fmt.Sprintf("Admin changed transfer rates to %+v", config))
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleDrain replies as soon as draining has started, it carries on in the background
+func (a *Admin) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if a.drainer == nil {
+		http.Error(w, "draining is not available", http.StatusNotImplemented)
+		return
+	}
+
+	a.logger.Info("Admin requested the server be drained")
+	go a.drainer.Drain()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"encoding/json"
+	"goftp/internal/guard"
+	"goftp/internal/throttle"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBans(t *testing.T) {
+	g := guard.New(guard.Config{MaxAttempts: 1, BanDuration: time.Hour})
+	g.Fail("10.0.0.1")
+
+	server := httptest.NewServer(New(WithGuard(g)).routes())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/bans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var bans []guard.Ban
+	if err := json.NewDecoder(resp.Body).Decode(&bans); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bans) != 1 || bans[0].IP != "10.0.0.1" {
+		t.Errorf("Expected a single ban for 10.0.0.1, but got %v", bans)
+	}
+
+	for _, expected := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req, _ := http.NewRequest(http.MethodDelete, server.URL+"/bans/10.0.0.1", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Expected: %d, but got %d", expected, resp.StatusCode)
+		}
+	}
+
+	if g.Banned("10.0.0.1") {
+		t.Errorf("Expected 10.0.0.1 not to be banned")
+	}
+}
+
+type drainer chan struct{}
+
+func (d drainer) Drain() {
+	close(d)
+}
+
+func TestDrain(t *testing.T) {
+	unavailable := httptest.NewServer(New().routes())
+	defer unavailable.Close()
+
+	resp, err := http.Post(unavailable.URL+"/drain", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected: %d, but got %d", http.StatusNotImplemented, resp.StatusCode)
+	}
+
+	drained := make(drainer)
+	server := httptest.NewServer(New(WithDrainer(drained)).routes())
+	defer server.Close()
+
+	resp, err = http.Post(server.URL+"/drain", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected: %d, but got %d", http.StatusAccepted, resp.StatusCode)
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the server to be drained")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := throttle.New(throttle.Config{Download: throttle.Rates{Global: 1000}})
+	server := httptest.NewServer(New(WithThrottle(th)).routes())
+	defer server.Close()
+
+	for _, testcase := range []struct {
+		Body     string
+		Expected int
+	}{
+		{`{"upload": {"session": 100}, "download": {"user": 200}}`, http.StatusOK},
+		{`{"upload": {"session": -1}}`, http.StatusBadRequest},
+		{`{"upload": {"sesion": 100}}`, http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/throttle", strings.NewReader(testcase.Body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != testcase.Expected {
+			t.Errorf("Expected: %d, but got %d for %s", testcase.Expected, resp.StatusCode, testcase.Body)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/throttle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var config throttle.Config
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := throttle.Config{Upload: throttle.Rates{Session: 100}, Download: throttle.Rates{User: 200}}
+	if config != expected {
+		t.Errorf("Expected: %+v, but got %+v", expected, config)
+	}
+}
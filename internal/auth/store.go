@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator is implemented by every backend that can verify
+// credentials and hand back the account they belong to
+type Authenticator interface {
+	Lookup(username string) (*User, bool)
+	Authenticate(username, password string) (*User, error)
+}
+
+// Store is an in memory Authenticator, safe for concurrent use
+type Store struct {
+	mutex sync.RWMutex
+	users map[string]*User
+}
+
+func NewStore(users ...*User) *Store {
+	s := &Store{}
+	s.Replace(users...)
+	return s
+}
+
+// Lookup returns the account registered under username, if any
+func (s *Store) Lookup(username string) (*User, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, ok := s.users[username]
+	return user, ok
+}
+
+func (s *Store) Authenticate(username, password string) (*User, error) {
+	user, ok := s.Lookup(username)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// Replace swaps out every registered account in one step
+func (s *Store) Replace(users ...*User) {
+	registered := make(map[string]*User, len(users))
+	for _, user := range users {
+		registered[user.Name] = user
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users = registered
+}
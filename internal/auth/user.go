@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Permission is a bit set of the operations a user is allowed to perform,
+// each FTP command that touches the filesystem maps to one of these
+type Permission uint16
+
+const (
+	List      Permission = 1 << iota // LIST, NLST
+	Download                         // RETR
+	Upload                           // STOR of a new file
+	Overwrite                        // STOR or RNTO over an existing file
+	Append                           // APPE
+	Delete                           // DELE
+	Rename                           // RNFR, RNTO
+	Mkdir                            // MKD
+	Rmd                              // RMD
+	Site                             // SITE
+
+	NoPermissions Permission = 0
+
+	// common combinations
+	ReadOnly Permission = List | Download
+	DropBox  Permission = Upload
+	All      Permission = List | Download | Upload | Overwrite | Append |
+		Delete | Rename | Mkdir | Rmd | Site
+)
+
+// permissionNames are how permissions are spelled out in configuration
+var permissionNames = map[string]Permission{
+	"list":      List,
+	"download":  Download,
+	"upload":    Upload,
+	"overwrite": Overwrite,
+	"append":    Append,
+	"delete":    Delete,
+	"rename":    Rename,
+	"mkdir":     Mkdir,
+	"rmd":       Rmd,
+	"site":      Site,
+	"none":      NoPermissions,
+	"readonly":  ReadOnly,
+	"dropbox":   DropBox,
+	"all":       All,
+}
+
+// ParsePermissions combines permissions given by name, e.g. ["readonly", "upload"]
+func ParsePermissions(names []string) (Permission, error) {
+	granted := NoPermissions
+	for _, name := range names {
+		perm, ok := permissionNames[strings.ToLower(name)]
+		if !ok {
+			return NoPermissions, fmt.Errorf("unknown permission %q", name)
+		}
+		granted |= perm
+	}
+
+	return granted, nil
+}
+
+// User couples the credentials of an account with what it's allowed to do
+type User struct {
+	Name     string
+	Password string
+
+	// Permissions granted across the entire tree
+	Permissions Permission
+
+	// Paths optionally overrides Permissions for a directory and
+	// everything below it, the longest matching directory wins
+	Paths map[string]Permission
+}
+
+// Can reports whether the user holds perm for the given absolute virtual path
+func (u *User) Can(perm Permission, pth string) bool {
+	return u.permissionsFor(pth)&perm == perm
+}
+
+func (u *User) permissionsFor(pth string) Permission {
+	granted := u.Permissions
+	longest := -1
+
+	pth = path.Clean("/" + pth)
+	for dir, perms := range u.Paths {
+		dir = path.Clean("/" + dir)
+		if !contains(dir, pth) {
+			continue
+		}
+
+		if len(dir) > longest {
+			longest = len(dir)
+			granted = perms
+		}
+	}
+
+	return granted
+}
+
+// contains checks whether pth is dir or lives somewhere beneath it
+func contains(dir, pth string) bool {
+	if dir == "/" || dir == pth {
+		return true
+	}
+
+	return strings.HasPrefix(pth, dir+"/")
+}
@@ -0,0 +1,124 @@
+package auth
+
+import "testing"
+
+var permissionTestCases = []struct {
+	TestName   string
+	User       *User
+	Permission Permission
+	Path       string
+	Expected   bool
+}{
+	{
+		TestName:   "Test_All_Can_Upload",
+		User:       &User{Permissions: All},
+		Permission: Upload,
+		Path:       "/temp/hello.txt",
+		Expected:   true,
+	},
+	{
+		TestName:   "Test_ReadOnly_Cannot_Upload",
+		User:       &User{Permissions: ReadOnly},
+		Permission: Upload,
+		Path:       "/temp/hello.txt",
+		Expected:   false,
+	},
+	{
+		TestName:   "Test_DropBox_Cannot_Download",
+		User:       &User{Permissions: DropBox},
+		Permission: Download,
+		Path:       "/temp/hello.txt",
+		Expected:   false,
+	},
+	{
+		TestName: "Test_Path_Override_Grants",
+		User: &User{
+			Permissions: ReadOnly,
+			Paths:       map[string]Permission{"/temp/incoming": DropBox},
+		},
+		Permission: Upload,
+		Path:       "/temp/incoming/hello.txt",
+		Expected:   true,
+	},
+	{
+		TestName: "Test_Path_Override_Revokes",
+		User: &User{
+			Permissions: ReadOnly,
+			Paths:       map[string]Permission{"/temp/incoming": DropBox},
+		},
+		Permission: Download,
+		Path:       "/temp/incoming/hello.txt",
+		Expected:   false,
+	},
+	{
+		TestName: "Test_Path_Override_Sibling_Unaffected",
+		User: &User{
+			Permissions: ReadOnly,
+			Paths:       map[string]Permission{"/temp/incoming": DropBox},
+		},
+		Permission: Download,
+		Path:       "/temp/incoming2/hello.txt",
+		Expected:   true,
+	},
+	{
+		TestName: "Test_Path_Override_Longest_Wins",
+		User: &User{
+			Permissions: All,
+			Paths: map[string]Permission{
+				"/temp":         ReadOnly,
+				"/temp/private": NoPermissions,
+			},
+		},
+		Permission: List,
+		Path:       "/temp/private/../private/secret.txt",
+		Expected:   false,
+	},
+	{
+		TestName:   "Test_Combined_Permission_Requires_Every_Bit",
+		User:       &User{Permissions: Upload},
+		Permission: Upload | Overwrite,
+		Path:       "/temp/hello.txt",
+		Expected:   false,
+	},
+}
+
+func TestCan(t *testing.T) {
+	for _, testcase := range permissionTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			if got := testcase.User.Can(testcase.Permission, testcase.Path); got != testcase.Expected {
+				t.Errorf("Expected: %v, but got %v", testcase.Expected, got)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := NewStore(&User{Name: "hkhan", Password: "password", Permissions: All})
+
+	if _, err := store.Authenticate("hkhan", "password"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if _, err := store.Authenticate("hkhan", "password123"); err != ErrInvalidCredentials {
+		t.Errorf("Expected: %v, but got %v", ErrInvalidCredentials, err)
+	}
+
+	if _, err := store.Authenticate("hakhan", "password"); err != ErrInvalidCredentials {
+		t.Errorf("Expected: %v, but got %v", ErrInvalidCredentials, err)
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	perms, err := ParsePermissions([]string{"ReadOnly", "upload"})
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if perms != List|Download|Upload {
+		t.Errorf("Expected: %b, but got %b", List|Download|Upload, perms)
+	}
+
+	if _, err := ParsePermissions([]string{"chmod"}); err == nil {
+		t.Errorf("Expected an error for an unknown permission, but got nil")
+	}
+}
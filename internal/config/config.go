@@ -0,0 +1,767 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
+	"goftp/internal/worker"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is everything that can be configured about the server, it's read
+// from a JSON file and can then be overridden by environment variables and
+// command line flags, see Parse
+type Config struct {
+	Listeners []Listener `json:"listeners"`
+
+	// directory on disk that clients see as /, when served from the os filesystem
+	Root string `json:"root"`
+
+	Filesystem Filesystem `json:"filesystem"`
+
+	// further filesystems served at paths within /, see Mount
+	Mounts []Mount `json:"mounts"`
+
+	// text of the 220 reply clients are greeted with
+	Banner string `json:"banner"`
+
+	Passive  Passive  `json:"passive"`
+	Active   Active   `json:"active"`
+	TLS      TLS      `json:"tls"`
+	Auth     Auth     `json:"auth"`
+	Limits   Limits   `json:"limits"`
+	Guard    Guard    `json:"guard"`
+	Log      Log      `json:"log"`
+	Admin    Admin    `json:"admin"`
+	Drain    Drain    `json:"drain"`
+	Timeouts Timeouts `json:"timeouts"`
+	Uploads  Uploads  `json:"uploads"`
+	Quotas   Quotas   `json:"quotas"`
+	Throttle Throttle `json:"throttle"`
+	Events   Events   `json:"events"`
+}
+
+type Listener struct {
+	Address string `json:"address"`
+
+	// address advertised in PASV replies, for listeners reached through NAT
+	Masquerade string `json:"masquerade,omitempty"`
+
+	// implicit TLS, the connection is secured before the 220 greeting
+	TLS bool `json:"tls,omitempty"`
+}
+
+// Filesystem selects where files are stored, "os" serves Root, "memory" keeps
+// everything in memory for as long as the server runs and "s3" serves a bucket
+type Filesystem struct {
+	Backend string `json:"backend"`
+	S3      S3     `json:"s3"`
+}
+
+// Mount serves a filesystem at Path instead of whatever / holds there, clients
+// see the mount point in listings of its parent and can't rename across it
+type Mount struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
+
+	// directory on disk served at Path, when mounted from the os filesystem
+	Root string `json:"root"`
+
+	Filesystem
+}
+
+// S3 is an S3 compatible bucket, see vfs.S3Config
+type S3 struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+
+	// bytes uploads are streamed in, 0 for the default of 8MiB
+	PartSize int64 `json:"part_size"`
+}
+
+// Passive is the range of ports passive data connections are opened on,
+// an empty range leaves it to the OS
+type Passive struct {
+	MinPort      uint16 `json:"min_port"`
+	MaxPort      uint16 `json:"max_port"`
+	AllowForeign bool   `json:"allow_foreign"`
+}
+
+type Active struct {
+	// CIDR prefixes PORT may name other than the address of the client
+	AllowList           []string `json:"allow_list"`
+	DenyPrivilegedPorts bool     `json:"deny_privileged_ports"`
+}
+
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// Auth selects where accounts come from, "config" reads Users while
+// "file" reads a JSON array of users from File
+type Auth struct {
+	Backend string `json:"backend"`
+	Users   []User `json:"users,omitempty"`
+	File    string `json:"file,omitempty"`
+}
+
+// User is an auth.User with its permissions spelled out by name, see auth.ParsePermissions
+type User struct {
+	Name        string              `json:"name"`
+	Password    string              `json:"password"`
+	Permissions []string            `json:"permissions"`
+	Paths       map[string][]string `json:"paths,omitempty"`
+}
+
+type Limits struct {
+	MaxSessions      int `json:"max_sessions"`
+	MaxSessionsPerIP int `json:"max_sessions_per_ip"`
+	MaxLoginsPerUser int `json:"max_logins_per_user"`
+}
+
+type Guard struct {
+	MaxSessionAttempts int      `json:"max_session_attempts"`
+	MaxAttempts        int      `json:"max_attempts"`
+	Window             Duration `json:"window"`
+	BanDuration        Duration `json:"ban_duration"`
+	BaseDelay          Duration `json:"base_delay"`
+	MaxDelay           Duration `json:"max_delay"`
+}
+
+type Log struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// Admin is where the admin interface listens, it's disabled when Address is empty
+type Admin struct {
+	Address string `json:"address"`
+}
+
+// Drain is how long transfers that are under way get to complete when the
+// server is drained, by SIGTERM or through the admin interface
+type Drain struct {
+	GracePeriod Duration `json:"grace_period"`
+}
+
+// Timeouts bound how long sessions wait on clients, "0s" disables one
+type Timeouts struct {
+	// control connection without a command, outside of transfers
+	Idle Duration `json:"idle"`
+
+	// PASV/PORT until the data connection is established and used
+	DataConnect Duration `json:"data_connect"`
+
+	// transfer without any data moving
+	Stall Duration `json:"stall"`
+}
+
+// Uploads decides how STOR writes files
+type Uploads struct {
+	// write to a hidden file in the same directory that's renamed into place
+	// once the transfer completes, so a failed or aborted upload leaves nothing behind
+	Atomic bool `json:"atomic"`
+}
+
+// Quotas cap what users, across every file they upload, and directory trees, across
+// every file within them, can hold. Uploads that would go over are refused with a 552
+type Quotas struct {
+	Users map[string]Quota `json:"users"`
+	Paths map[string]Quota `json:"paths"`
+
+	// how often usage is recomputed from the filesystem, to catch up
+	// with changes made outside of the server, "0s" disables it
+	Interval Duration `json:"interval"`
+}
+
+// Quota is the most bytes and files that can be held, 0 leaves either unbounded
+type Quota struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// Throttle caps how fast data is transferred, separately for uploads and downloads
+type Throttle struct {
+	Upload   Rates `json:"upload"`
+	Download Rates `json:"download"`
+}
+
+// Rates are in bytes per second, 0 leaves any of them unlimited
+type Rates struct {
+	// each session on its own
+	Session int64 `json:"session"`
+
+	// every session of a user put together
+	User int64 `json:"user"`
+
+	// every session on the server put together
+	Global int64 `json:"global"`
+}
+
+// Events are handed to local commands, webhooks and spool files as sessions raise them,
+// each only takes the types of event it lists, or every type when it lists none
+type Events struct {
+	Commands []EventCommand `json:"commands"`
+	Webhooks []EventWebhook `json:"webhooks"`
+	Spools   []EventSpool   `json:"spools"`
+}
+
+// EventCommand runs a program for every event, see events.Command
+type EventCommand struct {
+	// program followed by its arguments
+	Command []string `json:"command"`
+	Events  []string `json:"events"`
+
+	// how long the program is given before it's killed, 30s when left out
+	Timeout Duration `json:"timeout"`
+}
+
+// EventWebhook POSTs every event to an http(s) URL, see events.Webhook
+type EventWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+
+	// how long a request is given, 10s when left out
+	Timeout Duration `json:"timeout"`
+}
+
+// EventSpool appends every event to a JSONL file, see events.Spool
+type EventSpool struct {
+	File   string   `json:"file"`
+	Events []string `json:"events"`
+}
+
+// Duration is a time.Duration written the way time.ParseDuration reads it, e.g. "30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default is what the server runs with when nothing is configured
+func Default() *Config {
+	return &Config{
+		Listeners:  []Listener{{Address: ":2023"}},
+		Root:       "./temp",
+		Filesystem: Filesystem{Backend: "os"},
+		Banner:     "Service Ready",
+		Passive:    Passive{MinPort: 50000, MaxPort: 50100},
+		Active:     Active{DenyPrivilegedPorts: true},
+		Auth: Auth{
+			Backend: "config",
+			Users: []User{{
+				Name:        "hkhan",
+				Password:    "password",
+				Permissions: []string{"all"},
+			}},
+		},
+		Limits: Limits{
+			MaxSessions:      512,
+			MaxSessionsPerIP: 32,
+			MaxLoginsPerUser: 16,
+		},
+		Guard: Guard{
+			MaxSessionAttempts: 3,
+			MaxAttempts:        10,
+			Window:             Duration(10 * time.Minute),
+			BanDuration:        Duration(30 * time.Minute),
+			BaseDelay:          Duration(time.Second),
+			MaxDelay:           Duration(30 * time.Second),
+		},
+		Log:   Log{Level: "info", Format: "json"},
+		Admin: Admin{Address: "127.0.0.1:2024"},
+		Drain: Drain{GracePeriod: Duration(5 * time.Minute)},
+		Timeouts: Timeouts{
+			Idle:        Duration(5 * time.Minute),
+			DataConnect: Duration(time.Minute),
+			Stall:       Duration(5 * time.Minute),
+		},
+		Uploads: Uploads{Atomic: true},
+		Quotas:  Quotas{Interval: Duration(10 * time.Minute)},
+	}
+}
+
+// Load reads the file at pth over the defaults, fields left out of the file keep their default
+func Load(pth string) (*Config, error) {
+	c := Default()
+
+	switch ext := strings.ToLower(filepath.Ext(pth)); ext {
+	case ".json", "":
+	default:
+		return nil, fmt.Errorf("config %s: %s files are not supported, the configuration file has to be JSON", pth, ext)
+	}
+
+	b, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return nil, fmt.Errorf("config %s: %w", pth, err)
+	}
+
+	return c, nil
+}
+
+// Validate checks the entire configuration, every problem found is reported rather than just the first
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", field, err))
+	}
+
+	if len(c.Listeners) == 0 {
+		invalid("listeners", errors.New("at least one listener is required"))
+	}
+	for i, listener := range c.Listeners {
+		field := fmt.Sprintf("listeners[%d]", i)
+		if err := validateAddress(listener.Address); err != nil {
+			invalid(field+".address", err)
+		}
+		if listener.Masquerade != "" {
+			if _, err := netip.ParseAddr(listener.Masquerade); err != nil {
+				invalid(field+".masquerade", err)
+			}
+		}
+		if listener.TLS && c.TLS.CertFile == "" {
+			invalid(field+".tls", errors.New("tls.cert_file and tls.key_file are required for TLS listeners"))
+		}
+	}
+
+	validateFilesystem(c.Filesystem, c.Root, "root", "filesystem", invalid)
+	paths := make(map[string]bool)
+	for i, mount := range c.Mounts {
+		field := fmt.Sprintf("mounts[%d]", i)
+		switch {
+		case !strings.HasPrefix(mount.Path, "/") || vfs.Clean(mount.Path) != mount.Path:
+			invalid(field+".path", fmt.Errorf("%q has to be an absolute path", mount.Path))
+		case mount.Path == "/":
+			invalid(field+".path", errors.New("/ is served by filesystem"))
+		case paths[mount.Path]:
+			invalid(field+".path", fmt.Errorf("%s is mounted more than once", mount.Path))
+		}
+		paths[mount.Path] = true
+		validateFilesystem(mount.Filesystem, mount.Root, field+".root", field, invalid)
+	}
+
+	if c.Banner == "" || strings.ContainsAny(c.Banner, "\r\n") {
+		invalid("banner", errors.New("has to be a single, non empty, line"))
+	}
+
+	if c.Passive.MinPort > c.Passive.MaxPort || (c.Passive.MinPort == 0) != (c.Passive.MaxPort == 0) {
+		invalid("passive", fmt.Errorf("invalid port range %d-%d", c.Passive.MinPort, c.Passive.MaxPort))
+	}
+
+	if _, err := c.DataPolicy(); err != nil {
+		invalid("active.allow_list", err)
+	}
+
+	if _, err := c.TLSConfig(); err != nil {
+		invalid("tls", err)
+	}
+
+	if _, err := c.Users(); err != nil {
+		invalid("auth", err)
+	}
+
+	if c.Limits.MaxSessions < 0 || c.Limits.MaxSessionsPerIP < 0 || c.Limits.MaxLoginsPerUser < 0 {
+		invalid("limits", errors.New("limits can't be negative, use 0 for no limit"))
+	}
+
+	if c.Guard.MaxSessionAttempts < 0 || c.Guard.MaxAttempts < 0 || c.Guard.Window < 0 ||
+		c.Guard.BanDuration < 0 || c.Guard.BaseDelay < 0 || c.Guard.MaxDelay < 0 {
+		invalid("guard", errors.New("attempts and durations can't be negative"))
+	}
+
+	if _, err := c.LogLevel(); err != nil {
+		invalid("log.level", err)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		invalid("log.format", fmt.Errorf("%q is not one of json, text", c.Log.Format))
+	}
+
+	if c.Quotas.Interval < 0 {
+		invalid("quotas.interval", errors.New("can't be negative"))
+	}
+	for name, q := range c.Quotas.Users {
+		if q.Bytes < 0 || q.Files < 0 {
+			invalid(fmt.Sprintf("quotas.users[%q]", name), errors.New("can't be negative"))
+		}
+	}
+	for pth, q := range c.Quotas.Paths {
+		field := fmt.Sprintf("quotas.paths[%q]", pth)
+		if !strings.HasPrefix(pth, "/") || vfs.Clean(pth) != pth {
+			invalid(field, errors.New("has to be an absolute path"))
+		}
+		if q.Bytes < 0 || q.Files < 0 {
+			invalid(field, errors.New("can't be negative"))
+		}
+	}
+
+	for field, rates := range map[string]Rates{"throttle.upload": c.Throttle.Upload, "throttle.download": c.Throttle.Download} {
+		if rates.Session < 0 || rates.User < 0 || rates.Global < 0 {
+			invalid(field, errors.New("rates can't be negative"))
+		}
+	}
+
+	for i, cmd := range c.Events.Commands {
+		field := fmt.Sprintf("events.commands[%d]", i)
+		if len(cmd.Command) == 0 || cmd.Command[0] == "" {
+			invalid(field+".command", errors.New("a program has to be given"))
+		}
+		if cmd.Timeout < 0 {
+			invalid(field+".timeout", errors.New("can't be negative"))
+		}
+		validateEventTypes(cmd.Events, field+".events", invalid)
+	}
+	for i, hook := range c.Events.Webhooks {
+		field := fmt.Sprintf("events.webhooks[%d]", i)
+		if u, err := url.Parse(hook.URL); err != nil {
+			invalid(field+".url", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid(field+".url", fmt.Errorf("%q is not an http(s) URL", hook.URL))
+		}
+		if hook.Timeout < 0 {
+			invalid(field+".timeout", errors.New("can't be negative"))
+		}
+		validateEventTypes(hook.Events, field+".events", invalid)
+	}
+	for i, spool := range c.Events.Spools {
+		field := fmt.Sprintf("events.spools[%d]", i)
+		if spool.File == "" {
+			invalid(field+".file", errors.New("a file has to be given"))
+		}
+		validateEventTypes(spool.Events, field+".events", invalid)
+	}
+
+	if c.Timeouts.Idle < 0 || c.Timeouts.DataConnect < 0 || c.Timeouts.Stall < 0 {
+		invalid("timeouts", errors.New("can't be negative, use \"0s\" to disable one"))
+	}
+
+	if c.Drain.GracePeriod < 0 {
+		invalid("drain.grace_period", errors.New("can't be negative"))
+	}
+
+	if c.Admin.Address != "" {
+		if err := validateAddress(c.Admin.Address); err != nil {
+			invalid("admin.address", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// validateFilesystem checks a filesystem serving root when it's on disk
+func validateFilesystem(f Filesystem, root, rootField, field string, invalid func(string, error)) {
+	switch f.Backend {
+	case "os":
+		if info, err := os.Stat(root); err != nil {
+			invalid(rootField, err)
+		} else if !info.IsDir() {
+			invalid(rootField, fmt.Errorf("%s is not a directory", root))
+		}
+	case "memory":
+	case "s3":
+		if _, err := newFilesystem(f, root); err != nil {
+			invalid(field+".s3", err)
+		}
+	default:
+		invalid(field+".backend", fmt.Errorf("%q is not one of os, memory, s3", f.Backend))
+	}
+}
+
+func validateEventTypes(types []string, field string, invalid func(string, error)) {
+	for _, t := range types {
+		if !slices.Contains(events.Types, events.Type(t)) {
+			invalid(field, fmt.Errorf("%q is not one of %v", t, events.Types))
+		}
+	}
+}
+
+func validateAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return err
+	}
+
+	if host != "" {
+		if _, err := netip.ParseAddr(host); err != nil {
+			return fmt.Errorf("%q is not an IP address", host)
+		}
+	}
+
+	return nil
+}
+
+// Users builds the accounts of the configured auth backend
+func (c *Config) Users() ([]*auth.User, error) {
+	users := c.Auth.Users
+	switch c.Auth.Backend {
+	case "config":
+	case "file":
+		b, err := os.ReadFile(c.Auth.File)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&users); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Auth.File, err)
+		}
+	default:
+		return nil, fmt.Errorf("backend %q is not one of config, file", c.Auth.Backend)
+	}
+
+	if len(users) == 0 {
+		return nil, errors.New("no users configured")
+	}
+
+	seen := make(map[string]struct{})
+	var accounts []*auth.User
+	for _, user := range users {
+		if user.Name == "" {
+			return nil, errors.New("users need a name")
+		}
+		if _, ok := seen[user.Name]; ok {
+			return nil, fmt.Errorf("user %q is configured more than once", user.Name)
+		}
+		seen[user.Name] = struct{}{}
+
+		perms, err := auth.ParsePermissions(user.Permissions)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", user.Name, err)
+		}
+
+		var paths map[string]auth.Permission
+		for dir, names := range user.Paths {
+			if paths == nil {
+				paths = make(map[string]auth.Permission)
+			}
+			if paths[dir], err = auth.ParsePermissions(names); err != nil {
+				return nil, fmt.Errorf("user %q, path %s: %w", user.Name, dir, err)
+			}
+		}
+
+		accounts = append(accounts, &auth.User{
+			Name:        user.Name,
+			Password:    user.Password,
+			Permissions: perms,
+			Paths:       paths,
+		})
+	}
+
+	return accounts, nil
+}
+
+// TLSConfig loads the certificate, it's nil when TLS isn't configured
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.TLS.CertFile == "" && c.TLS.KeyFile == "" {
+		return nil, nil
+	}
+
+	if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+		return nil, errors.New("both cert_file and key_file are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (c *Config) DataPolicy() (worker.DataPolicy, error) {
+	policy := worker.DataPolicy{
+		DenyPrivilegedPorts: c.Active.DenyPrivilegedPorts,
+		AllowForeignPassive: c.Passive.AllowForeign,
+	}
+
+	for _, s := range c.Active.AllowList {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return policy, err
+		}
+		policy.ActiveAllowList = append(policy.ActiveAllowList, prefix)
+	}
+
+	return policy, nil
+}
+
+func (c *Config) GuardConfig() guard.Config {
+	return guard.Config{
+		MaxSessionAttempts: c.Guard.MaxSessionAttempts,
+		MaxAttempts:        c.Guard.MaxAttempts,
+		Window:             time.Duration(c.Guard.Window),
+		BanDuration:        time.Duration(c.Guard.BanDuration),
+		BaseDelay:          time.Duration(c.Guard.BaseDelay),
+		MaxDelay:           time.Duration(c.Guard.MaxDelay),
+	}
+}
+
+func (c *Config) LimitsConfig() limits.Config {
+	return limits.Config{
+		MaxSessions:      c.Limits.MaxSessions,
+		MaxSessionsPerIP: c.Limits.MaxSessionsPerIP,
+		MaxLoginsPerUser: c.Limits.MaxLoginsPerUser,
+	}
+}
+
+// NewFilesystem builds the configured filesystem along with its mounts, a memory
+// filesystem starts off empty
+func (c *Config) NewFilesystem() (vfs.Filesystem, error) {
+	root, err := newFilesystem(c.Filesystem, c.Root)
+	if err != nil || len(c.Mounts) == 0 {
+		return root, err
+	}
+
+	mounts := []vfs.Mount{{Path: "/", FS: root}}
+	for _, mount := range c.Mounts {
+		fs, err := newFilesystem(mount.Filesystem, mount.Root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", mount.Path, err)
+		}
+		mounts = append(mounts, vfs.Mount{Path: mount.Path, FS: fs, ReadOnly: mount.ReadOnly})
+	}
+
+	return vfs.NewMounts(mounts...)
+}
+
+func newFilesystem(f Filesystem, root string) (vfs.Filesystem, error) {
+	switch f.Backend {
+	case "memory":
+		return vfs.NewMemory(), nil
+	case "s3":
+		return vfs.NewS3(vfs.S3Config{
+			Endpoint:  f.S3.Endpoint,
+			Region:    f.S3.Region,
+			Bucket:    f.S3.Bucket,
+			Prefix:    f.S3.Prefix,
+			AccessKey: f.S3.AccessKey,
+			SecretKey: f.S3.SecretKey,
+			PartSize:  f.S3.PartSize,
+		})
+	}
+
+	return vfs.NewOS(root), nil
+}
+
+func (c *Config) QuotaConfig() quota.Config {
+	config := quota.Config{
+		Users:    make(map[string]quota.Limit, len(c.Quotas.Users)),
+		Paths:    make(map[string]quota.Limit, len(c.Quotas.Paths)),
+		Interval: time.Duration(c.Quotas.Interval),
+	}
+	for name, q := range c.Quotas.Users {
+		config.Users[name] = quota.Limit{Bytes: q.Bytes, Files: q.Files}
+	}
+	for pth, q := range c.Quotas.Paths {
+		config.Paths[pth] = quota.Limit{Bytes: q.Bytes, Files: q.Files}
+	}
+
+	return config
+}
+
+func (c *Config) ThrottleConfig() throttle.Config {
+	return throttle.Config{
+		Upload:   throttle.Rates(c.Throttle.Upload),
+		Download: throttle.Rates(c.Throttle.Download),
+	}
+}
+
+// EventSubscriptions builds a Subscription for every command, webhook and spool file events are handed to
+func (c *Config) EventSubscriptions() []events.Subscription {
+	var subscriptions []events.Subscription
+	subscribe := func(s events.Subscriber, names []string) {
+		var types []events.Type
+		for _, name := range names {
+			types = append(types, events.Type(name))
+		}
+		subscriptions = append(subscriptions, events.Subscription{Subscriber: s, Types: types})
+	}
+
+	for _, cmd := range c.Events.Commands {
+		timeout := time.Duration(cmd.Timeout)
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+		subscribe(&events.Command{Args: cmd.Command, Timeout: timeout}, cmd.Events)
+	}
+	for _, hook := range c.Events.Webhooks {
+		timeout := time.Duration(hook.Timeout)
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		subscribe(&events.Webhook{URL: hook.URL, Timeout: timeout}, hook.Events)
+	}
+	for _, spool := range c.Events.Spools {
+		subscribe(&events.Spool{File: spool.File}, spool.Events)
+	}
+
+	return subscriptions
+}
+
+func (c *Config) WorkerTimeouts() worker.Timeouts {
+	return worker.Timeouts{
+		Idle:        time.Duration(c.Timeouts.Idle),
+		DataConnect: time.Duration(c.Timeouts.DataConnect),
+		Stall:       time.Duration(c.Timeouts.Stall),
+	}
+}
+
+func (c *Config) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.Log.Level))
+	return level, err
+}
@@ -0,0 +1,363 @@
+package config
+
+import (
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/vfs"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupRoot runs the test from an empty directory holding the default root
+func setupRoot(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("temp", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	if err := os.WriteFile(name, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func environment(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	setupRoot(t)
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	setupRoot(t)
+	pth := writeFile(t, "goftp.json", `{
+		"listeners": [{"address": "127.0.0.1:2121", "masquerade": "203.0.113.7"}],
+		"guard": {"ban_duration": "1h"},
+		"auth": {"users": [{"name": "anonymous", "permissions": ["readonly"], "paths": {"/incoming": ["dropbox"]}}]}
+	}`)
+
+	c, err := Load(pth)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if c.Listeners[0].Address != "127.0.0.1:2121" || c.Listeners[0].Masquerade != "203.0.113.7" {
+		t.Errorf("Expected listener from file, but got %+v", c.Listeners)
+	}
+
+	if time.Duration(c.Guard.BanDuration) != time.Hour {
+		t.Errorf("Expected: %v, but got %v", time.Hour, time.Duration(c.Guard.BanDuration))
+	}
+
+	// left out of the file, keeps its default
+	if c.Guard.MaxAttempts != 10 {
+		t.Errorf("Expected: %d, but got %d", 10, c.Guard.MaxAttempts)
+	}
+
+	users, err := c.Users()
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if len(users) != 1 || users[0].Can(auth.Download, "/incoming") || !users[0].Can(auth.Upload, "/incoming/file") {
+		t.Errorf("Expected anonymous to only be able to upload to /incoming, but got %+v", users)
+	}
+}
+
+var loadErrorTestCases = []struct {
+	TestName string
+	File     string
+	Contents string
+	Expected string
+}{
+	{
+		TestName: "Test_Yaml_Rejected",
+		File:     "goftp.yaml",
+		Contents: "root: /srv",
+		Expected: "has to be JSON",
+	},
+	{
+		TestName: "Test_Unknown_Field",
+		File:     "goftp.json",
+		Contents: `{"rot": "/srv"}`,
+		Expected: `unknown field "rot"`,
+	},
+	{
+		TestName: "Test_Bad_Duration",
+		File:     "goftp.json",
+		Contents: `{"guard": {"window": "10 minutes"}}`,
+		Expected: "10 minutes",
+	},
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, testcase := range loadErrorTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			_, err := Load(writeFile(t, testcase.File, testcase.Contents))
+			if err == nil || !strings.Contains(err.Error(), testcase.Expected) {
+				t.Errorf("Expected error containing %q, but got %v", testcase.Expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	setupRoot(t)
+
+	c := Default()
+	c.Listeners = []Listener{{Address: "2023"}, {Address: ":990", TLS: true}}
+	c.Root = "missing"
+	c.Passive = Passive{MinPort: 50100, MaxPort: 50000}
+	c.Auth.Users[0].Permissions = []string{"chmod"}
+	c.Log.Level = "loud"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected an error, but got nil")
+	}
+
+	for _, field := range []string{"listeners[0].address", "listeners[1].tls", "root", "passive", "auth", "log.level"} {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("Expected an error for %s, but got %v", field, err)
+		}
+	}
+}
+
+func is[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
+
+func TestFilesystem(t *testing.T) {
+	setupRoot(t)
+
+	c := Default()
+	if fs, err := c.NewFilesystem(); err != nil || !is[*vfs.OS](fs) {
+		t.Errorf("Expected the os filesystem by default, but got %T (%v)", fs, err)
+	}
+
+	// a memory filesystem doesn't need the root to exist
+	c.Filesystem.Backend = "memory"
+	c.Root = "missing"
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if fs, _ := c.NewFilesystem(); !is[*vfs.Memory](fs) {
+		t.Errorf("Expected a memory filesystem, but got %T", fs)
+	}
+
+	c.Filesystem.Backend = "s3"
+	c.Filesystem.S3 = S3{Endpoint: "http://127.0.0.1:9000", Bucket: "ftp"}
+	if fs, err := c.NewFilesystem(); err != nil || !is[*vfs.S3](fs) {
+		t.Errorf("Expected an s3 filesystem, but got %T (%v)", fs, err)
+	}
+
+	c.Filesystem.S3.Bucket = ""
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "filesystem.s3:") {
+		t.Errorf("Expected an error for filesystem.s3, but got %v", err)
+	}
+
+	c.Filesystem.Backend = "tape"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "filesystem.backend:") {
+		t.Errorf("Expected an error for filesystem.backend, but got %v", err)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	setupRoot(t)
+
+	c := Default()
+	c.Mounts = []Mount{
+		{Path: "/public", ReadOnly: true, Root: "temp", Filesystem: Filesystem{Backend: "os"}},
+		{Path: "/incoming", Filesystem: Filesystem{Backend: "memory"}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if fs, err := c.NewFilesystem(); err != nil || !is[*vfs.Mounts](fs) {
+		t.Errorf("Expected mounts, but got %T (%v)", fs, err)
+	}
+
+	c.Mounts = append(c.Mounts,
+		Mount{Path: "/incoming", Filesystem: Filesystem{Backend: "memory"}},
+		Mount{Path: "/", Filesystem: Filesystem{Backend: "memory"}},
+		Mount{Path: "archive", Filesystem: Filesystem{Backend: "memory"}},
+		Mount{Path: "/archive", Root: "missing", Filesystem: Filesystem{Backend: "os"}},
+	)
+	err := c.Validate()
+	for _, field := range []string{"mounts[2].path:", "mounts[3].path:", "mounts[4].path:", "mounts[5].root:"} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected an error for %s, but got %v", field, err)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	setupRoot(t)
+
+	c := Default()
+	c.Quotas.Users = map[string]Quota{"partner": {Bytes: 1 << 30}, "broken": {Files: -1}}
+	c.Quotas.Paths = map[string]Quota{"/incoming": {Files: 100}, "incoming/": {Bytes: 1}}
+
+	err := c.Validate()
+	for _, field := range []string{`quotas.users["broken"]:`, `quotas.paths["incoming/"]:`} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected an error for %s, but got %v", field, err)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), `"partner"`) {
+		t.Errorf("Expected partner's quota to be valid, but got %v", err)
+	}
+
+	config := c.QuotaConfig()
+	if config.Users["partner"].Bytes != 1<<30 || config.Paths["/incoming"].Files != 100 || config.Interval != 10*time.Minute {
+		t.Errorf("Expected the quotas to carry over, but got %+v", config)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	setupRoot(t)
+
+	c := Default()
+	c.Events.Commands = []EventCommand{{Command: []string{"/usr/local/bin/ingest"}, Events: []string{"upload.complete"}}, {}}
+	c.Events.Webhooks = []EventWebhook{{URL: "https://hooks.example.com/ftp", Timeout: Duration(time.Second)}, {URL: "ftp://example.com"}}
+	c.Events.Spools = []EventSpool{{File: "events.jsonl", Events: []string{"uploaded"}}}
+
+	err := c.Validate()
+	for _, field := range []string{"events.commands[1].command:", "events.webhooks[1].url:", "events.spools[0].events:"} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected an error for %s, but got %v", field, err)
+		}
+	}
+	for _, field := range []string{"events.commands[0]", "events.webhooks[0]", "events.spools[0].file"} {
+		if err != nil && strings.Contains(err.Error(), field) {
+			t.Errorf("Expected %s to be valid, but got %v", field, err)
+		}
+	}
+
+	subscriptions := c.EventSubscriptions()
+	if len(subscriptions) != 5 {
+		t.Fatalf("Expected: 5 subscriptions, but got %d", len(subscriptions))
+	}
+	if cmd, ok := subscriptions[0].Subscriber.(*events.Command); !ok || cmd.Timeout != 30*time.Second ||
+		len(subscriptions[0].Types) != 1 || subscriptions[0].Types[0] != events.UploadComplete {
+		t.Errorf("Expected the command to carry over with the default timeout, but got %+v", subscriptions[0])
+	}
+	if hook, ok := subscriptions[2].Subscriber.(*events.Webhook); !ok || hook.Timeout != time.Second {
+		t.Errorf("Expected the webhook to carry over, but got %+v", subscriptions[2])
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	setupRoot(t)
+	if err := os.Mkdir("srv", 0755); err != nil {
+		t.Fatal(err)
+	}
+	pth := writeFile(t, "goftp.json", `{"root": "missing", "limits": {"max_sessions": 1}, "log": {"level": "debug"}}`)
+
+	c, err := Parse(
+		[]string{"-root", "srv", "-listen", ":2121", "-masquerade", "203.0.113.7"},
+		environment(map[string]string{
+			"GOFTP_CONFIG":        pth,
+			"GOFTP_ROOT":          "temp",
+			"GOFTP_MAX_SESSIONS":  "2",
+			"GOFTP_PASSIVE_PORTS": "40000-40010",
+		}),
+		io.Discard,
+	)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if c.Root != "srv" {
+		t.Errorf("Expected flag to win, but got root %s", c.Root)
+	}
+
+	if c.Limits.MaxSessions != 2 {
+		t.Errorf("Expected environment to win over file, but got %d", c.Limits.MaxSessions)
+	}
+
+	if c.Log.Level != "debug" {
+		t.Errorf("Expected file to win over defaults, but got %s", c.Log.Level)
+	}
+
+	if c.Passive.MinPort != 40000 || c.Passive.MaxPort != 40010 {
+		t.Errorf("Expected: 40000-40010, but got %d-%d", c.Passive.MinPort, c.Passive.MaxPort)
+	}
+
+	if len(c.Listeners) != 1 || c.Listeners[0] != (Listener{Address: ":2121", Masquerade: "203.0.113.7"}) {
+		t.Errorf("Expected a single masqueraded listener, but got %+v", c.Listeners)
+	}
+}
+
+func TestParseListeners(t *testing.T) {
+	file := []Listener{{Address: ":21"}, {Address: ":990", TLS: true, Masquerade: "198.51.100.1"}}
+
+	for _, testcase := range []struct {
+		TestName string
+		Settings [][2]string
+		Expected []Listener
+	}{
+		{"none", nil, file},
+		{"listen", [][2]string{{"listen", ":2121"}}, []Listener{{Address: ":2121"}, file[1]}},
+		{"tls-listen", [][2]string{{"tls-listen", ":9990"}}, []Listener{file[0], {Address: ":9990", TLS: true}}},
+		{"either order", [][2]string{{"tls-listen", ":9990"}, {"listen", ":2121, :2122"}},
+			[]Listener{{Address: ":2121"}, {Address: ":2122"}, {Address: ":9990", TLS: true}}},
+		{"environment then flag", [][2]string{{"listen", ":2121"}, {"tls-listen", ":9990"}, {"listen", ":2122"}},
+			[]Listener{{Address: ":2122"}, {Address: ":9990", TLS: true}}},
+		{"tls only", [][2]string{{"listen", ""}, {"tls-listen", ":9990"}}, []Listener{{Address: ":9990", TLS: true}}},
+		{"masquerade first", [][2]string{{"masquerade", "203.0.113.7"}, {"listen", ":2121"}},
+			[]Listener{{Address: ":2121", Masquerade: "203.0.113.7"}, {Address: ":990", TLS: true, Masquerade: "203.0.113.7"}}},
+	} {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			o := &overrides{Config: &Config{Listeners: slices.Clone(file)}}
+			for _, setting := range testcase.Settings {
+				for _, s := range settings {
+					if s.name == setting[0] {
+						s.apply(o, setting[1])
+					}
+				}
+			}
+			o.listeners()
+
+			if !slices.Equal(o.Listeners, testcase.Expected) {
+				t.Errorf("Expected: %+v, but got %+v", testcase.Expected, o.Listeners)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	setupRoot(t)
+
+	if _, err := Parse(nil, environment(map[string]string{"GOFTP_MAX_SESSIONS": "many"}), io.Discard); err == nil ||
+		!strings.Contains(err.Error(), "GOFTP_MAX_SESSIONS") {
+		t.Errorf("Expected an error naming GOFTP_MAX_SESSIONS, but got %v", err)
+	}
+
+	if _, err := Parse([]string{"-passive-ports", "50000"}, environment(nil), io.Discard); err == nil ||
+		!strings.Contains(err.Error(), "-passive-ports") {
+		t.Errorf("Expected an error naming -passive-ports, but got %v", err)
+	}
+
+	if _, err := Parse([]string{"-auth-file", filepath.Join("missing", "users.json")}, environment(nil), io.Discard); err == nil ||
+		!strings.Contains(err.Error(), "auth:") {
+		t.Errorf("Expected an auth error, but got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a single value that can be overridden from the environment, as
+// GOFTP_<NAME> with dashes turned into underscores, and from the command line as -<name>
+type setting struct {
+	name  string
+	usage string
+	apply func(c *overrides, value string) error
+}
+
+// overrides is the configuration settings are applied to, along with the settings
+// that make up the listeners, which are only built once every one of them is known
+type overrides struct {
+	*Config
+
+	// nil unless given, each replaces the listeners of its kind and leaves the others be
+	listen, tlsListen []string
+	masquerade        *string
+}
+
+var settings = []setting{
+	{"listen", "comma separated addresses to accept control connections on, e.g. :2023", func(c *overrides, v string) error {
+		c.listen = append([]string{}, split(v)...)
+		return nil
+	}},
+	{"tls-listen", "comma separated addresses to accept implicit TLS control connections on, e.g. :990", func(c *overrides, v string) error {
+		c.tlsListen = append([]string{}, split(v)...)
+		return nil
+	}},
+	{"masquerade", "address advertised in PASV replies on every listener", func(c *overrides, v string) error {
+		c.masquerade = &v
+		return nil
+	}},
+	{"root", "directory served as /", func(c *overrides, v string) error {
+		c.Root = v
+		return nil
+	}},
+	{"filesystem", "where files are stored, one of os, memory, s3", func(c *overrides, v string) error {
+		c.Filesystem.Backend = v
+		return nil
+	}},
+	{"s3-endpoint", "URL of the S3 compatible service, e.g. https://s3.us-east-1.amazonaws.com", func(c *overrides, v string) error {
+		c.Filesystem.S3.Endpoint = v
+		return nil
+	}},
+	{"s3-region", "region requests to the S3 service are signed for", func(c *overrides, v string) error {
+		c.Filesystem.S3.Region = v
+		return nil
+	}},
+	{"s3-bucket", "bucket served when the filesystem is s3", func(c *overrides, v string) error {
+		c.Filesystem.S3.Bucket = v
+		return nil
+	}},
+	{"s3-prefix", "key prefix served as /", func(c *overrides, v string) error {
+		c.Filesystem.S3.Prefix = v
+		return nil
+	}},
+	{"s3-access-key", "access key of the S3 service", func(c *overrides, v string) error {
+		c.Filesystem.S3.AccessKey = v
+		return nil
+	}},
+	{"s3-secret-key", "secret key of the S3 service, prefer the environment over the command line", func(c *overrides, v string) error {
+		c.Filesystem.S3.SecretKey = v
+		return nil
+	}},
+	{"banner", "text clients are greeted with", func(c *overrides, v string) error {
+		c.Banner = v
+		return nil
+	}},
+	{"passive-ports", "range passive data connections are opened on, e.g. 50000-50100", func(c *overrides, v string) error {
+		min, max, ok := strings.Cut(v, "-")
+		if !ok {
+			return fmt.Errorf("%q is not a range such as 50000-50100", v)
+		}
+		lo, err := strconv.ParseUint(min, 10, 16)
+		if err != nil {
+			return err
+		}
+		hi, err := strconv.ParseUint(max, 10, 16)
+		if err != nil {
+			return err
+		}
+		c.Passive.MinPort, c.Passive.MaxPort = uint16(lo), uint16(hi)
+		return nil
+	}},
+	{"tls-cert", "PEM certificate used by TLS listeners", func(c *overrides, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key", "PEM private key used by TLS listeners", func(c *overrides, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"auth-file", "JSON file of users, replaces the users of the configuration file", func(c *overrides, v string) error {
+		c.Auth.Backend, c.Auth.File = "file", v
+		return nil
+	}},
+	{"max-sessions", "concurrent control connections, 0 for no limit", integer(func(c *Config) *int { return &c.Limits.MaxSessions })},
+	{"max-sessions-per-ip", "concurrent control connections from a single address, 0 for no limit", integer(func(c *Config) *int { return &c.Limits.MaxSessionsPerIP })},
+	{"max-logins-per-user", "concurrent logins of a single user, 0 for no limit", integer(func(c *Config) *int { return &c.Limits.MaxLoginsPerUser })},
+	{"log-level", "one of debug, info, warn, error", func(c *overrides, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"log-format", "one of json, text", func(c *overrides, v string) error {
+		c.Log.Format = v
+		return nil
+	}},
+	{"grace-period", "how long transfers get to complete when draining, e.g. 5m", duration(func(c *Config) *Duration { return &c.Drain.GracePeriod })},
+	{"idle-timeout", "how long the control connection may go without a command, 0s disables it", duration(func(c *Config) *Duration { return &c.Timeouts.Idle })},
+	{"data-connect-timeout", "how long after PASV/PORT the data connection has to be used, 0s disables it", duration(func(c *Config) *Duration { return &c.Timeouts.DataConnect })},
+	{"stall-timeout", "how long a transfer may go without data moving, 0s disables it", duration(func(c *Config) *Duration { return &c.Timeouts.Stall })},
+	{"atomic-uploads", "write uploads to a hidden file that's renamed into place once complete, true or false", func(c *overrides, v string) error {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Uploads.Atomic = on
+		return nil
+	}},
+	{"quota-interval", "how often quota usage is recomputed from the filesystem, 0s disables it", duration(func(c *Config) *Duration { return &c.Quotas.Interval })},
+	{"admin", "address of the admin interface, empty to disable it", func(c *overrides, v string) error {
+		c.Admin.Address = v
+		return nil
+	}},
+}
+
+func integer(field func(*Config) *int) func(*overrides, string) error {
+	return func(c *overrides, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c.Config) = n
+		return nil
+	}
+}
+
+func duration(field func(*Config) *Duration) func(*overrides, string) error {
+	return func(c *overrides, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c.Config) = Duration(d)
+		return nil
+	}
+}
+
+// listeners replaces the plain and TLS listeners with those of -listen and -tls-listen, whichever
+// were given, regardless of the order they were given in, then masquerades every one of them
+func (c *overrides) listeners() {
+	if c.listen != nil || c.tlsListen != nil {
+		var listeners []Listener
+		for _, tls := range []bool{false, true} {
+			addrs := c.listen
+			if tls {
+				addrs = c.tlsListen
+			}
+
+			if addrs == nil {
+				for _, listener := range c.Listeners {
+					if listener.TLS == tls {
+						listeners = append(listeners, listener)
+					}
+				}
+				continue
+			}
+			for _, addr := range addrs {
+				listeners = append(listeners, Listener{Address: addr, TLS: tls})
+			}
+		}
+		c.Listeners = listeners
+	}
+
+	if c.masquerade != nil {
+		for i := range c.Listeners {
+			c.Listeners[i].Masquerade = *c.masquerade
+		}
+	}
+}
+
+func split(v string) []string {
+	var values []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func env(name string) string {
+	return "GOFTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Parse builds the configuration from the command line arguments (without the program name)
+// and the environment, later sources win: defaults, the -config file, GOFTP_* variables then flags
+func Parse(args []string, lookup func(string) (string, bool), output io.Writer) (*Config, error) {
+	flags := flag.NewFlagSet("goftp", flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	path := flags.String("config", "", "JSON configuration file, also read from "+env("config"))
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.name] = flags.String(s.name, "", fmt.Sprintf("%s (%s)", s.usage, env(s.name)))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	if *path == "" {
+		*path, _ = lookup(env("config"))
+	}
+
+	c := Default()
+	if *path != "" {
+		var err error
+		if c, err = Load(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	o := &overrides{Config: c}
+	for _, s := range settings {
+		if v, ok := lookup(env(s.name)); ok {
+			if err := s.apply(o, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env(s.name), err)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range settings {
+		if set[s.name] {
+			if err := s.apply(o, *values[s.name]); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.name, err)
+			}
+		}
+	}
+	o.listeners()
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
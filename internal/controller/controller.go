@@ -1,44 +1,378 @@
 package controller
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"goftp/internal/admin"
+	"goftp/internal/auth"
+	"goftp/internal/config"
 	"goftp/internal/dispatcher"
+	"goftp/internal/events"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
 	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
+	"goftp/internal/worker"
+	"log"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
 	"sync"
+	"time"
 )
 
-var once sync.Once
-var goFtp *GoFTP
-
 type GoFTP struct {
 	logger     logger.Client
+	guard      *guard.Guard
+	limits     *limits.Tracker
+	quotas     *quota.Tracker
+	throttle   *throttle.Throttle
+	events     *events.Bus
 	dispatcher *dispatcher.Dispatcher
+
+	// level of the logger unless one was injected, changed by Reload
+	level *slog.LevelVar
+
+	// who can log in, users is nil when an Authenticator was injected
+	// in place of the configured users, which are then left alone
+	auth  auth.Authenticator
+	users *auth.Store
+
+	// applied to every session on top of the built in behaviour
+	middleware []worker.Middleware
+	commands   *worker.Commands
+
+	// files served to clients, kept across reloads unless the configured
+	// filesystem changes, injected ones are always kept
+	fs       vfs.Filesystem
+	injected bool
+
+	// nil when the admin interface is disabled
+	admin *admin.Admin
+
+	// configuration currently applied, replaced by Reload
+	mutex  sync.Mutex
+	config *config.Config
+
+	// quotas and the admin interface are started along with the first listener
+	starting sync.Once
+
+	// closed once Drain has completed
+	draining sync.Once
+	drained  chan struct{}
 }
 
-func NewGoFTP() *GoFTP {
-	once.Do(func() {
-		logger := logger.NewStdStreamClient()
+// settings is what's derived from a config.Config before any of it is applied,
+// so that a configuration is either applied as a whole or not at all
+type settings struct {
+	level     slog.Level
+	users     []*auth.User
+	policy    worker.DataPolicy
+	tls       *tls.Config
+	listeners []dispatcher.Listener
+}
+
+func prepare(cfg *config.Config) (*settings, error) {
+	var s settings
+	var err error
+
+	if s.level, err = cfg.LogLevel(); err != nil {
+		return nil, err
+	}
+
+	if s.users, err = cfg.Users(); err != nil {
+		return nil, err
+	}
 
-		goFtp = &GoFTP{
-			logger: logger,
-			dispatcher: dispatcher.New(
-				dispatcher.WithLogger(logger),
-				dispatcher.WithPort(2023),
-			),
+	if s.policy, err = cfg.DataPolicy(); err != nil {
+		return nil, err
+	}
+
+	if s.tls, err = cfg.TLSConfig(); err != nil {
+		return nil, err
+	}
+
+	for _, listener := range cfg.Listeners {
+		var masquerade netip.Addr
+		if listener.Masquerade != "" {
+			if masquerade, err = netip.ParseAddr(listener.Masquerade); err != nil {
+				return nil, err
+			}
 		}
-	})
 
-	return goFtp
+		s.listeners = append(s.listeners, dispatcher.Listener{
+			Address:    listener.Address,
+			Masquerade: masquerade,
+			TLS:        listener.TLS,
+		})
+	}
+
+	return &s, nil
+}
+
+// WithFilesystem serves fs in place of the configured filesystem
+func WithFilesystem(fs vfs.Filesystem) func(*GoFTP) {
+	return func(g *GoFTP) {
+		g.fs = fs
+		g.injected = true
+	}
+}
+
+// WithAuthenticator verifies credentials with a in place of the configured users
+func WithAuthenticator(a auth.Authenticator) func(*GoFTP) {
+	return func(g *GoFTP) {
+		g.auth = a
+	}
+}
+
+// WithLogger logs to l in place of stdout, the configured level and format are left to l
+func WithLogger(l logger.Client) func(*GoFTP) {
+	return func(g *GoFTP) {
+		g.logger = l
+	}
+}
+
+// WithMiddleware runs every request through middleware, see worker.Middleware
+func WithMiddleware(middleware ...worker.Middleware) func(*GoFTP) {
+	return func(g *GoFTP) {
+		g.middleware = append(g.middleware, middleware...)
+	}
+}
+
+// WithCommands replaces the commands clients can send, see worker.DefaultCommands
+func WithCommands(commands *worker.Commands) func(*GoFTP) {
+	return func(g *GoFTP) {
+		g.commands = commands
+	}
+}
+
+type Options func(*GoFTP)
+
+// NewGoFTP wires up the server as described by cfg, which is expected to have been validated,
+// every call returns a server of its own so that several can run side by side
+func NewGoFTP(cfg *config.Config, options ...Options) (*GoFTP, error) {
+	s, err := prepare(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GoFTP{
+		level:   new(slog.LevelVar),
+		config:  cfg,
+		drained: make(chan struct{}),
+	}
+	for _, option := range options {
+		option(g)
+	}
+
+	g.level.Set(s.level)
+	if g.logger == nil {
+		g.logger = logger.NewStdStream(g.level, cfg.Log.Format)
+	}
+	if g.commands == nil {
+		g.commands = worker.DefaultCommands()
+	}
+	if g.auth == nil {
+		g.users = auth.NewStore(s.users...)
+		g.auth = g.users
+	}
+	if g.fs == nil {
+		if g.fs, err = cfg.NewFilesystem(); err != nil {
+			return nil, err
+		}
+	}
+
+	g.guard = guard.New(cfg.GuardConfig())
+	g.limits = limits.New(cfg.LimitsConfig())
+	g.quotas = quota.New(cfg.QuotaConfig(), g.fs)
+	g.throttle = throttle.New(cfg.ThrottleConfig())
+	g.events = events.New(g.logger, cfg.EventSubscriptions()...)
+
+	dispatcherOptions := []dispatcher.Options{
+		dispatcher.WithLogger(g.logger),
+		dispatcher.WithAuthenticator(g.auth),
+		dispatcher.WithGuard(g.guard),
+		dispatcher.WithLimits(g.limits),
+		dispatcher.WithQuotas(g.quotas),
+		dispatcher.WithThrottle(g.throttle),
+		dispatcher.WithEvents(g.events),
+		dispatcher.WithMiddleware(g.middleware...),
+		dispatcher.WithCommands(g.commands),
+		dispatcher.WithDataPolicy(s.policy),
+		dispatcher.WithPassivePorts(cfg.Passive.MinPort, cfg.Passive.MaxPort),
+		dispatcher.WithFilesystem(g.fs),
+		dispatcher.WithBanner(cfg.Banner),
+		dispatcher.WithTimeouts(cfg.WorkerTimeouts()),
+		dispatcher.WithAtomicUploads(cfg.Uploads.Atomic),
+		dispatcher.WithTLS(s.tls),
+	}
+	for _, listener := range s.listeners {
+		dispatcherOptions = append(dispatcherOptions, dispatcher.WithListener(listener))
+	}
+	g.dispatcher = dispatcher.New(dispatcherOptions...)
+
+	if cfg.Admin.Address != "" {
+		g.admin = admin.New(
+			admin.WithLogger(g.logger),
+			admin.WithAddress(cfg.Admin.Address),
+			admin.WithGuard(g.guard),
+			admin.WithLimits(g.limits),
+			admin.WithThrottle(g.throttle),
+			admin.WithDrainer(g),
+		)
+	}
+
+	return g, nil
+}
+
+// Reload applies cfg to the running server without dropping sessions, users, permissions, limits,
+// quotas, transfer rates, event subscribers and the log level change straight away, while listeners,
+// the banner and data connection settings apply to connections accepted from here on
+//
+// when cfg can't be applied the running configuration is kept, the admin interface
+// and the log format are only read at startup
+func (g *GoFTP) Reload(cfg *config.Config) error {
+	s, err := prepare(cfg)
+	if err != nil {
+		return fmt.Errorf("rejected, keeping the running configuration: %w", err)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// a memory filesystem is only started over when switching to it, or when the mounts change
+	if !g.injected && (cfg.Filesystem != g.config.Filesystem || !slices.Equal(cfg.Mounts, g.config.Mounts) ||
+		(cfg.Filesystem.Backend == "os" && cfg.Root != g.config.Root)) {
+		fs, err := cfg.NewFilesystem()
+		if err != nil {
+			return fmt.Errorf("rejected, keeping the running configuration: %w", err)
+		}
+		g.fs = fs
+		g.quotas.SetFilesystem(fs)
+	}
+
+	g.level.Set(s.level)
+	if g.users != nil {
+		g.users.Replace(s.users...)
+	}
+	g.guard.SetConfig(cfg.GuardConfig())
+	g.limits.SetConfig(cfg.LimitsConfig())
+	g.quotas.SetConfig(cfg.QuotaConfig())
+	g.throttle.SetConfig(cfg.ThrottleConfig())
+	g.events.SetSubscriptions(cfg.EventSubscriptions()...)
+
+	options := []dispatcher.Options{
+		dispatcher.WithDataPolicy(s.policy),
+		dispatcher.WithFilesystem(g.fs),
+		dispatcher.WithBanner(cfg.Banner),
+		dispatcher.WithTimeouts(cfg.WorkerTimeouts()),
+		dispatcher.WithAtomicUploads(cfg.Uploads.Atomic),
+		dispatcher.WithTLS(s.tls),
+	}
+	// a new pool would hand out ports the current one still has in use
+	if cfg.Passive.MinPort != g.config.Passive.MinPort || cfg.Passive.MaxPort != g.config.Passive.MaxPort {
+		options = append(options, dispatcher.WithPassivePorts(cfg.Passive.MinPort, cfg.Passive.MaxPort))
+	}
+	g.dispatcher.Update(options...)
+
+	if cfg.Admin != g.config.Admin || cfg.Log.Format != g.config.Log.Format {
+		g.logger.Info("Admin interface and log format changes take effect after a restart")
+	}
+
+	g.config = cfg
+	if err := g.dispatcher.Listen(s.listeners...); err != nil {
+		return fmt.Errorf("configuration applied, but not every listener could be opened: %w", err)
+	}
+
+	g.logger.Info("Configuration reloaded")
+	return nil
 }
 
 // Start kicks off more Go routines which are expected to be running until the lifetime of the process
 func (g *GoFTP) Start() {
-	g.logger.Info("Starting up GoFTP...")
-	go g.dispatcher.Start()
+	go func() {
+		if err := g.ListenAndServe(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// ListenAndServe accepts connections on the configured listeners until the server is stopped or drained
+func (g *GoFTP) ListenAndServe() error {
+	g.background()
+	return g.dispatcher.ListenAndServe()
+}
+
+// Serve accepts connections on l until the server is stopped or drained, alongside the configured listeners
+// when ListenAndServe is running as well
+func (g *GoFTP) Serve(l net.Listener) error {
+	g.background()
+	return g.dispatcher.Serve(l)
+}
+
+func (g *GoFTP) background() {
+	g.starting.Do(func() {
+		g.logger.Info("Starting up GoFTP...")
+		go g.quotas.Start()
+		if g.admin != nil {
+			go g.admin.Start()
+		}
+	})
+}
+
+// Drain stops accepting connections and waits up to the configured grace period
+// for transfers that are under way to complete before shutting down, see Drained
+func (g *GoFTP) Drain() {
+	g.mutex.Lock()
+	grace := time.Duration(g.config.Drain.GracePeriod)
+	g.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	g.Shutdown(ctx)
+}
+
+// Shutdown drains the server the way Drain does, for as long as ctx allows rather than the configured
+// grace period, the error is that of ctx when transfers had to be forced closed
+func (g *GoFTP) Shutdown(ctx context.Context) error {
+	var err error
+	g.draining.Do(func() {
+		g.logger.Info("Draining GoFTP...")
+		if g.admin != nil {
+			g.admin.Stop()
+		}
+		err = g.dispatcher.Shutdown(ctx)
+		g.quotas.Stop()
+		g.events.Close()
+		g.logger.Info("GoFTP drained, exiting")
+		close(g.drained)
+	})
+
+	return err
+}
+
+// Logger is where the server logs to
+func (g *GoFTP) Logger() logger.Client {
+	return g.logger
+}
+
+// Drained is closed once Drain has completed, however it was triggered
+func (g *GoFTP) Drained() <-chan struct{} {
+	return g.drained
 }
 
 // Stop will shutdown the service
 func (g *GoFTP) Stop() {
 	g.logger.Info("Shutting down GoFTP...")
+	if g.admin != nil {
+		g.admin.Stop()
+	}
 	g.dispatcher.Stop()
+	g.quotas.Stop()
+	g.events.Close()
 	g.logger.Info("GoFTP shutdown complete, exiting")
 }
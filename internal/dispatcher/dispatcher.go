@@ -2,12 +2,21 @@ package dispatcher
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
 	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
 	"goftp/internal/worker"
 	"log"
 	"net"
+	"net/netip"
 	"strconv"
 	"sync"
 	"time"
@@ -19,93 +28,540 @@ func WithLogger(l logger.Client) func(*Dispatcher) {
 	}
 }
 
+// WithPort listens on every interface, the wildcard address is
+// dual-stack so both IPv4 and IPv6 clients are accepted
 func WithPort(p int) func(*Dispatcher) {
 	return func(d *Dispatcher) {
 		d.port = ":" + strconv.Itoa(p)
 	}
 }
 
+func WithAuthenticator(a auth.Authenticator) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.auth = a
+	}
+}
+
+func WithGuard(g *guard.Guard) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.guard = g
+	}
+}
+
+func WithLimits(t *limits.Tracker) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.limits = t
+	}
+}
+
+func WithDataPolicy(p worker.DataPolicy) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.dataPolicy = p
+	}
+}
+
+// WithPassivePorts restricts passive data connections to the ports within [min, max]
+func WithPassivePorts(min, max uint16) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.ports = worker.NewPortPool(min, max)
+	}
+}
+
+// WithListener accepts control connections on an additional address, when
+// none are given the Dispatcher listens on every interface at WithPort
+func WithListener(l Listener) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.listeners = append(d.listeners, l)
+	}
+}
+
+// WithTLS is the certificate served on listeners marked TLS, and on their data connections
+func WithTLS(cfg *tls.Config) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.tls = cfg
+	}
+}
+
+func WithTimeouts(t worker.Timeouts) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.timeouts = t
+	}
+}
+
+// WithBanner is the text clients are greeted with
+func WithBanner(text string) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.banner = text
+	}
+}
+
+// WithFilesystem serves fs to clients as /
+func WithFilesystem(fs vfs.Filesystem) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.fs = fs
+	}
+}
+
+// WithAtomicUploads decides whether uploads are renamed into place once complete, see worker.WithAtomicUploads
+func WithAtomicUploads(on bool) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.atomicUploads = on
+	}
+}
+
+// WithQuotas counts what sessions store against the quotas of t
+func WithQuotas(t *quota.Tracker) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.quotas = t
+	}
+}
+
+// WithThrottle limits how fast the transfers of every session go
+func WithThrottle(t *throttle.Throttle) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.throttle = t
+	}
+}
+
+// WithEvents raises what every session does on bus
+func WithEvents(bus *events.Bus) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.events = bus
+	}
+}
+
+// WithMiddleware runs every request of every session through middleware, see worker.Middleware
+func WithMiddleware(middleware ...worker.Middleware) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.middleware = append(d.middleware, middleware...)
+	}
+}
+
+// WithCommands replaces the commands clients can send, see worker.DefaultCommands
+func WithCommands(commands *worker.Commands) func(*Dispatcher) {
+	return func(d *Dispatcher) {
+		d.commands = commands
+	}
+}
+
 type Options func(*Dispatcher)
 
+// Listener is an address control connections are accepted on
+type Listener struct {
+	// host:port, an empty host ":2023" listens dual-stack on every interface
+	// while a specific address such as "0.0.0.0:2023" restricts it to that one
+	Address string
+
+	// advertised in PASV replies in place of the address the client
+	// connected to, for listeners that are reached through NAT
+	Masquerade netip.Addr
+
+	// implicit TLS, sessions are secured before the greeting is sent
+	TLS bool
+}
+
 // Dispatcher will handle all control connections initiated against the FTP Server
 type Dispatcher struct {
 	logger   logger.Client
-	server   net.Listener
-	port     string
-	shutdown context.CancelFunc
-	wg       *sync.WaitGroup
+	auth     auth.Authenticator
+	guard    *guard.Guard
+	limits   *limits.Tracker
+	quotas   *quota.Tracker
+	throttle *throttle.Throttle
+	events   *events.Bus
+
+	// runs after the built in middleware on every request
+	middleware []worker.Middleware
+
+	// commands clients can send, shared across every session of the dispatcher
+	commands *worker.Commands
+
+	// guards everything below, which can be changed while running through Listen and Update
+	mutex sync.Mutex
+
+	listeners []Listener
+	servers   map[Listener]net.Listener
+
+	dataPolicy    worker.DataPolicy
+	ports         *worker.PortPool
+	tls           *tls.Config
+	fs            vfs.Filesystem
+	atomicUploads bool
+	banner        string
+	timeouts      worker.Timeouts
+	port          string
+
+	// sessions run under ctx, listeners are only opened once started
+	ctx       context.Context
+	shutdown  context.CancelFunc
+	started   bool
+	closed    bool
+	served    map[net.Listener]struct{}
+	accepting *sync.WaitGroup
+	wg        *sync.WaitGroup
+
+	// closed once by Drain, tells sessions to wind down
+	drain    chan struct{}
+	draining sync.Once
 }
 
+const stopTimeout = 5 * time.Second
+
 func New(options ...Options) *Dispatcher {
 	d := &Dispatcher{
-		logger: logger.NewStdStreamClient(),
-		wg:     new(sync.WaitGroup),
-		port:   ":2023",
+		logger:        logger.NewStdStreamClient(),
+		guard:         guard.New(guard.Config{}),
+		limits:        limits.New(limits.Config{}),
+		ports:         worker.NewPortPool(0, 0),
+		atomicUploads: true,
+		timeouts:      worker.DefaultTimeouts,
+		servers:       make(map[Listener]net.Listener),
+		served:        make(map[net.Listener]struct{}),
+		accepting:     new(sync.WaitGroup),
+		wg:            new(sync.WaitGroup),
+		drain:         make(chan struct{}),
+		port:          ":2023",
 	}
 
+	d.ctx, d.shutdown = context.WithCancel(context.Background())
+
 	for _, option := range options {
 		option(d)
 	}
 
+	if len(d.listeners) == 0 {
+		d.listeners = []Listener{{Address: d.port}}
+	}
+	if d.commands == nil {
+		d.commands = worker.DefaultCommands()
+	}
+
 	return d
 }
 
 // Start kicks off the reactor loop that handles each control connections initiated by some ftp client
 // a new ControlWorker instance will handle the LCM of that connection
 func (d *Dispatcher) Start() {
+	if err := d.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ListenAndServe accepts control connections on every listener until the Dispatcher is stopped,
+// listeners that can't be opened are reported straight away, with the rest of them closed again
+func (d *Dispatcher) ListenAndServe() error {
 	d.logger.Info("Dispatcher starting up...")
 
-	var err error
-	d.server, err = net.Listen("tcp", d.port)
-	if err != nil {
-		log.Fatal(err)
+	d.mutex.Lock()
+	d.started = true
+	listeners := d.listeners
+	d.mutex.Unlock()
+
+	if err := d.Listen(listeners...); err != nil {
+		d.Listen()
+		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	d.shutdown = cancel
-	for {
-		d.logger.Info("Dispatcher waiting for connections")
+	<-d.ctx.Done()
+	d.accepting.Wait()
+	return nil
+}
+
+// Serve accepts control connections on server until the Dispatcher is stopped, server is closed
+// along with the listeners the Dispatcher opened itself but otherwise left alone by Listen,
+// net.ErrClosed is returned straight away once the Dispatcher is draining or stopped
+func (d *Dispatcher) Serve(server net.Listener) error {
+	d.mutex.Lock()
+	select {
+	case <-d.drain:
+		d.mutex.Unlock()
+		server.Close()
+		return net.ErrClosed
+	case <-d.ctx.Done():
+		d.mutex.Unlock()
+		server.Close()
+		return net.ErrClosed
+	default:
+	}
+	d.served[server] = struct{}{}
+	d.accepting.Add(1)
+	d.mutex.Unlock()
+
+	d.accept(d.ctx, server, Listener{Address: server.Addr().String()})
+	d.accepting.Done()
+
+	d.mutex.Lock()
+	delete(d.served, server)
+	d.mutex.Unlock()
+	return nil
+}
+
+// Listen changes the addresses control connections are accepted on, listeners that
+// are no longer wanted stop accepting while the sessions they accepted carry on
+//
+// every listener that could be opened is, the error reports the ones that couldn't
+func (d *Dispatcher) Listen(listeners ...Listener) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.listeners = listeners
+	if !d.started {
+		// not started yet, ListenAndServe opens them
+		return nil
+	}
+
+	select {
+	case <-d.drain:
+		return nil
+	case <-d.ctx.Done():
+		return nil
+	default:
+	}
 
-		conn, err := d.server.Accept()
+	wanted := make(map[Listener]bool, len(listeners))
+	for _, listener := range listeners {
+		wanted[listener] = true
+	}
+
+	// closed before opening new ones so that an address can be taken over by a changed listener
+	for listener, server := range d.servers {
+		if !wanted[listener] {
+			server.Close()
+			delete(d.servers, listener)
+		}
+	}
+
+	var errs []error
+	for _, listener := range listeners {
+		if _, ok := d.servers[listener]; ok {
+			continue
+		}
+
+		server, err := net.Listen("tcp", listener.Address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if listener.TLS {
+			// looked up per handshake so that certificate changes apply to new connections
+			server = tls.NewListener(server, &tls.Config{
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+					d.mutex.Lock()
+					defer d.mutex.Unlock()
+					return d.tls, nil
+				},
+			})
+		}
+
+		d.servers[listener] = server
+		d.accepting.Add(1)
+		go func(ctx context.Context) {
+			defer d.accepting.Done()
+			d.accept(ctx, server, listener)
+		}(d.ctx)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Update applies options to sessions accepted from here on, sessions that are
+// already under way keep what they started with, listeners are changed through Listen
+func (d *Dispatcher) Update(options ...Options) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, option := range options {
+		option(d)
+	}
+}
+
+func (d *Dispatcher) accept(ctx context.Context, server net.Listener, listener Listener) {
+	d.logger.Info(fmt.Sprintf("Dispatcher waiting for connections on %s", server.Addr()))
+	for {
+		conn, err := server.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
+				d.logger.Info(fmt.Sprintf("Dispatcher stopped accepting connections on %s", server.Addr()))
 				return
 			}
 			d.logger.Info(fmt.Sprintf("Dispatcher connection error: %v", err))
 			continue
 		}
 
-		worker := worker.NewControlWorker(ctx, d.logger, conn)
+		ip := remoteIP(conn)
+		if d.guard.Banned(ip) {
+			d.logger.Info(fmt.Sprintf("Dispatcher dropping connection from banned address %s", ip))
+			conn.Close()
+			continue
+		}
+
+		if err := d.limits.Acquire(ip); err != nil {
+			d.logger.Info(fmt.Sprintf("Dispatcher refusing connection from %s: %v", ip, err))
+			go refuse(conn)
+			continue
+		}
+
+		// counted under the lock close takes, so that a connection accepted just
+		// as the Dispatcher is closing can't be added once wait has started
+		d.mutex.Lock()
+		if d.closed {
+			d.mutex.Unlock()
+			conn.Close()
+			d.limits.Release(ip)
+			continue
+		}
 		d.wg.Add(1)
+		d.mutex.Unlock()
+
+		worker := worker.NewControlWorker(ctx, d.logger, conn, d.sessionOptions(listener)...)
 		go func() {
 			worker.Start()
+			d.limits.Release(ip)
 			d.wg.Done()
 		}()
 	}
 }
 
+// sessionOptions configures a ControlWorker for a connection accepted on listener
+func (d *Dispatcher) sessionOptions(listener Listener) []worker.Options {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	options := []worker.Options{
+		worker.WithDrain(d.drain),
+		worker.WithTimeouts(d.timeouts),
+		worker.WithGuard(d.guard),
+		worker.WithLimits(d.limits),
+		worker.WithCommands(d.commands),
+		worker.WithDataOptions(
+			worker.WithPolicy(d.dataPolicy),
+			worker.WithPassivePorts(d.ports),
+			worker.WithMasquerade(listener.Masquerade),
+			worker.WithAtomicUploads(d.atomicUploads),
+		),
+	}
+	if d.auth != nil {
+		options = append(options, worker.WithAuthenticator(d.auth))
+	}
+	if d.fs != nil {
+		options = append(options, worker.WithFilesystem(d.fs))
+	}
+	if d.quotas != nil {
+		options = append(options, worker.WithQuotas(d.quotas))
+	}
+	if d.throttle != nil {
+		options = append(options, worker.WithThrottle(d.throttle))
+	}
+	if d.events != nil {
+		options = append(options, worker.WithEvents(d.events))
+	}
+	if len(d.middleware) > 0 {
+		options = append(options, worker.WithMiddleware(d.middleware...))
+	}
+	if d.banner != "" {
+		options = append(options, worker.WithBanner(d.banner))
+	}
+	if listener.TLS {
+		options = append(options, worker.WithDataOptions(worker.WithTLS(d.tls)))
+	}
+
+	return options
+}
+
+// Drain stops accepting connections and lets the transfers that are under way finish,
+// sessions are closed with a 421 as soon as they're idle and new transfers are refused,
+// whatever is still running once grace has passed is forced closed by Stop
+func (d *Dispatcher) Drain(grace time.Duration) {
+	d.logger.Info(fmt.Sprintf("Dispatcher draining, waiting up to %s for transfers to complete...", grace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	d.Shutdown(ctx)
+}
+
+// Shutdown drains the Dispatcher the way Drain does, for as long as ctx allows rather than a grace
+// period, the error is that of ctx when sessions had to be forced closed
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	// closed first, so that Serve can't take on a listener once they've all been closed
+	d.draining.Do(func() { close(d.drain) })
+	d.close()
+
+	err := d.wait(ctx)
+	if err == nil {
+		d.logger.Info("Dispatcher drained")
+	} else {
+		d.logger.Info("Grace period over, forcing remaining sessions closed")
+	}
+
+	d.Stop()
+	return err
+}
+
 // Stop Dispatcher thread from accepting new connections and invoke
 // shutdown ctx for each subsequent worker, forces a shutdown rather than
-// waiting until some transfer has completed as its a non-deterministic operation
-//
-// there can be future enhancements to wait for a transfer to complete in a given timeout
+// waiting until some transfer has completed, see Drain for that
 func (d *Dispatcher) Stop() {
 	d.logger.Info("Dispatcher shutting down...")
-	d.server.Close()
 	d.shutdown()
+	d.close()
 
-	done := make(chan struct{}, 1)
+	// sessions exit as soon as they see the shutdown, this only bounds a stuck one
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if d.wait(ctx) == nil {
+		d.logger.Info("Shutdown done, exiting")
+	} else {
+		d.logger.Info("Timeout received for shutdown, exiting")
+	}
+	d.logger.Info("Dispatcher shutdown complete")
+}
+
+// close stops accepting connections on every listener, sessions are no longer taken on once it's called
+func (d *Dispatcher) close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.closed = true
+
+	for listener, server := range d.servers {
+		server.Close()
+		delete(d.servers, listener)
+	}
+	for server := range d.served {
+		server.Close()
+	}
+}
+
+// wait blocks until every session has ended, or ctx is done
+func (d *Dispatcher) wait(ctx context.Context) error {
+	done := make(chan struct{})
 	go func() {
 		d.wg.Wait()
-		done <- struct{}{}
+		close(done)
 	}()
 
-	timeout := time.Tick(5 * time.Minute)
 	select {
-	case <-timeout:
-		d.logger.Info("Timeout received for shutdown, exiting")
+	case <-ctx.Done():
+		return ctx.Err()
 	case <-done:
-		d.logger.Info("Shutdown done, exiting")
+		return nil
 	}
-	d.logger.Info("Dispatcher shutdown complete")
+}
+
+// refuse lets the client know why it's being disconnected, done off of the
+// accept loop so a client that never reads can't stall it
+func refuse(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(worker.ServiceNotAvailable.Byte())
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
 }
@@ -0,0 +1,197 @@
+package dispatcher
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"goftp/internal/worker"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// greeting connects to addr and reads the first reply
+func greeting(t *testing.T, addr string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+	return scanner.Text(), scanner.Err()
+}
+
+// address waits for listener to be opened and returns where it's listening
+func address(t *testing.T, d *Dispatcher, listener Listener) string {
+	for i := 0; i < 100; i++ {
+		d.mutex.Lock()
+		server, ok := d.servers[listener]
+		d.mutex.Unlock()
+		if ok {
+			return server.Addr().String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected %s to be listening", listener.Address)
+	return ""
+}
+
+func TestReload(t *testing.T) {
+	first := Listener{Address: "127.0.0.1:0"}
+	d := New(WithListener(first), WithBanner("first"))
+	go d.Start()
+	defer d.Stop()
+
+	addr := address(t, d, first)
+	if resp, err := greeting(t, addr); resp != "220 first" {
+		t.Errorf("Expected: %s, but got %s (%v)", "220 first", resp, err)
+	}
+
+	d.Update(WithBanner("second"))
+	if resp, err := greeting(t, addr); resp != "220 second" {
+		t.Errorf("Expected: %s, but got %s (%v)", "220 second", resp, err)
+	}
+
+	second := Listener{Address: "127.0.0.2:0"}
+	if err := d.Listen(second); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if _, err := greeting(t, addr); err == nil {
+		t.Errorf("Expected %s to no longer accept connections", addr)
+	}
+
+	if resp, err := greeting(t, address(t, d, second)); resp != "220 second" {
+		t.Errorf("Expected: %s, but got %s (%v)", "220 second", resp, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	listener := Listener{Address: "127.0.0.1:0"}
+	d := New(WithListener(listener))
+	go d.Start()
+
+	addr := address(t, d, listener)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+
+	drained := make(chan struct{})
+	go func() {
+		d.Drain(time.Minute)
+		close(drained)
+	}()
+
+	// idle sessions don't hold up the drain
+	scanner.Scan()
+	if resp := scanner.Text(); resp != string(worker.ServiceNotAvailable) {
+		t.Errorf("Expected: %s, but got %s", string(worker.ServiceNotAvailable), resp)
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected drain to complete")
+	}
+
+	if _, err := greeting(t, addr); err == nil {
+		t.Errorf("Expected %s to no longer accept connections", addr)
+	}
+}
+
+// selfSigned is a throwaway certificate for 127.0.0.1
+func selfSigned(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	listener := Listener{Address: "127.0.0.1:0", TLS: true}
+	d := New(WithListener(listener), WithTLS(selfSigned(t)))
+	go d.Start()
+	defer d.Stop()
+
+	// the greeting is only sent once the handshake has completed
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", address(t, d, listener), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+	if resp := scanner.Text(); resp != "220 Service Ready" {
+		t.Errorf("Expected: %s, but got %s (%v)", "220 Service Ready", resp, scanner.Err())
+	}
+}
+
+// once hands out conn, and reports itself closed from then on
+type once struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (o *once) Accept() (net.Conn, error) {
+	if conn := o.conn; conn != nil {
+		o.conn = nil
+		return conn, nil
+	}
+	return nil, net.ErrClosed
+}
+
+func (o *once) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func TestAcceptedWhileClosing(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// accepted just as the Dispatcher closed, it's dropped rather than
+	// becoming a session Shutdown has already stopped waiting for
+	d := New()
+	d.close()
+	d.accept(context.Background(), &once{conn: server}, Listener{})
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected: %v, but got %v", io.EOF, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.wait(ctx); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
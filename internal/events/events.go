@@ -0,0 +1,188 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"goftp/internal/logger"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Type of what happened, events of each type are raised once it has
+type Type string
+
+const (
+	Login            Type = "login"
+	Logout           Type = "logout"
+	UploadComplete   Type = "upload.complete"
+	UploadFailed     Type = "upload.failed"
+	DownloadComplete Type = "download.complete"
+	Delete           Type = "delete"
+	Rename           Type = "rename"
+	Mkdir            Type = "mkdir"
+)
+
+// Types is every Type there is
+var Types = []Type{Login, Logout, UploadComplete, UploadFailed, DownloadComplete, Delete, Rename, Mkdir}
+
+// Event is something a session did, fields that don't apply to its Type are left as the zero value
+type Event struct {
+	Type    Type      `json:"type"`
+	Time    time.Time `json:"time"`
+	Session string    `json:"session"`
+	User    string    `json:"user"`
+
+	// absolute virtual path, To is where Rename moved it
+	Path string `json:"path,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// bytes transferred, and how long it took
+	Size     int64         `json:"size,omitempty"`
+	Duration time.Duration `json:"-"`
+
+	// why an upload failed
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalJSON writes Duration in seconds
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	return json.Marshal(struct {
+		event
+		Duration float64 `json:"duration,omitempty"`
+	}{event(e), e.Duration.Seconds()})
+}
+
+// Subscriber is handed the events it subscribed to, one at a time and in the order they were raised
+type Subscriber interface {
+	Notify(Event) error
+}
+
+// Subscription is a Subscriber along with the types of event it's handed, every type when empty
+type Subscription struct {
+	Subscriber Subscriber
+	Types      []Type
+}
+
+func (s Subscription) wants(t Type) bool {
+	return len(s.Types) == 0 || slices.Contains(s.Types, t)
+}
+
+// queued is how many events a Subscriber can fall behind by before they're dropped
+const queued = 256
+
+// queue hands events to a Subscriber in a Go routine of its own, so that
+// a slow one holds up neither the sessions raising them nor the others
+type queue struct {
+	Subscription
+	events chan Event
+	done   chan struct{}
+}
+
+func (q *queue) finished() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *queue) run(l logger.Client) {
+	defer close(q.done)
+	for e := range q.events {
+		if err := q.Subscriber.Notify(e); err != nil {
+			l.Info(fmt.Sprintf("Events: unable to notify %T of %s: %v", q.Subscriber, e.Type, err))
+		}
+	}
+}
+
+// Bus hands the events sessions raise to every Subscription that wants them, it's
+// shared by every session and is safe for concurrent use
+type Bus struct {
+	mutex  sync.Mutex
+	logger logger.Client
+	queues []*queue
+	closed bool
+
+	// queues that were replaced, which may still be handing out events
+	replaced []*queue
+}
+
+func New(l logger.Client, subscriptions ...Subscription) *Bus {
+	b := &Bus{logger: l}
+	b.queues = b.start(subscriptions)
+	return b
+}
+
+func (b *Bus) start(subscriptions []Subscription) []*queue {
+	var queues []*queue
+	for _, s := range subscriptions {
+		q := &queue{Subscription: s, events: make(chan Event, queued), done: make(chan struct{})}
+		go q.run(b.logger)
+		queues = append(queues, q)
+	}
+
+	return queues
+}
+
+// SetSubscriptions replaces every Subscription, events already raised are still
+// handed to the ones being replaced
+func (b *Bus) SetSubscriptions(subscriptions ...Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.replaced = slices.DeleteFunc(b.replaced, (*queue).finished)
+	for _, q := range b.queues {
+		close(q.events)
+		b.replaced = append(b.replaced, q)
+	}
+	b.queues = b.start(subscriptions)
+}
+
+// Publish raises e without waiting on any Subscriber, it's dropped for those
+// that have fallen too far behind
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return
+	}
+	for _, q := range b.queues {
+		if !q.wants(e.Type) {
+			continue
+		}
+		select {
+		case q.events <- e:
+		default:
+			b.logger.Info(fmt.Sprintf("Events: %T has fallen behind, dropping %s of %s", q.Subscriber, e.Type, e.Path))
+		}
+	}
+}
+
+// Close stops raising events and waits for every Subscriber to be handed
+// what was raised before it was called
+func (b *Bus) Close() {
+	b.mutex.Lock()
+	if !b.closed {
+		for _, q := range b.queues {
+			close(q.events)
+		}
+	}
+	b.closed = true
+	queues := append(slices.Clone(b.queues), b.replaced...)
+	b.mutex.Unlock()
+
+	for _, q := range queues {
+		<-q.done
+	}
+}
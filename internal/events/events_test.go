@@ -0,0 +1,198 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"goftp/internal/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps every event it's handed
+type recorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Notify(e Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) types() []Type {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var types []Type
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestBus(t *testing.T) {
+	all, uploads := &recorder{}, &recorder{}
+	bus := New(logger.NewStdStreamClient(),
+		Subscription{Subscriber: all},
+		Subscription{Subscriber: uploads, Types: []Type{UploadComplete, UploadFailed}},
+	)
+
+	for _, typ := range []Type{Login, UploadComplete, Delete, UploadFailed, Logout} {
+		bus.Publish(Event{Type: typ, Session: "session", User: "hkhan"})
+	}
+	bus.Close()
+
+	// raised after Close, which isn't handed to anyone
+	bus.Publish(Event{Type: Mkdir})
+
+	if expected := []Type{Login, UploadComplete, Delete, UploadFailed, Logout}; !slices.Equal(all.types(), expected) {
+		t.Errorf("Expected: %v, but got %v", expected, all.types())
+	}
+	if expected := []Type{UploadComplete, UploadFailed}; !slices.Equal(uploads.types(), expected) {
+		t.Errorf("Expected: %v, but got %v", expected, uploads.types())
+	}
+	if e := all.events[0]; e.Time.IsZero() {
+		t.Errorf("Expected the time events are raised at to be filled in, but got %v", e.Time)
+	}
+}
+
+func TestSetSubscriptions(t *testing.T) {
+	before, after := &recorder{}, &recorder{}
+	bus := New(logger.NewStdStreamClient(), Subscription{Subscriber: before})
+
+	bus.Publish(Event{Type: Login})
+	bus.SetSubscriptions(Subscription{Subscriber: after})
+	bus.Publish(Event{Type: Logout})
+	bus.Close()
+
+	// events already raised are still handed to the subscriptions being replaced
+	if expected := []Type{Login}; !slices.Equal(before.types(), expected) {
+		t.Errorf("Expected: %v, but got %v", expected, before.types())
+	}
+	if expected := []Type{Logout}; !slices.Equal(after.types(), expected) {
+		t.Errorf("Expected: %v, but got %v", expected, after.types())
+	}
+}
+
+var uploaded = Event{
+	Type:     UploadComplete,
+	Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	Session:  "session",
+	User:     "hkhan",
+	Path:     "/incoming/report.csv",
+	Size:     2048,
+	Duration: 1500 * time.Millisecond,
+}
+
+func TestMarshal(t *testing.T) {
+	b, err := json.Marshal(uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"type":"upload.complete","time":"2024-05-01T12:00:00Z","session":"session","user":"hkhan",` +
+		`"path":"/incoming/report.csv","size":2048,"duration":1.5}`
+	if string(b) != expected {
+		t.Errorf("Expected: %s, but got %s", expected, b)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	spool := &Spool{File: filepath.Join(t.TempDir(), "events.jsonl")}
+	for _, e := range []Event{uploaded, {Type: Delete, Path: "/incoming/report.csv"}} {
+		if err := spool.Notify(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(spool.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var types []Type
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Expected a line of JSON, but got %s: %v", scanner.Text(), err)
+		}
+		types = append(types, e.Type)
+	}
+
+	if expected := []Type{UploadComplete, Delete}; !slices.Equal(types, expected) {
+		t.Errorf("Expected: %v, but got %v", expected, types)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Type == UploadFailed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL, Timeout: time.Second}
+	if err := hook.Notify(uploaded); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if received.Path != uploaded.Path || received.Size != uploaded.Size {
+		t.Errorf("Expected: %v, but got %v", uploaded, received)
+	}
+
+	if err := hook.Notify(Event{Type: UploadFailed}); err == nil {
+		t.Errorf("Expected an error for a 500 reply, but got nil")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	cmd := &Command{
+		Args:    []string{"sh", "-c", `cat > "$0"; echo "$GOFTP_EVENT_TYPE $GOFTP_EVENT_PATH $GOFTP_EVENT_SIZE" >> "$0"`, out},
+		Timeout: 10 * time.Second,
+	}
+	if err := cmd.Notify(uploaded); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, _ := io.ReadAll(f)
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"type":"upload.complete"`) {
+		t.Fatalf("Expected the event as JSON followed by a line, but got %q", b)
+	}
+	if expected := "upload.complete /incoming/report.csv 2048"; lines[1] != expected {
+		t.Errorf("Expected: %s, but got %s", expected, lines[1])
+	}
+
+	failing := &Command{Args: []string{"sh", "-c", "echo broken; exit 3"}}
+	if err := failing.Notify(uploaded); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected an error carrying the output, but got %v", err)
+	}
+}
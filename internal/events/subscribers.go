@@ -0,0 +1,115 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Command runs a local program for every event, with the event as JSON on its
+// standard input and its fields in GOFTP_EVENT_* environment variables
+type Command struct {
+	// program followed by its arguments
+	Args []string
+
+	// how long the program is given before it's killed, 0 leaves it unbounded
+	Timeout time.Duration
+}
+
+func (c *Command) Notify(e Event) error {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	cmd.Env = append(os.Environ(),
+		"GOFTP_EVENT_TYPE="+string(e.Type),
+		"GOFTP_EVENT_TIME="+e.Time.Format(time.RFC3339Nano),
+		"GOFTP_EVENT_SESSION="+e.Session,
+		"GOFTP_EVENT_USER="+e.User,
+		"GOFTP_EVENT_PATH="+e.Path,
+		"GOFTP_EVENT_TO="+e.To,
+		"GOFTP_EVENT_SIZE="+strconv.FormatInt(e.Size, 10),
+		"GOFTP_EVENT_DURATION="+strconv.FormatFloat(e.Duration.Seconds(), 'f', -1, 64),
+		"GOFTP_EVENT_ERROR="+e.Error,
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", c.Args[0], err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Webhook POSTs every event as JSON to a URL, any reply other than a 2xx is an error
+type Webhook struct {
+	URL string
+
+	// how long a request is given, 0 leaves it unbounded
+	Timeout time.Duration
+}
+
+func (w *Webhook) Notify(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: w.Timeout}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s replied %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// Spool appends every event as a line of JSON to a file, which is reopened
+// each time so that it can be rotated or consumed from underneath it
+type Spool struct {
+	File string
+
+	mutex sync.Mutex
+}
+
+func (s *Spool) Notify(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.OpenFile(s.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,188 @@
+package guard
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config controls how aggressively failed logins are punished,
+// a zero value for any of the fields disables that protection
+type Config struct {
+	// failed PASS attempts a single control connection is allowed
+	// before it is closed
+	MaxSessionAttempts int
+
+	// failed logins a single source IP is allowed within Window
+	// before it is banned for BanDuration
+	MaxAttempts int
+	Window      time.Duration
+	BanDuration time.Duration
+
+	// delay applied before replying to a failed login, doubled
+	// for every consecutive failure from the same source IP
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Ban is a source IP that is currently refused
+type Ban struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Attempts int       `json:"attempts"`
+}
+
+type failures struct {
+	count int
+	first time.Time
+}
+
+// Guard keeps track of failed logins across every control connection,
+// it's shared by the Dispatcher (to drop banned IPs) and each ControlWorker
+// (to report failures), and is safe for concurrent use
+type Guard struct {
+	mutex  sync.Mutex
+	config Config
+	now    func() time.Time
+
+	failures map[string]*failures
+	bans     map[string]Ban
+}
+
+func New(config Config) *Guard {
+	return &Guard{
+		config:   config,
+		now:      time.Now,
+		failures: make(map[string]*failures),
+		bans:     make(map[string]Ban),
+	}
+}
+
+// SetConfig changes the thresholds, failures and bans already recorded are kept
+func (g *Guard) SetConfig(config Config) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.config = config
+}
+
+// MaxSessionAttempts is the number of failed logins after which a session should be closed
+func (g *Guard) MaxSessionAttempts() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.config.MaxSessionAttempts
+}
+
+// Banned reports whether ip is currently refused
+func (g *Guard) Banned(ip string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ban, ok := g.bans[ip]
+	if !ok {
+		return false
+	}
+
+	if !g.now().Before(ban.Until) {
+		delete(g.bans, ip)
+		return false
+	}
+
+	return true
+}
+
+// Fail records a failed login from ip, returning how long to wait before
+// replying and whether the ip has just been banned
+func (g *Guard) Fail(ip string) (time.Duration, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	g.prune(now)
+
+	record, ok := g.failures[ip]
+	if !ok {
+		record = &failures{first: now}
+		g.failures[ip] = record
+	}
+	record.count++
+
+	delay := g.delay(record.count)
+	if g.config.MaxAttempts > 0 && g.config.BanDuration > 0 && record.count >= g.config.MaxAttempts {
+		g.bans[ip] = Ban{
+			IP:       ip,
+			Until:    now.Add(g.config.BanDuration),
+			Attempts: record.count,
+		}
+		delete(g.failures, ip)
+		return delay, true
+	}
+
+	return delay, false
+}
+
+// Succeed forgets every failed login from ip
+func (g *Guard) Succeed(ip string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.failures, ip)
+}
+
+// Bans lists every ban still in effect, ordered by ip
+func (g *Guard) Bans() []Ban {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	bans := make([]Ban, 0, len(g.bans))
+	for ip, ban := range g.bans {
+		if !now.Before(ban.Until) {
+			delete(g.bans, ip)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// Unban lifts the ban on ip, reporting whether there was one
+func (g *Guard) Unban(ip string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	_, ok := g.bans[ip]
+	delete(g.bans, ip)
+	delete(g.failures, ip)
+	return ok
+}
+
+// delay doubles BaseDelay for every failure after the first, capped at MaxDelay
+func (g *Guard) delay(count int) time.Duration {
+	delay := g.config.BaseDelay
+	for i := 1; i < count && delay > 0; i++ {
+		if g.config.MaxDelay > 0 && delay >= g.config.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+
+	if g.config.MaxDelay > 0 && delay > g.config.MaxDelay {
+		delay = g.config.MaxDelay
+	}
+
+	return delay
+}
+
+// prune drops failure records that fell outside of Window
+func (g *Guard) prune(now time.Time) {
+	if g.config.Window <= 0 {
+		return
+	}
+
+	for ip, record := range g.failures {
+		if now.Sub(record.first) > g.config.Window {
+			delete(g.failures, ip)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package guard
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestGuard(config Config) (*Guard, *time.Time) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := New(config)
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestDelayDoubles(t *testing.T) {
+	g, _ := newTestGuard(Config{BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+
+	for _, expected := range []time.Duration{1, 2, 4, 5, 5} {
+		delay, _ := g.Fail("10.0.0.1")
+		if delay != expected*time.Second {
+			t.Errorf("Expected: %v, but got %v", expected*time.Second, delay)
+		}
+	}
+}
+
+func TestBanAfterMaxAttempts(t *testing.T) {
+	g, now := newTestGuard(Config{MaxAttempts: 3, Window: time.Minute, BanDuration: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if _, banned := g.Fail("10.0.0.1"); banned {
+			t.Errorf("Expected not to be banned after %d attempts", i+1)
+		}
+	}
+
+	if _, banned := g.Fail("10.0.0.1"); !banned {
+		t.Errorf("Expected to be banned")
+	}
+
+	if !g.Banned("10.0.0.1") {
+		t.Errorf("Expected 10.0.0.1 to be banned")
+	}
+
+	if g.Banned("10.0.0.2") {
+		t.Errorf("Expected 10.0.0.2 not to be banned")
+	}
+
+	*now = now.Add(time.Hour)
+	if g.Banned("10.0.0.1") {
+		t.Errorf("Expected ban to have expired")
+	}
+}
+
+func TestFailuresOutsideWindowForgotten(t *testing.T) {
+	g, now := newTestGuard(Config{MaxAttempts: 2, Window: time.Minute, BanDuration: time.Hour})
+
+	g.Fail("10.0.0.1")
+	*now = now.Add(2 * time.Minute)
+
+	if _, banned := g.Fail("10.0.0.1"); banned {
+		t.Errorf("Expected failure outside of window to be forgotten")
+	}
+}
+
+func TestSucceedResets(t *testing.T) {
+	g, _ := newTestGuard(Config{MaxAttempts: 2, Window: time.Minute, BanDuration: time.Hour})
+
+	g.Fail("10.0.0.1")
+	g.Succeed("10.0.0.1")
+
+	if _, banned := g.Fail("10.0.0.1"); banned {
+		t.Errorf("Expected success to reset failures")
+	}
+}
+
+func TestBansAndUnban(t *testing.T) {
+	g, _ := newTestGuard(Config{MaxAttempts: 1, BanDuration: time.Hour})
+
+	g.Fail("10.0.0.2")
+	g.Fail("10.0.0.1")
+
+	bans := g.Bans()
+	if len(bans) != 2 || bans[0].IP != "10.0.0.1" || bans[1].IP != "10.0.0.2" {
+		t.Errorf("Expected bans for 10.0.0.1 and 10.0.0.2, but got %v", bans)
+	}
+
+	if !g.Unban("10.0.0.1") {
+		t.Errorf("Expected 10.0.0.1 to have been banned")
+	}
+
+	if g.Unban("10.0.0.1") {
+		t.Errorf("Expected 10.0.0.1 to no longer be banned")
+	}
+
+	if g.Banned("10.0.0.1") {
+		t.Errorf("Expected 10.0.0.1 not to be banned")
+	}
+}
@@ -0,0 +1,132 @@
+package limits
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrTooManySessions      = errors.New("maximum number of sessions reached")
+	ErrTooManySessionsForIP = errors.New("maximum number of sessions for address reached")
+	ErrTooManyLogins        = errors.New("maximum number of logins for user reached")
+)
+
+// Config caps how many control connections can be open at once,
+// a zero value for any of the fields leaves it unbounded
+type Config struct {
+	MaxSessions      int
+	MaxSessionsPerIP int
+	MaxLoginsPerUser int
+}
+
+// Stats is a point in time snapshot of the tracked counts
+type Stats struct {
+	Sessions int            `json:"sessions"`
+	PerIP    map[string]int `json:"per_ip"`
+	PerUser  map[string]int `json:"per_user"`
+}
+
+// Tracker counts open sessions and logged in users across every
+// control connection, it's safe for concurrent use
+type Tracker struct {
+	mutex  sync.Mutex
+	config Config
+
+	sessions int
+	perIP    map[string]int
+	perUser  map[string]int
+}
+
+func New(config Config) *Tracker {
+	return &Tracker{
+		config:  config,
+		perIP:   make(map[string]int),
+		perUser: make(map[string]int),
+	}
+}
+
+// SetConfig changes the limits, sessions and logins already over a lowered limit
+// are left alone but no new ones are let in until they drop below it
+func (t *Tracker) SetConfig(config Config) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.config = config
+}
+
+// Acquire reserves a session for ip, every successful call has to be
+// paired with a call to Release once the session ends
+func (t *Tracker) Acquire(ip string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.MaxSessions > 0 && t.sessions >= t.config.MaxSessions {
+		return ErrTooManySessions
+	}
+
+	if t.config.MaxSessionsPerIP > 0 && t.perIP[ip] >= t.config.MaxSessionsPerIP {
+		return ErrTooManySessionsForIP
+	}
+
+	t.sessions++
+	t.perIP[ip]++
+	return nil
+}
+
+func (t *Tracker) Release(ip string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sessions--
+	decrement(t.perIP, ip)
+}
+
+// Login reserves a concurrent login for user, every successful call has
+// to be paired with a call to Logout once the user logs out
+func (t *Tracker) Login(user string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.MaxLoginsPerUser > 0 && t.perUser[user] >= t.config.MaxLoginsPerUser {
+		return ErrTooManyLogins
+	}
+
+	t.perUser[user]++
+	return nil
+}
+
+func (t *Tracker) Logout(user string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	decrement(t.perUser, user)
+}
+
+func (t *Tracker) Stats() Stats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stats := Stats{
+		Sessions: t.sessions,
+		PerIP:    make(map[string]int, len(t.perIP)),
+		PerUser:  make(map[string]int, len(t.perUser)),
+	}
+
+	for ip, count := range t.perIP {
+		stats.PerIP[ip] = count
+	}
+
+	for user, count := range t.perUser {
+		stats.PerUser[user] = count
+	}
+
+	return stats
+}
+
+// decrement keeps the maps from growing with keys that no longer have any sessions
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+
+	counts[key]--
+}
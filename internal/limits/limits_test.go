@@ -0,0 +1,102 @@
+package limits
+
+import "testing"
+
+func TestMaxSessions(t *testing.T) {
+	tracker := New(Config{MaxSessions: 2})
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if err := tracker.Acquire(ip); err != nil {
+			t.Errorf("Expected nil error, but got %v", err)
+		}
+	}
+
+	if err := tracker.Acquire("10.0.0.3"); err != ErrTooManySessions {
+		t.Errorf("Expected: %v, but got %v", ErrTooManySessions, err)
+	}
+
+	tracker.Release("10.0.0.1")
+	if err := tracker.Acquire("10.0.0.3"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func TestMaxSessionsPerIP(t *testing.T) {
+	tracker := New(Config{MaxSessionsPerIP: 1})
+
+	if err := tracker.Acquire("10.0.0.1"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if err := tracker.Acquire("10.0.0.1"); err != ErrTooManySessionsForIP {
+		t.Errorf("Expected: %v, but got %v", ErrTooManySessionsForIP, err)
+	}
+
+	if err := tracker.Acquire("10.0.0.2"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func TestMaxLoginsPerUser(t *testing.T) {
+	tracker := New(Config{MaxLoginsPerUser: 1})
+
+	if err := tracker.Login("hkhan"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if err := tracker.Login("hkhan"); err != ErrTooManyLogins {
+		t.Errorf("Expected: %v, but got %v", ErrTooManyLogins, err)
+	}
+
+	tracker.Logout("hkhan")
+	if err := tracker.Login("hkhan"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tracker := New(Config{})
+	tracker.Acquire("10.0.0.1")
+	tracker.Acquire("10.0.0.1")
+	tracker.Acquire("10.0.0.2")
+	tracker.Login("hkhan")
+	tracker.Release("10.0.0.2")
+
+	stats := tracker.Stats()
+	if stats.Sessions != 2 {
+		t.Errorf("Expected: %d, but got %d", 2, stats.Sessions)
+	}
+
+	if stats.PerIP["10.0.0.1"] != 2 {
+		t.Errorf("Expected: %d, but got %d", 2, stats.PerIP["10.0.0.1"])
+	}
+
+	if _, ok := stats.PerIP["10.0.0.2"]; ok {
+		t.Errorf("Expected 10.0.0.2 to no longer be tracked")
+	}
+
+	if stats.PerUser["hkhan"] != 1 {
+		t.Errorf("Expected: %d, but got %d", 1, stats.PerUser["hkhan"])
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	tracker := New(Config{})
+	tracker.Acquire("10.0.0.1")
+	tracker.Acquire("10.0.0.1")
+
+	// sessions already over the new limit are kept
+	tracker.SetConfig(Config{MaxSessions: 1})
+	if stats := tracker.Stats(); stats.Sessions != 2 {
+		t.Errorf("Expected: %d, but got %d", 2, stats.Sessions)
+	}
+
+	if err := tracker.Acquire("10.0.0.2"); err != ErrTooManySessions {
+		t.Errorf("Expected: %v, but got %v", ErrTooManySessions, err)
+	}
+
+	tracker.SetConfig(Config{})
+	if err := tracker.Acquire("10.0.0.2"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
@@ -3,16 +3,20 @@ package logger
 import (
 	"log/slog"
 	"os"
-	"sync"
 )
 
-var once sync.Once
-var stdClient *slog.Logger
+// NewStdStream returns a client writing to stdout in format ("json" or "text"),
+// level can be a *slog.LevelVar for it to be changed while the client is in use
+func NewStdStream(level slog.Leveler, format string) Client {
+	options := &slog.HandlerOptions{Level: level}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stdout, options))
+	}
 
-func NewStdStreamClient() Client {
-	once.Do(func() {
-		stdClient = slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	})
+	return slog.New(slog.NewJSONHandler(os.Stdout, options))
+}
 
-	return stdClient
+// NewStdStreamClient returns a client writing JSON to stdout at the info level
+func NewStdStreamClient() Client {
+	return NewStdStream(slog.LevelInfo, "json")
 }
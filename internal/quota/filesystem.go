@@ -0,0 +1,237 @@
+package quota
+
+import (
+	"goftp/internal/vfs"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Filesystem counts what a user changes through it against the quotas of a Tracker,
+// and refuses changes that would take any of them over their limit with ErrExceeded
+//
+// writes are counted as they happen, so an upload is cut off as soon as it runs
+// out of room rather than once it's complete
+type Filesystem struct {
+	vfs.Filesystem
+
+	tracker *Tracker
+	user    string
+
+	// files made by CreateTemp, which are already counted as where they're going
+	mutex  sync.Mutex
+	staged map[string]bool
+}
+
+// Filesystem counts what user changes through fs against the quotas of t
+func (t *Tracker) Filesystem(fs vfs.Filesystem, user string) *Filesystem {
+	if q, ok := fs.(*Filesystem); ok {
+		fs = q.Filesystem
+	}
+
+	return &Filesystem{Filesystem: fs, tracker: t, user: user, staged: make(map[string]bool)}
+}
+
+// CreatesAtomically asks the filesystem underneath
+func (f *Filesystem) CreatesAtomically(name string) bool {
+	return vfs.CreatesAtomically(f.Filesystem, name)
+}
+
+// existing returns the size of the file at name, and whether there is one
+func (f *Filesystem) existing(name string) (int64, bool) {
+	info, err := f.Filesystem.Stat(name)
+	if err != nil || info.IsDir() {
+		return 0, false
+	}
+
+	return info.Size(), true
+}
+
+func (f *Filesystem) Create(name string) (vfs.File, error) {
+	return f.open("create", name, f.Filesystem.Create, true)
+}
+
+func (f *Filesystem) Append(name string) (vfs.File, error) {
+	return f.open("append", name, f.Filesystem.Append, false)
+}
+
+// open counts a new file against the quotas before it's opened, an existing one
+// changes hands to the user along with what it holds, which is given up when truncating
+func (f *Filesystem) open(op, name string, open func(string) (vfs.File, error), truncate bool) (vfs.File, error) {
+	name = vfs.Clean(name)
+	size, exists := f.existing(name)
+	if !exists {
+		if err := f.tracker.adjust(f.user, name, 0, 1, true); err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+
+	fd, err := open(name)
+	if err != nil {
+		if !exists {
+			f.tracker.adjust(f.user, name, 0, -1, false)
+		}
+		return nil, err
+	}
+
+	if exists {
+		owner := f.tracker.owner(name)
+		if truncate {
+			f.tracker.adjust(owner, name, -size, -1, false)
+			f.tracker.adjust(f.user, name, 0, 1, false)
+		} else if owner != f.user {
+			f.tracker.adjust(owner, "", -size, -1, false)
+			f.tracker.adjust(f.user, "", size, 1, false)
+		}
+	}
+	f.tracker.own(name, f.user)
+
+	return &file{File: fd, fs: f, name: name}, nil
+}
+
+// CreateTemp counts temp as name, so that replacing a file through a temporary one
+// takes no more room than writing over it would, see vfs.TempCreator
+func (f *Filesystem) CreateTemp(name, temp string) (vfs.File, error) {
+	temp = vfs.Clean(temp)
+	fd, err := f.open("create", name, func(string) (vfs.File, error) {
+		return f.Filesystem.Create(temp)
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	f.staged[temp] = true
+	f.mutex.Unlock()
+
+	return fd, nil
+}
+
+// unstage forgets about temp, reporting whether it was made by CreateTemp
+func (f *Filesystem) unstage(temp string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	staged := f.staged[temp]
+	delete(f.staged, temp)
+	return staged
+}
+
+func (f *Filesystem) Remove(name string) error {
+	name = vfs.Clean(name)
+	if f.unstage(name) {
+		// what was counted for it is given back once usage is recomputed
+		defer f.tracker.reconcileSoon()
+		return f.Filesystem.Remove(name)
+	}
+
+	size, exists := f.existing(name)
+	if err := f.Filesystem.Remove(name); err != nil {
+		return err
+	}
+
+	if exists {
+		f.tracker.adjust(f.tracker.owner(name), name, -size, -1, false)
+		f.tracker.own(name, "")
+	}
+
+	return nil
+}
+
+// Rename refuses to move a file into a directory tree without room for it,
+// directories are moved regardless and caught up with by reconciling
+func (f *Filesystem) Rename(from, to string) error {
+	from, to = vfs.Clean(from), vfs.Clean(to)
+	if f.unstage(from) {
+		// counted as to ever since it was created
+		if err := f.Filesystem.Rename(from, to); err != nil {
+			f.mutex.Lock()
+			f.staged[from] = true
+			f.mutex.Unlock()
+			return err
+		}
+		return nil
+	}
+
+	info, err := f.Filesystem.Stat(from)
+	if err != nil || info.IsDir() || from == to {
+		if err := f.Filesystem.Rename(from, to); err != nil {
+			return err
+		}
+		if info != nil && info.IsDir() {
+			f.tracker.rename(from, to)
+			f.tracker.reconcileSoon()
+		}
+		return nil
+	}
+
+	replaced, exists := f.existing(to)
+	if err := f.tracker.move(from, to, info.Size(), replaced, exists, true); err != nil {
+		return &fs.PathError{Op: "rename", Path: to, Err: err}
+	}
+
+	if err := f.Filesystem.Rename(from, to); err != nil {
+		f.tracker.move(to, from, info.Size(), 0, false, false)
+		if exists {
+			f.tracker.adjust("", to, replaced, 1, false)
+		}
+		return err
+	}
+
+	if exists {
+		f.tracker.adjust(f.tracker.owner(to), "", -replaced, -1, false)
+	}
+	f.tracker.own(to, f.tracker.owner(from))
+	f.tracker.own(from, "")
+
+	return nil
+}
+
+// file counts every write against the quotas before it's made
+type file struct {
+	vfs.File
+
+	fs   *Filesystem
+	name string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if err := f.fs.tracker.adjust(f.fs.user, f.name, int64(len(p)), 0, true); err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(p)
+	if n < len(p) {
+		f.fs.tracker.adjust(f.fs.user, f.name, int64(n-len(p)), 0, false)
+	}
+
+	return n, err
+}
+
+// ReadFrom hands the copy to the file underneath, so it can splice(2) straight from the
+// data connection, unless what's written has to be counted against a quota
+func (f *file) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := f.File.(io.ReaderFrom)
+	if !ok || f.fs.tracker.counts(f.fs.user, f.name) {
+		return io.Copy(struct{ io.Writer }{f}, r)
+	}
+
+	return from.ReadFrom(r)
+}
+
+// Sync flushes the file when the filesystem underneath can
+func (f *file) Sync() error {
+	if syncer, ok := f.File.(vfs.Syncer); ok {
+		return syncer.Sync()
+	}
+
+	return nil
+}
+
+// Abort throws the file away when the filesystem underneath can, what was counted
+// for it is given back once usage is recomputed
+func (f *file) Abort() error {
+	err := vfs.Abort(f.File)
+	f.fs.tracker.reconcileSoon()
+	return err
+}
@@ -0,0 +1,376 @@
+package quota
+
+import (
+	"errors"
+	"goftp/internal/vfs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrExceeded is returned by changes that would take usage over a quota
+var ErrExceeded = errors.New("quota exceeded")
+
+// Limit caps what can be stored, a zero value for either field leaves it unbounded
+type Limit struct {
+	Bytes int64
+	Files int64
+}
+
+// Usage is what's stored against a quota
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// Config sets the quotas of users, across every file they've uploaded,
+// and of directory trees, across every file within them
+type Config struct {
+	Users map[string]Limit
+	Paths map[string]Limit
+
+	// how often usage is recomputed from the filesystem, a zero value
+	// only does so at start up and when the configuration changes
+	Interval time.Duration
+}
+
+// Status is a quota along with what's used of it
+type Status struct {
+	// user name, or absolute path of the directory tree
+	Name  string
+	User  bool
+	Limit Limit
+	Usage Usage
+}
+
+// Tracker keeps track of usage against every quota, it's shared by every
+// session and is safe for concurrent use
+//
+// usage is updated as sessions change files through a Filesystem, and recomputed
+// every Interval to catch up with changes made outside of the server. A user's
+// usage is made up of the files they uploaded while the server was running
+type Tracker struct {
+	mutex  sync.Mutex
+	config Config
+	fs     vfs.Filesystem
+
+	paths map[string]*Usage
+	users map[string]*Usage
+
+	// who uploaded each file, only kept for users with a quota
+	owners map[string]string
+
+	// signals Start to reconcile straight away
+	changed  chan struct{}
+	stop     chan struct{}
+	stopping sync.Once
+}
+
+func New(config Config, fs vfs.Filesystem) *Tracker {
+	return &Tracker{
+		config:  config,
+		fs:      fs,
+		paths:   make(map[string]*Usage),
+		users:   make(map[string]*Usage),
+		owners:  make(map[string]string),
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// SetConfig changes the quotas, usage is recomputed for them straight away
+func (t *Tracker) SetConfig(config Config) {
+	t.mutex.Lock()
+	t.config = config
+	t.mutex.Unlock()
+	t.reconcileSoon()
+}
+
+// SetFilesystem changes the filesystem usage is recomputed from
+func (t *Tracker) SetFilesystem(fs vfs.Filesystem) {
+	t.mutex.Lock()
+	t.fs = fs
+	t.mutex.Unlock()
+	t.reconcileSoon()
+}
+
+func (t *Tracker) reconcileSoon() {
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Start recomputes usage every Interval until Stop is called
+func (t *Tracker) Start() {
+	for {
+		t.Reconcile()
+
+		t.mutex.Lock()
+		interval := t.config.Interval
+		t.mutex.Unlock()
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			tick = time.After(interval)
+		}
+
+		select {
+		case <-tick:
+		case <-t.changed:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *Tracker) Stop() {
+	t.stopping.Do(func() { close(t.stop) })
+}
+
+// Reconcile recomputes usage from what's on the filesystem, changes made while it
+// runs may be missed or counted twice until the next time around
+func (t *Tracker) Reconcile() {
+	t.mutex.Lock()
+	fs := t.fs
+	var paths []string
+	for pth := range t.config.Paths {
+		paths = append(paths, pth)
+	}
+	owners := make(map[string]string, len(t.owners))
+	for name, user := range t.owners {
+		if _, ok := t.config.Users[user]; ok {
+			owners[name] = user
+		}
+	}
+	t.mutex.Unlock()
+
+	usage := make(map[string]*Usage, len(paths))
+	for _, pth := range paths {
+		usage[pth] = new(Usage)
+		walk(fs, pth, usage[pth])
+	}
+
+	users := make(map[string]*Usage)
+	for name, user := range owners {
+		info, err := fs.Stat(name)
+		if err != nil || info.IsDir() {
+			delete(owners, name)
+			continue
+		}
+		if users[user] == nil {
+			users[user] = new(Usage)
+		}
+		users[user].Bytes += info.Size()
+		users[user].Files++
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.paths, t.users, t.owners = usage, users, owners
+}
+
+// walk adds up the files within dir, what can't be read is left out
+func walk(fs vfs.Filesystem, dir string, usage *Usage) {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			walk(fs, vfs.Clean(dir+"/"+info.Name()), usage)
+			continue
+		}
+		usage.Bytes += info.Size()
+		usage.Files++
+	}
+}
+
+// Status reports the quota of user, when there's one, followed by every directory tree's
+func (t *Tracker) Status(user string) []Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var statuses []Status
+	if limit, ok := t.config.Users[user]; ok {
+		statuses = append(statuses, Status{Name: user, User: true, Limit: limit, Usage: t.usage(t.users, user)})
+	}
+
+	var paths []Status
+	for pth, limit := range t.config.Paths {
+		paths = append(paths, Status{Name: pth, Limit: limit, Usage: t.usage(t.paths, pth)})
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Name < paths[j].Name })
+
+	return append(statuses, paths...)
+}
+
+func (t *Tracker) usage(m map[string]*Usage, key string) Usage {
+	if usage, ok := m[key]; ok {
+		return *usage
+	}
+	return Usage{}
+}
+
+// quota is a limit along with the usage counted against it
+type quota struct {
+	limit Limit
+	usage *Usage
+}
+
+func (q quota) allows(bytes, files int64) bool {
+	return (bytes <= 0 || q.limit.Bytes == 0 || q.usage.Bytes+bytes <= q.limit.Bytes) &&
+		(files <= 0 || q.limit.Files == 0 || q.usage.Files+files <= q.limit.Files)
+}
+
+func (q quota) add(bytes, files int64) {
+	q.usage.Bytes += bytes
+	q.usage.Files += files
+}
+
+// user returns the quota of user, when there's one, the caller holds the lock
+func (t *Tracker) user(user string) (quota, bool) {
+	limit, ok := t.config.Users[user]
+	if !ok {
+		return quota{}, false
+	}
+	if t.users[user] == nil {
+		t.users[user] = new(Usage)
+	}
+	return quota{limit, t.users[user]}, true
+}
+
+// path returns the quota of the directory tree pth, the caller holds the lock
+func (t *Tracker) path(pth string) quota {
+	if t.paths[pth] == nil {
+		t.paths[pth] = new(Usage)
+	}
+	return quota{t.config.Paths[pth], t.paths[pth]}
+}
+
+// counts reports whether anything user writes to name is counted against a quota
+func (t *Tracker) counts(user, name string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.config.Users[user]; ok {
+		return true
+	}
+	for pth := range t.config.Paths {
+		if under(name, pth) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adjust counts bytes and files against user and every directory tree name is within, an
+// empty name only counts against user, when check is set nothing is counted if that would
+// take any of them over their limit
+func (t *Tracker) adjust(user, name string, bytes, files int64, check bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var quotas []quota
+	if q, ok := t.user(user); ok {
+		quotas = append(quotas, q)
+	}
+	for pth := range t.config.Paths {
+		if name != "" && under(name, pth) {
+			quotas = append(quotas, t.path(pth))
+		}
+	}
+
+	for _, q := range quotas {
+		if check && !q.allows(bytes, files) {
+			return ErrExceeded
+		}
+	}
+	for _, q := range quotas {
+		q.add(bytes, files)
+	}
+
+	return nil
+}
+
+// move counts a file of size bytes being renamed from one name to another, replacing
+// a file of replaced bytes when it exists, against the directory trees either is within
+func (t *Tracker) move(from, to string, size, replaced int64, exists, check bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	type change struct {
+		quota
+		bytes, files int64
+	}
+
+	var changes []change
+	for pth := range t.config.Paths {
+		var c change
+		if under(from, pth) {
+			c.bytes, c.files = c.bytes-size, c.files-1
+		}
+		if under(to, pth) {
+			c.bytes, c.files = c.bytes+size, c.files+1
+			if exists {
+				c.bytes, c.files = c.bytes-replaced, c.files-1
+			}
+		}
+		if c.bytes != 0 || c.files != 0 {
+			c.quota = t.path(pth)
+			changes = append(changes, c)
+		}
+	}
+
+	for _, c := range changes {
+		if check && !c.allows(c.bytes, c.files) {
+			return ErrExceeded
+		}
+	}
+	for _, c := range changes {
+		c.add(c.bytes, c.files)
+	}
+
+	return nil
+}
+
+// owner returns who uploaded name, "" when it isn't known
+func (t *Tracker) owner(name string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.owners[name]
+}
+
+// own records user as the uploader of name, only users with a quota are
+// kept track of and "" forgets about name
+func (t *Tracker) own(name, user string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.config.Users[user]; !ok {
+		delete(t.owners, name)
+		return
+	}
+	t.owners[name] = user
+}
+
+// rename moves the uploaders of from, and of everything within it, over to to
+func (t *Tracker) rename(from, to string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for name, user := range t.owners {
+		if under(name, from) {
+			delete(t.owners, name)
+			t.owners[to+strings.TrimPrefix(name, from)] = user
+		}
+	}
+}
+
+// under reports whether name is dir or within it
+func under(name, dir string) bool {
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
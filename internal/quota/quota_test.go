@@ -0,0 +1,203 @@
+package quota
+
+import (
+	"errors"
+	"goftp/internal/vfs"
+	"io"
+	"strings"
+	"testing"
+)
+
+func setupTracker(t *testing.T, config Config) (*Tracker, *vfs.Memory) {
+	fs := vfs.NewMemory()
+	for _, dir := range []string{"/incoming", "/incoming/partner"} {
+		if err := fs.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, _ := fs.Create("/incoming/existing.txt")
+	io.WriteString(file, "0123456789")
+	file.Close()
+
+	tracker := New(config, fs)
+	tracker.Reconcile()
+	return tracker, fs
+}
+
+func upload(fs vfs.Filesystem, name, contents string) error {
+	file, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, strings.NewReader(contents))
+	return err
+}
+
+func status(tracker *Tracker, user, name string) Usage {
+	for _, s := range tracker.Status(user) {
+		if s.Name == name {
+			return s.Usage
+		}
+	}
+	return Usage{}
+}
+
+func TestPathBytes(t *testing.T) {
+	tracker, memory := setupTracker(t, Config{Paths: map[string]Limit{"/incoming": {Bytes: 15}}})
+	fs := tracker.Filesystem(memory, "partner")
+
+	if usage := status(tracker, "partner", "/incoming"); usage != (Usage{Bytes: 10, Files: 1}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 10, Files: 1}, usage)
+	}
+
+	if err := upload(fs, "/incoming/partner/a.txt", "01234"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	// out of room, the file is created but nothing can be written to it
+	if err := upload(fs, "/incoming/b.txt", "0"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected: %v, but got %v", ErrExceeded, err)
+	}
+
+	// outside of the quota
+	if err := upload(fs, "/elsewhere.txt", "0123456789"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	// overwriting gives up what the file held
+	if err := upload(fs, "/incoming/existing.txt", "012345"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	expected := Usage{Bytes: 11, Files: 3}
+	if usage := status(tracker, "partner", "/incoming"); usage != expected {
+		t.Errorf("Expected: %v, but got %v", expected, usage)
+	}
+
+	// what's counted as it happens matches what's on the filesystem
+	tracker.Reconcile()
+	if usage := status(tracker, "partner", "/incoming"); usage != expected {
+		t.Errorf("Expected: %v, but got %v after reconciling", expected, usage)
+	}
+}
+
+func TestPathFiles(t *testing.T) {
+	tracker, memory := setupTracker(t, Config{Paths: map[string]Limit{"/incoming": {Files: 2}}})
+	fs := tracker.Filesystem(memory, "partner")
+
+	if err := upload(fs, "/incoming/a.txt", "a"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if _, err := fs.Create("/incoming/b.txt"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected: %v, but got %v", ErrExceeded, err)
+	}
+
+	// moving a file in takes room as well
+	upload(fs, "/b.txt", "b")
+	if err := fs.Rename("/b.txt", "/incoming/b.txt"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected: %v, but got %v", ErrExceeded, err)
+	}
+
+	if err := fs.Remove("/incoming/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/b.txt", "/incoming/b.txt"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if usage := status(tracker, "partner", "/incoming"); usage != (Usage{Bytes: 11, Files: 2}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 11, Files: 2}, usage)
+	}
+}
+
+func TestCreateTemp(t *testing.T) {
+	tracker, memory := setupTracker(t, Config{Paths: map[string]Limit{"/incoming": {Files: 1}}})
+	fs := tracker.Filesystem(memory, "partner")
+
+	if _, err := fs.CreateTemp("/incoming/new.txt", "/incoming/.new.txt.part"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected: %v, but got %v", ErrExceeded, err)
+	}
+
+	// replacing a file doesn't take another one's room while it's being written
+	file, err := fs.CreateTemp("/incoming/existing.txt", "/incoming/.existing.txt.part")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	io.WriteString(file, "0123")
+	file.Close()
+	if err := fs.Rename("/incoming/.existing.txt.part", "/incoming/existing.txt"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	expected := Usage{Bytes: 4, Files: 1}
+	if usage := status(tracker, "partner", "/incoming"); usage != expected {
+		t.Errorf("Expected: %v, but got %v", expected, usage)
+	}
+
+	tracker.Reconcile()
+	if usage := status(tracker, "partner", "/incoming"); usage != expected {
+		t.Errorf("Expected: %v, but got %v after reconciling", expected, usage)
+	}
+}
+
+func TestUser(t *testing.T) {
+	tracker, memory := setupTracker(t, Config{Users: map[string]Limit{"partner": {Bytes: 8, Files: 2}}})
+	partner := tracker.Filesystem(memory, "partner")
+	admin := tracker.Filesystem(memory, "admin")
+
+	// files uploaded by others don't count
+	if err := upload(admin, "/admin.txt", "0123456789"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	if err := upload(partner, "/a.txt", "0123"); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+	if err := upload(partner, "/incoming/b.txt", "012345"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected: %v, but got %v", ErrExceeded, err)
+	}
+	// a write that doesn't fit is refused as a whole
+	if usage := status(tracker, "partner", "partner"); usage != (Usage{Bytes: 4, Files: 2}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 4, Files: 2}, usage)
+	}
+
+	// removed by someone else, the room is given back to the uploader
+	if err := admin.Remove("/incoming/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if usage := status(tracker, "partner", "partner"); usage != (Usage{Bytes: 4, Files: 1}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 4, Files: 1}, usage)
+	}
+
+	// renamed files stay with who uploaded them
+	if err := admin.Rename("/a.txt", "/incoming/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Reconcile()
+	if usage := status(tracker, "partner", "partner"); usage != (Usage{Bytes: 4, Files: 1}) {
+		t.Errorf("Expected: %v, but got %v after reconciling", Usage{Bytes: 4, Files: 1}, usage)
+	}
+
+	if statuses := tracker.Status("admin"); len(statuses) != 0 {
+		t.Errorf("Expected no quotas for admin, but got %v", statuses)
+	}
+}
+
+func TestReconcileCatchesUp(t *testing.T) {
+	tracker, memory := setupTracker(t, Config{Paths: map[string]Limit{"/incoming": {Bytes: 100}}})
+
+	// changed behind the tracker's back
+	upload(memory, "/incoming/partner/c.txt", "0123456789")
+	if usage := status(tracker, "", "/incoming"); usage != (Usage{Bytes: 10, Files: 1}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 10, Files: 1}, usage)
+	}
+
+	tracker.Reconcile()
+	if usage := status(tracker, "", "/incoming"); usage != (Usage{Bytes: 20, Files: 2}) {
+		t.Errorf("Expected: %v, but got %v", Usage{Bytes: 20, Files: 2}, usage)
+	}
+}
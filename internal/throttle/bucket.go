@@ -0,0 +1,70 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket holding up to a second's worth of bytes, it's
+// safe for concurrent use
+type Bucket struct {
+	mutex sync.Mutex
+	now   func() time.Time
+
+	// bytes per second, 0 for unlimited
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate int64) *Bucket {
+	b := &Bucket{now: time.Now, rate: rate}
+	b.last = b.now()
+	b.tokens = float64(rate)
+	return b
+}
+
+// SetRate changes how fast the bucket fills up, what it holds is kept within a second's worth
+func (b *Bucket) SetRate(rate int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.fill()
+	b.rate = rate
+	b.tokens = min(b.tokens, float64(rate))
+}
+
+// Limited reports whether the bucket holds anything back
+func (b *Bucket) Limited() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate > 0
+}
+
+// fill adds the tokens accrued since it was last called, the caller holds the lock
+func (b *Bucket) fill() {
+	now := b.now()
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.rate), float64(b.rate))
+	}
+	b.last = now
+}
+
+// Take removes n tokens and returns how long to wait before using them, taking
+// more than the bucket holds leaves it in debt, which later callers wait out
+func (b *Bucket) Take(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.fill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
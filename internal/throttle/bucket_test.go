@@ -0,0 +1,46 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(1000)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// a second's worth is available straight away
+	if delay := b.Take(1000); delay != 0 {
+		t.Errorf("Expected: %v, but got %v", time.Duration(0), delay)
+	}
+
+	if delay := b.Take(500); delay != 500*time.Millisecond {
+		t.Errorf("Expected: %v, but got %v", 500*time.Millisecond, delay)
+	}
+
+	// the debt is paid off over time
+	now = now.Add(time.Second)
+	if delay := b.Take(250); delay != 0 {
+		t.Errorf("Expected: %v, but got %v", time.Duration(0), delay)
+	}
+
+	// never holds more than a second's worth
+	now = now.Add(time.Hour)
+	if delay := b.Take(2000); delay != time.Second {
+		t.Errorf("Expected: %v, but got %v", time.Second, delay)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := NewBucket(0)
+	if delay := b.Take(1 << 30); delay != 0 {
+		t.Errorf("Expected: %v, but got %v", time.Duration(0), delay)
+	}
+
+	b.SetRate(100)
+	if delay := b.Take(100); delay <= 0 {
+		t.Errorf("Expected a delay once limited, but got %v", delay)
+	}
+}
@@ -0,0 +1,296 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rates are in bytes per second, a zero value for any of the fields leaves it unlimited
+type Rates struct {
+	// each session on its own
+	Session int64 `json:"session"`
+
+	// every session of a user put together
+	User int64 `json:"user"`
+
+	// every session on the server put together
+	Global int64 `json:"global"`
+}
+
+// Config sets how fast data is transferred, separately for each direction
+type Config struct {
+	Upload   Rates `json:"upload"`
+	Download Rates `json:"download"`
+}
+
+// Direction data flows in, from the point of view of the client
+type Direction int
+
+const (
+	Upload Direction = iota
+	Download
+)
+
+// rates returns what applies to d
+func (c Config) rates(d Direction) Rates {
+	if d == Upload {
+		return c.Upload
+	}
+	return c.Download
+}
+
+// user is the buckets shared by every session of a user
+type user struct {
+	sessions int
+	buckets  [2]*Bucket
+}
+
+// Throttle hands out the buckets transfers wait on, it's shared by every
+// session and is safe for concurrent use
+type Throttle struct {
+	mutex  sync.Mutex
+	config Config
+
+	global   [2]*Bucket
+	users    map[string]*user
+	sessions map[*Session]struct{}
+}
+
+func New(config Config) *Throttle {
+	t := &Throttle{
+		config:   config,
+		users:    make(map[string]*user),
+		sessions: make(map[*Session]struct{}),
+	}
+
+	for _, d := range []Direction{Upload, Download} {
+		t.global[d] = NewBucket(config.rates(d).Global)
+	}
+
+	return t
+}
+
+func (t *Throttle) Config() Config {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.config
+}
+
+// SetConfig changes the rates, transfers that are under way pick them up straight away
+func (t *Throttle) SetConfig(config Config) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.config = config
+	for _, d := range []Direction{Upload, Download} {
+		rates := config.rates(d)
+		t.global[d].SetRate(rates.Global)
+		for _, u := range t.users {
+			u.buckets[d].SetRate(rates.User)
+		}
+		for s := range t.sessions {
+			s.buckets[d].SetRate(rates.Session)
+		}
+	}
+}
+
+// Session returns the buckets the transfers of a session of name wait on,
+// every Session has to be closed once the session ends
+func (t *Throttle) Session(name string) *Session {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	u, ok := t.users[name]
+	if !ok {
+		u = &user{}
+		for _, d := range []Direction{Upload, Download} {
+			u.buckets[d] = NewBucket(t.config.rates(d).User)
+		}
+		t.users[name] = u
+	}
+	u.sessions++
+
+	s := &Session{throttle: t, name: name}
+	for _, d := range []Direction{Upload, Download} {
+		s.buckets[d] = NewBucket(t.config.rates(d).Session)
+		s.chain[d] = []*Bucket{s.buckets[d], u.buckets[d], t.global[d]}
+	}
+	t.sessions[s] = struct{}{}
+
+	return s
+}
+
+// Session limits the transfers of a single session
+type Session struct {
+	throttle *Throttle
+	name     string
+	buckets  [2]*Bucket
+
+	// every bucket a transfer in each direction waits on
+	chain [2][]*Bucket
+}
+
+func (s *Session) Close() {
+	t := s.throttle
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.sessions[s]; !ok {
+		return
+	}
+	delete(t.sessions, s)
+
+	if u := t.users[s.name]; u != nil {
+		u.sessions--
+		if u.sessions == 0 {
+			delete(t.users, s.name)
+		}
+	}
+}
+
+// wait blocks until n bytes can be moved in direction d, or ctx is done
+func (s *Session) wait(ctx context.Context, d Direction, n int) error {
+	var delay time.Duration
+	for _, b := range s.chain[d] {
+		delay = max(delay, b.Take(n))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limited reports whether any of the buckets data flowing in direction d waits on holds it back
+func (s *Session) limited(d Direction) bool {
+	for _, b := range s.chain[d] {
+		if b.Limited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Reader limits how fast r is read from, for data flowing in direction d
+func (s *Session) Reader(ctx context.Context, r io.Reader, d Direction) io.Reader {
+	return &reader{ctx: ctx, r: r, s: s, d: d}
+}
+
+// Writer limits how fast w is written to, for data flowing in direction d
+func (s *Session) Writer(ctx context.Context, w io.Writer, d Direction) io.Writer {
+	return &writer{ctx: ctx, w: w, s: s, d: d}
+}
+
+// chunk is the most moved at once, so that slow rates are kept to smoothly
+const chunk = 16 * 1024
+
+// forwardChunk is the most handed on at once while nothing limits a direction, see
+// ReadFrom, the buckets are checked again in between so rates set part way through
+// a transfer still take hold
+const forwardChunk = 256 * 1024
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	s   *Session
+	d   Direction
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.s.wait(r.ctx, r.d, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	s   *Session
+	d   Direction
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), chunk)
+		if err := w.s.wait(w.ctx, w.d, n); err != nil {
+			return written, err
+		}
+
+		n, err := w.w.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// WriteTo lets r hand the copy on to w when it can, the buckets are then kept to by
+// what it writes instead
+func (r *reader) WriteTo(w io.Writer) (int64, error) {
+	to, ok := r.r.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, struct{ io.Reader }{r})
+	}
+
+	return to.WriteTo(&writer{ctx: r.ctx, w: w, s: r.s, d: r.d})
+}
+
+// ReadFrom hands the copy on to w while nothing limits the direction, so that it can
+// be left to the kernel, and goes through Write a chunk at a time otherwise
+func (w *writer) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := w.w.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+
+	// a reader that's already limited is cut down in place rather than wrapped
+	// again, the kernel only sees the file beneath a single io.LimitedReader
+	limited, ok := r.(*io.LimitedReader)
+	if !ok {
+		limited = &io.LimitedReader{R: r, N: math.MaxInt64}
+	}
+
+	var written int64
+	for limited.N > 0 {
+		remain, n := limited.N, min(limited.N, forwardChunk)
+		limited.N = n
+
+		var moved int64
+		var err error
+		if w.s.limited(w.d) {
+			moved, err = io.Copy(struct{ io.Writer }{w}, limited)
+		} else {
+			moved, err = from.ReadFrom(limited)
+		}
+		written += moved
+		limited.N = remain - moved
+		if err != nil || moved < n {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,114 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSessionShareUserBucket(t *testing.T) {
+	throttle := New(Config{Download: Rates{User: 1000}})
+
+	first := throttle.Session("partner")
+	second := throttle.Session("partner")
+	other := throttle.Session("admin")
+
+	if first.chain[Download][1] != second.chain[Download][1] {
+		t.Errorf("Expected sessions of the same user to share a bucket")
+	}
+	if first.chain[Download][1] == other.chain[Download][1] {
+		t.Errorf("Expected sessions of different users not to share a bucket")
+	}
+
+	first.Close()
+	second.Close()
+	other.Close()
+	if len(throttle.users) != 0 || len(throttle.sessions) != 0 {
+		t.Errorf("Expected everything to be released, but got %d users and %d sessions", len(throttle.users), len(throttle.sessions))
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	throttle := New(Config{})
+	session := throttle.Session("partner")
+	defer session.Close()
+
+	throttle.SetConfig(Config{Upload: Rates{Session: 100, User: 200, Global: 300}})
+	for i, expected := range []int64{100, 200, 300} {
+		if rate := session.chain[Upload][i].rate; rate != expected {
+			t.Errorf("Expected: %d, but got %d", expected, rate)
+		}
+	}
+
+	if rate := session.chain[Download][0].rate; rate != 0 {
+		t.Errorf("Expected: %d, but got %d", 0, rate)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	throttle := New(Config{Download: Rates{Global: 64 * 1024}})
+	session := throttle.Session("partner")
+	defer session.Close()
+
+	// the first second's worth goes straight through, the next half second's has to wait
+	var buf bytes.Buffer
+	start := time.Now()
+	w := session.Writer(context.Background(), &buf, Download)
+	if n, err := io.Copy(w, bytes.NewReader(make([]byte, 96*1024))); err != nil || n != 96*1024 {
+		t.Fatalf("Expected %d bytes, but got %d (%v)", 96*1024, n, err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected the copy to take about 500ms, but took %v", elapsed)
+	}
+}
+
+func TestReaderCancelled(t *testing.T) {
+	throttle := New(Config{Upload: Rates{Session: 1}})
+	session := throttle.Session("partner")
+	defer session.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := session.Reader(ctx, bytes.NewReader(make([]byte, 10)), Upload)
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Errorf("Expected: %v, but got %v", context.Canceled, err)
+	}
+}
+
+// rated sets a rate on throttle once a forwarded chunk has been read
+type rated struct {
+	r        io.Reader
+	throttle *Throttle
+	read     int
+}
+
+func (r *rated) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += n
+	if r.read >= forwardChunk {
+		r.throttle.SetConfig(Config{Download: Rates{Session: 1}})
+	}
+	return n, err
+}
+
+func TestRateSetPartWay(t *testing.T) {
+	throttle := New(Config{})
+	session := throttle.Session("partner")
+	defer session.Close()
+
+	// nothing waits while the transfer is unlimited, so the cancelled context
+	// only cuts it off once the rate has been set
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	w := session.Writer(ctx, &buf, Download)
+	r := &rated{r: bytes.NewReader(make([]byte, 2*forwardChunk)), throttle: throttle}
+	if n, err := io.Copy(w, r); err != context.Canceled || n != forwardChunk {
+		t.Errorf("Expected %d bytes with %v, but got %d (%v)", forwardChunk, context.Canceled, n, err)
+	}
+}
@@ -0,0 +1,367 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// Memory keeps the entire tree in memory, nothing outlives the process,
+// which makes it suited to tests and ephemeral servers
+//
+// it's safe for concurrent use, files that are open keep working when
+// they're removed or renamed, as they would on disk
+type Memory struct {
+	mutex sync.RWMutex
+	nodes map[string]*node
+
+	// time source for modification times, swapped out by tests
+	now func() time.Time
+}
+
+type node struct {
+	dir   bool
+	data  []byte
+	mtime time.Time
+}
+
+func NewMemory() *Memory {
+	m := &Memory{nodes: make(map[string]*node), now: time.Now}
+	m.nodes["/"] = &node{dir: true, mtime: m.now()}
+	return m
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// parent returns the directory name would be created in, the caller holds the lock
+func (m *Memory) parent(op, name string) (*node, error) {
+	dir, ok := m.nodes[path.Dir(name)]
+	if !ok {
+		return nil, pathError(op, name, fs.ErrNotExist)
+	}
+	if !dir.dir {
+		return nil, pathError(op, name, errNotDir)
+	}
+
+	return dir, nil
+}
+
+func (m *Memory) Open(name string) (File, error) {
+	name = Clean(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+	if n.dir {
+		return nil, pathError("open", name, errIsDir)
+	}
+
+	return &memoryFile{m: m, n: n, name: name, read: true}, nil
+}
+
+func (m *Memory) Create(name string) (File, error) {
+	return m.create("create", Clean(name), false)
+}
+
+func (m *Memory) Append(name string) (File, error) {
+	return m.create("append", Clean(name), true)
+}
+
+func (m *Memory) create(op, name string, appending bool) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, ok := m.nodes[name]
+	switch {
+	case ok && n.dir:
+		return nil, pathError(op, name, errIsDir)
+	case ok && !appending:
+		n.data, n.mtime = nil, m.now()
+	case !ok:
+		dir, err := m.parent(op, name)
+		if err != nil {
+			return nil, err
+		}
+		n = &node{mtime: m.now()}
+		m.nodes[name] = n
+		dir.mtime = n.mtime
+	}
+
+	return &memoryFile{m: m, n: n, name: name, read: !appending, write: true, append: appending}, nil
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	name = Clean(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+
+	return n.info(path.Base(name)), nil
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.FileInfo, error) {
+	name = Clean(name)
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+	if !n.dir {
+		return nil, pathError("readdir", name, errNotDir)
+	}
+
+	var infos []fs.FileInfo
+	for pth, child := range m.nodes {
+		if pth != "/" && path.Dir(pth) == name {
+			infos = append(infos, child.info(path.Base(pth)))
+		}
+	}
+	sortByName(infos)
+
+	return infos, nil
+}
+
+func (m *Memory) Mkdir(name string) error {
+	name = Clean(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.nodes[name]; ok {
+		return pathError("mkdir", name, fs.ErrExist)
+	}
+
+	dir, err := m.parent("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	m.nodes[name] = &node{dir: true, mtime: m.now()}
+	dir.mtime = m.nodes[name].mtime
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	name = Clean(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok || name == "/" {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+
+	if n.dir {
+		for pth := range m.nodes {
+			if strings.HasPrefix(pth, name+"/") {
+				return pathError("remove", name, errNotEmpty)
+			}
+		}
+	}
+
+	delete(m.nodes, name)
+	m.nodes[path.Dir(name)].mtime = m.now()
+	return nil
+}
+
+// Rename replaces to when it's a file, directories are moved along with everything in them
+func (m *Memory) Rename(from, to string) error {
+	from, to = Clean(from), Clean(to)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, ok := m.nodes[from]
+	if !ok || from == "/" {
+		return pathError("rename", from, fs.ErrNotExist)
+	}
+	if from == to {
+		return nil
+	}
+
+	if existing, ok := m.nodes[to]; ok && (existing.dir || n.dir) {
+		return pathError("rename", to, fs.ErrExist)
+	}
+
+	dir, err := m.parent("rename", to)
+	if err != nil {
+		return err
+	}
+
+	if n.dir && strings.HasPrefix(to, from+"/") {
+		return pathError("rename", to, fs.ErrInvalid)
+	}
+
+	if n.dir {
+		for pth, child := range m.nodes {
+			if strings.HasPrefix(pth, from+"/") {
+				delete(m.nodes, pth)
+				m.nodes[to+strings.TrimPrefix(pth, from)] = child
+			}
+		}
+	}
+	delete(m.nodes, from)
+	m.nodes[to] = n
+
+	now := m.now()
+	m.nodes[path.Dir(from)].mtime = now
+	dir.mtime = now
+	return nil
+}
+
+func (m *Memory) Chtimes(name string, atime, mtime time.Time) error {
+	name = Clean(name)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	n, ok := m.nodes[name]
+	if !ok {
+		return pathError("chtimes", name, fs.ErrNotExist)
+	}
+
+	// access times aren't tracked
+	n.mtime = mtime
+	return nil
+}
+
+// info describes n as it is now, the caller holds the lock
+func (n *node) info(name string) fs.FileInfo {
+	if name == "/" {
+		name = "."
+	}
+
+	mode := fs.FileMode(0644)
+	if n.dir {
+		mode = fs.ModeDir | 0755
+	}
+
+	return memoryInfo{name: name, size: int64(len(n.data)), mode: mode, mtime: n.mtime}
+}
+
+type memoryInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (i memoryInfo) Name() string       { return i.name }
+func (i memoryInfo) Size() int64        { return i.size }
+func (i memoryInfo) Mode() fs.FileMode  { return i.mode }
+func (i memoryInfo) ModTime() time.Time { return i.mtime }
+func (i memoryInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memoryInfo) Sys() any           { return nil }
+
+// memoryFile is an open file of a Memory, reads and writes go straight
+// to the node so they're seen by every other open file
+type memoryFile struct {
+	m    *Memory
+	n    *node
+	name string
+
+	offset int64
+	closed bool
+
+	read, write, append bool
+}
+
+func (f *memoryFile) Read(b []byte) (int, error) {
+	if f.closed {
+		return 0, pathError("read", f.name, fs.ErrClosed)
+	}
+	if !f.read {
+		return 0, pathError("read", f.name, fs.ErrPermission)
+	}
+
+	f.m.mutex.RLock()
+	defer f.m.mutex.RUnlock()
+
+	if f.offset >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.n.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memoryFile) Write(b []byte) (int, error) {
+	if f.closed {
+		return 0, pathError("write", f.name, fs.ErrClosed)
+	}
+	if !f.write {
+		return 0, pathError("write", f.name, fs.ErrPermission)
+	}
+
+	f.m.mutex.Lock()
+	defer f.m.mutex.Unlock()
+
+	if f.append {
+		f.offset = int64(len(f.n.data))
+	}
+
+	// writing past the end leaves a hole of zeros, as it would on disk
+	if end := f.offset + int64(len(b)); end > int64(len(f.n.data)) {
+		f.n.data = append(f.n.data, make([]byte, end-int64(len(f.n.data)))...)
+	}
+
+	copy(f.n.data[f.offset:], b)
+	f.offset += int64(len(b))
+	f.n.mtime = f.m.now()
+	return len(b), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, pathError("seek", f.name, fs.ErrClosed)
+	}
+
+	f.m.mutex.RLock()
+	size := int64(len(f.n.data))
+	f.m.mutex.RUnlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, pathError("seek", f.name, fs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, pathError("seek", f.name, fs.ErrInvalid)
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memoryFile) Close() error {
+	if f.closed {
+		return pathError("close", f.name, fs.ErrClosed)
+	}
+
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,239 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ticking returns a clock that moves a second forward every time it's read
+func ticking(start time.Time) func() time.Time {
+	var mutex sync.Mutex
+	return func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		start = start.Add(time.Second)
+		return start
+	}
+}
+
+func write(t *testing.T, open func(string) (File, error), name, contents string) {
+	file, err := open(name)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.WriteString(file, contents); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+}
+
+func read(t *testing.T, m *Memory, name string) string {
+	file, err := m.Open(name)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer file.Close()
+
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	return string(contents)
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	if err := m.Mkdir("/docs"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	write(t, m.Create, "/docs/notes.txt", "hello")
+	write(t, m.Append, "docs/../docs/notes.txt", " world")
+	if contents := read(t, m, "/docs/notes.txt"); contents != "hello world" {
+		t.Errorf("Expected: %s, but got %s", "hello world", contents)
+	}
+
+	write(t, m.Create, "/docs/notes.txt", "bye")
+	if contents := read(t, m, "/docs/notes.txt"); contents != "bye" {
+		t.Errorf("Expected Create to truncate, but got %s", contents)
+	}
+
+	if err := m.Rename("/docs", "/archive"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	if contents := read(t, m, "/archive/notes.txt"); contents != "bye" {
+		t.Errorf("Expected the directory to be moved along with its files, but got %s", contents)
+	}
+
+	infos, err := m.ReadDir("/")
+	if err != nil || len(infos) != 1 || infos[0].Name() != "archive" || !infos[0].IsDir() {
+		t.Errorf("Expected only archive, but got %v (%v)", infos, err)
+	}
+
+	if err := m.Remove("/archive"); err == nil {
+		t.Errorf("Expected a non empty directory not to be removed")
+	}
+
+	if err := m.Remove("/archive/notes.txt"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	if err := m.Remove("/archive"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+}
+
+var memoryErrorTestCases = []struct {
+	TestName string
+	Op       func(m *Memory) error
+	Expected error
+}{
+	{
+		TestName: "Test_Open_Missing",
+		Op:       func(m *Memory) error { _, err := m.Open("/missing"); return err },
+		Expected: fs.ErrNotExist,
+	},
+	{
+		TestName: "Test_Create_In_Missing_Directory",
+		Op:       func(m *Memory) error { _, err := m.Create("/missing/file"); return err },
+		Expected: fs.ErrNotExist,
+	},
+	{
+		TestName: "Test_Mkdir_Existing",
+		Op:       func(m *Memory) error { return m.Mkdir("/dir") },
+		Expected: fs.ErrExist,
+	},
+	{
+		TestName: "Test_Rename_Over_Directory",
+		Op:       func(m *Memory) error { return m.Rename("/dir/file", "/dir") },
+		Expected: fs.ErrExist,
+	},
+	{
+		TestName: "Test_Rename_Into_Itself",
+		Op:       func(m *Memory) error { return m.Rename("/dir", "/dir/sub") },
+		Expected: fs.ErrInvalid,
+	},
+	{
+		TestName: "Test_Remove_Root",
+		Op:       func(m *Memory) error { return m.Remove("/") },
+		Expected: fs.ErrNotExist,
+	},
+}
+
+func TestMemoryErrors(t *testing.T) {
+	for _, testcase := range memoryErrorTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			m := NewMemory()
+			m.Mkdir("/dir")
+			write(t, m.Create, "/dir/file", "")
+
+			if err := testcase.Op(m); !errors.Is(err, testcase.Expected) {
+				t.Errorf("Expected: %v, but got %v", testcase.Expected, err)
+			}
+		})
+	}
+}
+
+func TestMemoryTimesAndSizes(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory()
+	m.now = ticking(start)
+	m.Mkdir("/dir")
+
+	file, err := m.Create("/dir/file")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	created, _ := m.Stat("/dir/file")
+
+	io.WriteString(file, "12345")
+	written, _ := m.Stat("/dir/file")
+	if written.Size() != 5 || !written.ModTime().After(created.ModTime()) {
+		t.Errorf("Expected writes to grow the file and move its mtime, but got %d bytes at %v", written.Size(), written.ModTime())
+	}
+
+	// reading and seeking don't count as modifications
+	file.Seek(-2, io.SeekEnd)
+	io.WriteString(file, "xyz")
+	file.Seek(0, io.SeekStart)
+	io.ReadAll(file)
+	file.Close()
+
+	info, _ := m.Stat("/dir/file")
+	if contents := read(t, m, "/dir/file"); info.Size() != 6 || contents != "123xyz" {
+		t.Errorf("Expected: %s, but got %s (%d bytes)", "123xyz", contents, info.Size())
+	}
+	if mtime, _ := m.Stat("/dir/file"); !mtime.ModTime().Equal(info.ModTime()) {
+		t.Errorf("Expected reads to leave the mtime alone, but got %v", mtime.ModTime())
+	}
+
+	dir, _ := m.Stat("/dir")
+	if !dir.ModTime().Equal(created.ModTime()) {
+		t.Errorf("Expected the directory mtime to be when the file was created %v, but got %v", created.ModTime(), dir.ModTime())
+	}
+
+	if err := m.Chtimes("/dir/file", start, start); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	if info, _ := m.Stat("/dir/file"); !info.ModTime().Equal(start) {
+		t.Errorf("Expected: %v, but got %v", start, info.ModTime())
+	}
+}
+
+func TestMemoryOpenFileOutlivesRemove(t *testing.T) {
+	m := NewMemory()
+	write(t, m.Create, "/file", "still here")
+
+	file, err := m.Open("/file")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer file.Close()
+
+	m.Remove("/file")
+
+	if contents, _ := io.ReadAll(file); string(contents) != "still here" {
+		t.Errorf("Expected: %s, but got %s", "still here", contents)
+	}
+}
+
+func TestMemoryConcurrentUse(t *testing.T) {
+	m := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("/%d", i)
+			for j := 0; j < 100; j++ {
+				file, err := m.Append(name)
+				if err != nil {
+					t.Errorf("Expected nil error, but got %v", err)
+					return
+				}
+				io.WriteString(file, "x")
+				file.Close()
+				m.ReadDir("/")
+				m.Stat(name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	infos, _ := m.ReadDir("/")
+	for _, info := range infos {
+		if info.Size() != 100 {
+			t.Errorf("Expected %s to be 100 bytes, but got %d", info.Name(), info.Size())
+		}
+	}
+	if len(infos) != 16 {
+		t.Errorf("Expected: %d, but got %d", 16, len(infos))
+	}
+}
@@ -0,0 +1,283 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrCrossMount is returned when renaming from one mount into another
+var ErrCrossMount = errors.New("can't rename across mounts")
+
+// Mount serves FS at Path within a Mounts
+type Mount struct {
+	Path     string
+	FS       Filesystem
+	ReadOnly bool
+}
+
+// Mounts joins filesystems into a single tree, every name is served by the mount
+// with the longest path containing it, and the directories leading up to a mount
+// point exist even when no mount holds them
+//
+// mount points can't be removed or renamed, and nothing can be renamed across them
+type Mounts struct {
+	// longest path first, so the first match is the innermost mount
+	mounts []Mount
+}
+
+func NewMounts(mounts ...Mount) (*Mounts, error) {
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.Path, "/") || Clean(m.Path) != m.Path {
+			return nil, fmt.Errorf("mount path %q has to be absolute and clean", m.Path)
+		}
+		if seen[m.Path] {
+			return nil, fmt.Errorf("%s is mounted more than once", m.Path)
+		}
+		if m.FS == nil {
+			return nil, fmt.Errorf("nothing is mounted at %s", m.Path)
+		}
+		seen[m.Path] = true
+	}
+
+	sorted := append([]Mount(nil), mounts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].Path) > len(sorted[j].Path)
+	})
+
+	return &Mounts{mounts: sorted}, nil
+}
+
+// under reports whether name is dir or within it
+func under(name, dir string) bool {
+	return dir == "/" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// find returns the mount serving name and the name within it, nil when there's none
+func (m *Mounts) find(name string) (*Mount, string) {
+	name = Clean(name)
+	for i, mount := range m.mounts {
+		if under(name, mount.Path) {
+			inner := strings.TrimPrefix(name, strings.TrimSuffix(mount.Path, "/"))
+			return &m.mounts[i], Clean(inner)
+		}
+	}
+
+	return nil, ""
+}
+
+// children are the names directly within dir that lead to a mount point
+func (m *Mounts) children(dir string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, mount := range m.mounts {
+		if mount.Path == dir || !under(mount.Path, dir) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(strings.TrimPrefix(mount.Path, dir), "/")
+		child, _, _ := strings.Cut(rest, "/")
+		if !seen[child] {
+			seen[child] = true
+			names = append(names, child)
+		}
+	}
+
+	return names
+}
+
+// pinned reports whether name is a mount point or leads up to one, which can't be changed
+func (m *Mounts) pinned(name string) bool {
+	for _, mount := range m.mounts {
+		if under(mount.Path, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writable returns the mount name can be changed through
+func (m *Mounts) writable(op, name string) (*Mount, string, error) {
+	name = Clean(name)
+	mount, inner := m.find(name)
+	switch {
+	case mount == nil:
+		return nil, "", pathError(op, name, fs.ErrNotExist)
+	case mount.ReadOnly:
+		return nil, "", pathError(op, name, fs.ErrPermission)
+	}
+
+	return mount, inner, nil
+}
+
+// renamed reports a FileInfo under the name it has within the Mounts
+type renamed struct {
+	fs.FileInfo
+	name string
+}
+
+func (r renamed) Name() string {
+	return r.name
+}
+
+func (m *Mounts) Stat(name string) (fs.FileInfo, error) {
+	name = Clean(name)
+	base := path.Base(name)
+	if name == "/" {
+		base = "."
+	}
+
+	mount, inner := m.find(name)
+	if mount != nil {
+		info, err := mount.FS.Stat(inner)
+		if err == nil {
+			return renamed{info, base}, nil
+		}
+		if len(m.children(name)) == 0 {
+			return nil, err
+		}
+	}
+
+	if len(m.children(name)) > 0 {
+		return memoryInfo{name: base, mode: fs.ModeDir | 0555}, nil
+	}
+
+	return nil, pathError("stat", name, fs.ErrNotExist)
+}
+
+// ReadDir lists the entries of the mount serving name, along with the mount
+// points within it which take the place of entries of the same name
+func (m *Mounts) ReadDir(name string) ([]fs.FileInfo, error) {
+	name = Clean(name)
+	children := m.children(name)
+
+	var infos []fs.FileInfo
+	mount, inner := m.find(name)
+	if mount != nil {
+		var err error
+		if infos, err = mount.FS.ReadDir(inner); err != nil && len(children) == 0 {
+			return nil, err
+		}
+	} else if len(children) == 0 {
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+
+	shadowed := make(map[string]bool)
+	for _, child := range children {
+		shadowed[child] = true
+	}
+
+	merged := make([]fs.FileInfo, 0, len(infos)+len(children))
+	for _, info := range infos {
+		if !shadowed[info.Name()] {
+			merged = append(merged, info)
+		}
+	}
+	for _, child := range children {
+		info, err := m.Stat(path.Join(name, child))
+		if err != nil {
+			// the mounted filesystem is unavailable
+			continue
+		}
+		merged = append(merged, info)
+	}
+	sortByName(merged)
+
+	return merged, nil
+}
+
+func (m *Mounts) Open(name string) (File, error) {
+	mount, inner := m.find(name)
+	if mount == nil {
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+
+	return mount.FS.Open(inner)
+}
+
+func (m *Mounts) Create(name string) (File, error) {
+	mount, inner, err := m.writable("create", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return mount.FS.Create(inner)
+}
+
+// CreatesAtomically asks the mount that would hold name
+func (m *Mounts) CreatesAtomically(name string) bool {
+	mount, inner := m.find(name)
+	return mount != nil && CreatesAtomically(mount.FS, inner)
+}
+
+func (m *Mounts) Append(name string) (File, error) {
+	mount, inner, err := m.writable("append", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return mount.FS.Append(inner)
+}
+
+func (m *Mounts) Mkdir(name string) error {
+	if m.pinned(Clean(name)) {
+		return pathError("mkdir", name, fs.ErrExist)
+	}
+
+	mount, inner, err := m.writable("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return mount.FS.Mkdir(inner)
+}
+
+func (m *Mounts) Remove(name string) error {
+	if m.pinned(Clean(name)) {
+		return pathError("remove", name, fs.ErrPermission)
+	}
+
+	mount, inner, err := m.writable("remove", name)
+	if err != nil {
+		return err
+	}
+
+	return mount.FS.Remove(inner)
+}
+
+func (m *Mounts) Rename(from, to string) error {
+	if m.pinned(Clean(from)) || m.pinned(Clean(to)) {
+		return pathError("rename", from, fs.ErrPermission)
+	}
+
+	source, innerFrom, err := m.writable("rename", from)
+	if err != nil {
+		return err
+	}
+
+	target, innerTo, err := m.writable("rename", to)
+	if err != nil {
+		return err
+	}
+
+	if source != target {
+		return pathError("rename", from, ErrCrossMount)
+	}
+
+	return source.FS.Rename(innerFrom, innerTo)
+}
+
+func (m *Mounts) Chtimes(name string, atime, mtime time.Time) error {
+	mount, inner, err := m.writable("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	return mount.FS.Chtimes(inner, atime, mtime)
+}
@@ -0,0 +1,172 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// setupMounts serves a writable root, a read-only /public and /archive/2024 from another filesystem
+func setupMounts(t *testing.T) (*Mounts, *Memory, *Memory, *Memory) {
+	root, public, archive := NewMemory(), NewMemory(), NewMemory()
+	write(t, root.Create, "/notes.txt", "root")
+	root.Mkdir("/public")
+	write(t, root.Create, "/public/shadowed.txt", "hidden by the mount")
+	write(t, public.Create, "/readme.txt", "public")
+	write(t, archive.Create, "/old.txt", "archived")
+
+	m, err := NewMounts(
+		Mount{Path: "/", FS: root},
+		Mount{Path: "/public", FS: public, ReadOnly: true},
+		Mount{Path: "/archive/2024", FS: archive},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, root, public, archive
+}
+
+func names(infos []fs.FileInfo) string {
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestMountsResolve(t *testing.T) {
+	m, _, _, _ := setupMounts(t)
+
+	for name, expected := range map[string]string{
+		"/notes.txt":            "root",
+		"/public/readme.txt":    "public",
+		"/archive/2024/old.txt": "archived",
+	} {
+		file, err := m.Open(name)
+		if err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		contents := make([]byte, 16)
+		n, _ := file.Read(contents)
+		file.Close()
+		if string(contents[:n]) != expected {
+			t.Errorf("Expected %s to read %s, but got %s", name, expected, contents[:n])
+		}
+	}
+
+	// mount points are listed in place of what the parent holds under the same name
+	infos, err := m.ReadDir("/")
+	if err != nil || names(infos) != "archive notes.txt public" {
+		t.Errorf("Expected: %s, but got %s (%v)", "archive notes.txt public", names(infos), err)
+	}
+
+	infos, err = m.ReadDir("/public")
+	if err != nil || names(infos) != "readme.txt" {
+		t.Errorf("Expected: %s, but got %s (%v)", "readme.txt", names(infos), err)
+	}
+
+	// /archive only exists as the way to /archive/2024
+	info, err := m.Stat("/archive")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Expected /archive to be a directory, but got %v (%v)", info, err)
+	}
+	infos, err = m.ReadDir("/archive")
+	if err != nil || names(infos) != "2024" {
+		t.Errorf("Expected: %s, but got %s (%v)", "2024", names(infos), err)
+	}
+}
+
+var mountsErrorTestCases = []struct {
+	TestName string
+	Op       func(m *Mounts) error
+	Expected error
+}{
+	{
+		TestName: "Test_Read_Only_Create",
+		Op:       func(m *Mounts) error { _, err := m.Create("/public/new.txt"); return err },
+		Expected: fs.ErrPermission,
+	},
+	{
+		TestName: "Test_Read_Only_Remove",
+		Op:       func(m *Mounts) error { return m.Remove("/public/readme.txt") },
+		Expected: fs.ErrPermission,
+	},
+	{
+		TestName: "Test_Remove_Mount_Point",
+		Op:       func(m *Mounts) error { return m.Remove("/archive/2024") },
+		Expected: fs.ErrPermission,
+	},
+	{
+		TestName: "Test_Remove_Leading_To_Mount_Point",
+		Op:       func(m *Mounts) error { return m.Remove("/archive") },
+		Expected: fs.ErrPermission,
+	},
+	{
+		TestName: "Test_Rename_Across_Mounts",
+		Op:       func(m *Mounts) error { return m.Rename("/notes.txt", "/archive/2024/notes.txt") },
+		Expected: ErrCrossMount,
+	},
+	{
+		TestName: "Test_Rename_Onto_Mount_Point",
+		Op:       func(m *Mounts) error { return m.Rename("/notes.txt", "/public") },
+		Expected: fs.ErrPermission,
+	},
+	{
+		TestName: "Test_Mkdir_Mount_Point",
+		Op:       func(m *Mounts) error { return m.Mkdir("/archive") },
+		Expected: fs.ErrExist,
+	},
+}
+
+func TestMountsErrors(t *testing.T) {
+	for _, testcase := range mountsErrorTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			m, _, _, _ := setupMounts(t)
+			if err := testcase.Op(m); !errors.Is(err, testcase.Expected) {
+				t.Errorf("Expected: %v, but got %v", testcase.Expected, err)
+			}
+		})
+	}
+}
+
+func TestMountsWrite(t *testing.T) {
+	m, _, _, archive := setupMounts(t)
+
+	write(t, m.Create, "/archive/2024/new.txt", "new")
+	if err := m.Rename("/archive/2024/new.txt", "/archive/2024/renamed.txt"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if contents := read(t, archive, "/renamed.txt"); contents != "new" {
+		t.Errorf("Expected the file to be written to the mounted filesystem, but got %q", contents)
+	}
+}
+
+func TestMountsCreatesAtomically(t *testing.T) {
+	_, s := newFakeS3(t)
+	m, err := NewMounts(Mount{Path: "/", FS: NewMemory()}, Mount{Path: "/bucket", FS: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{"/file.txt": false, "/bucket/file.txt": true} {
+		if atomic := CreatesAtomically(m, name); atomic != expected {
+			t.Errorf("Expected: %v, but got %v for %s", expected, atomic, name)
+		}
+	}
+}
+
+func TestNewMountsErrors(t *testing.T) {
+	for _, mounts := range [][]Mount{
+		{{Path: "public", FS: NewMemory()}},
+		{{Path: "/public/", FS: NewMemory()}},
+		{{Path: "/public", FS: NewMemory()}, {Path: "/public", FS: NewMemory()}},
+		{{Path: "/public"}},
+	} {
+		if _, err := NewMounts(mounts...); err == nil {
+			t.Errorf("Expected an error for %+v", mounts)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// OS serves a directory on disk, names are resolved through os.Root
+// so symlinks leading out of the directory aren't followed
+type OS struct {
+	dir string
+}
+
+// NewOS serves dir, which is reopened for every call rather than held
+// onto so that it can be swapped out from underneath a running server
+func NewOS(dir string) *OS {
+	return &OS{dir: dir}
+}
+
+// relative maps a virtual path onto one relative to the served directory
+func relative(name string) string {
+	if name = strings.TrimPrefix(Clean(name), "/"); name == "" {
+		return "."
+	}
+
+	return name
+}
+
+func (o *OS) do(f func(root *os.Root) error) error {
+	root, err := os.OpenRoot(o.dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return f(root)
+}
+
+func (o *OS) open(name string, flag int) (File, error) {
+	var file *os.File
+	err := o.do(func(root *os.Root) (err error) {
+		file, err = root.OpenFile(relative(name), flag, 0644)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (o *OS) Open(name string) (File, error) {
+	return o.open(name, os.O_RDONLY)
+}
+
+func (o *OS) Create(name string) (File, error) {
+	return o.open(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+func (o *OS) Append(name string) (File, error) {
+	return o.open(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+}
+
+func (o *OS) Stat(name string) (info fs.FileInfo, err error) {
+	err = o.do(func(root *os.Root) error {
+		info, err = root.Stat(relative(name))
+		return err
+	})
+	return info, err
+}
+
+func (o *OS) ReadDir(name string) (infos []fs.FileInfo, err error) {
+	err = o.do(func(root *os.Root) error {
+		dir, err := root.Open(relative(name))
+		if err != nil {
+			return err
+		}
+		defer dir.Close()
+
+		entries, err := dir.ReadDir(-1)
+		if err != nil {
+			return err
+		}
+
+		infos = make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			// removed since it was listed
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return nil
+	})
+	sortByName(infos)
+
+	return infos, err
+}
+
+func (o *OS) Mkdir(name string) error {
+	return o.do(func(root *os.Root) error {
+		return root.Mkdir(relative(name), 0755)
+	})
+}
+
+func (o *OS) Remove(name string) error {
+	return o.do(func(root *os.Root) error {
+		return root.Remove(relative(name))
+	})
+}
+
+func (o *OS) Rename(from, to string) error {
+	return o.do(func(root *os.Root) error {
+		return root.Rename(relative(from), relative(to))
+	})
+}
+
+func (o *OS) Chtimes(name string, atime, mtime time.Time) error {
+	return o.do(func(root *os.Root) error {
+		return root.Chtimes(relative(name), atime, mtime)
+	})
+}
@@ -0,0 +1,93 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOS(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOS(dir)
+
+	if err := o.Mkdir("/docs"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	for _, open := range []func(string) (File, error){o.Create, o.Append} {
+		file, err := open("/docs/../docs/notes.txt")
+		if err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		io.WriteString(file, "hi")
+		file.Close()
+	}
+
+	file, err := o.Open("docs/notes.txt")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	contents, _ := io.ReadAll(file)
+	file.Close()
+	if string(contents) != "hihi" {
+		t.Errorf("Expected: %s, but got %s", "hihi", contents)
+	}
+
+	mtime := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if err := o.Chtimes("/docs/notes.txt", mtime, mtime); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if err := o.Rename("/docs/notes.txt", "/notes.txt"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	info, err := o.Stat("/notes.txt")
+	if err != nil || info.Size() != 4 || !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected a 4 byte file modified at %v, but got %v (%v)", mtime, info, err)
+	}
+
+	infos, err := o.ReadDir("/")
+	if err != nil || len(infos) != 2 || infos[0].Name() != "docs" || infos[1].Name() != "notes.txt" {
+		t.Errorf("Expected docs and notes.txt, but got %v (%v)", infos, err)
+	}
+
+	if err := o.Remove("/docs"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	if _, err := o.Stat("/docs"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestOSStaysWithinDirectory(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	o := NewOS(dir)
+
+	// climbing above / lands back on it
+	file, err := o.Create("/../../created")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	file.Close()
+	if _, err := os.Stat(filepath.Join(dir, "created")); err != nil {
+		t.Errorf("Expected file to be created within %s, but got %v", dir, err)
+	}
+
+	if _, err := o.Open("/escape/secret"); err == nil {
+		t.Errorf("Expected symlink out of %s not to be followed", dir)
+	}
+}
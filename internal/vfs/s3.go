@@ -0,0 +1,731 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// S3 refuses multipart uploads with parts smaller than this, other than the last
+	minPartSize     = 5 << 20
+	defaultPartSize = 8 << 20
+)
+
+// S3Config describes an S3 compatible bucket, requests are path style
+// (https://endpoint/bucket/key) which every implementation understands
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// served as /, e.g. "ftp/" serves the objects under ftp/
+	Prefix string
+
+	// size of the parts uploads are streamed in, it's buffered in
+	// memory for each upload that's under way, defaults to 8MiB
+	PartSize int64
+}
+
+// S3 serves a bucket, directories map onto key prefixes ending in / and exist for as long
+// as there are objects under them, or a marker object of their own created by Mkdir
+//
+// uploads are streamed as multipart uploads a part at a time, reads are ranged GETs
+// starting wherever the file was last seeked to, there's no appending to objects
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	partSize int64
+
+	client *http.Client
+	signer *signer
+	now    func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint %q has to be an http(s) URL", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, errors.New("a bucket is required")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	} else if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("parts can't be smaller than %d bytes", minPartSize)
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{
+		endpoint: endpoint,
+		bucket:   cfg.Bucket,
+		prefix:   prefix,
+		partSize: cfg.PartSize,
+		client:   http.DefaultClient,
+		signer: &signer{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    cfg.Region,
+			service:   "s3",
+		},
+		now: time.Now,
+	}, nil
+}
+
+// s3Error is an error response, 404s are fs.ErrNotExist and 403s fs.ErrPermission
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s %s", e.Status, e.Code, e.Message)
+}
+
+func (e *s3Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Status == http.StatusNotFound
+	case fs.ErrPermission:
+		return e.Status == http.StatusForbidden
+	}
+	return false
+}
+
+// key is the object key of a virtual path, the root maps onto the prefix
+func (s *S3) key(name string) string {
+	return s.prefix + strings.TrimPrefix(Clean(name), "/")
+}
+
+// dir is the prefix the entries of the directory name are found under
+func (s *S3) dir(name string) string {
+	if key := s.key(name); key != s.prefix {
+		return key + "/"
+	}
+	return s.prefix
+}
+
+// do sends a signed request for key, the caller closes the body of the response
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = "/" + s.bucket + "/" + key
+	target.RawPath = "/" + uriEncode(s.bucket, false) + "/" + uriEncode(key, true)
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.signer.sign(req, hashHex(body), s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseError(resp)
+	}
+
+	return resp, nil
+}
+
+func parseError(resp *http.Response) error {
+	e := &s3Error{Status: resp.StatusCode}
+	b, _ := io.ReadAll(resp.Body)
+	xml.Unmarshal(b, e)
+	return e
+}
+
+// checked reads the body of a response that can carry an error even though it's a 200,
+// which is the case for copying objects and completing multipart uploads
+func checked(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(b, []byte("<Error>")) {
+		e := &s3Error{Status: resp.StatusCode}
+		xml.Unmarshal(b, e)
+		return e
+	}
+
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(b, v)
+}
+
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list walks the keys under prefix, max at a time (0 for as many as S3 returns), with
+// a delimiter of / what's below the first level is rolled up into common prefixes
+func (s *S3) list(prefix, delimiter string, max int, each func(*listResult) bool) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if max > 0 {
+			query.Set("max-keys", strconv.Itoa(max))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		var result listResult
+		if err := checked(resp, &result); err != nil {
+			return err
+		}
+
+		if !each(&result) || !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Stat(name string) (fs.FileInfo, error) {
+	base := path.Base(Clean(name))
+	if base == "/" {
+		return memoryInfo{name: ".", mode: fs.ModeDir | 0755}, nil
+	}
+
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return memoryInfo{name: base, size: resp.ContentLength, mode: 0644, mtime: mtime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, pathError("stat", name, err)
+	}
+
+	// directories only exist through the keys under them
+	var info fs.FileInfo
+	err = s.list(s.dir(name), "", 1, func(result *listResult) bool {
+		if len(result.Contents) > 0 {
+			info = memoryInfo{name: base, mode: fs.ModeDir | 0755, mtime: result.Contents[0].LastModified}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	if info == nil {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+
+	return info, nil
+}
+
+// isDir reports whether name is an existing directory
+func (s *S3) isDir(name string) bool {
+	info, err := s.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+func (s *S3) ReadDir(name string) ([]fs.FileInfo, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, pathError("readdir", name, errNotDir)
+	}
+
+	prefix := s.dir(name)
+	var infos []fs.FileInfo
+	err = s.list(prefix, "/", 0, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			// the marker of the directory itself
+			if object.Key == prefix {
+				continue
+			}
+			infos = append(infos, memoryInfo{
+				name:  strings.TrimPrefix(object.Key, prefix),
+				size:  object.Size,
+				mode:  0644,
+				mtime: object.LastModified,
+			})
+		}
+		for _, common := range result.CommonPrefixes {
+			infos = append(infos, memoryInfo{
+				name: strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/"),
+				mode: fs.ModeDir | 0755,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	sortByName(infos)
+
+	return infos, nil
+}
+
+func (s *S3) Mkdir(name string) error {
+	if _, err := s.Stat(name); err == nil {
+		return pathError("mkdir", name, fs.ErrExist)
+	}
+
+	if !s.isDir(path.Dir(Clean(name))) {
+		return pathError("mkdir", name, fs.ErrNotExist)
+	}
+
+	resp, err := s.do(http.MethodPut, s.dir(name), nil, nil, nil)
+	if err != nil {
+		return pathError("mkdir", name, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *S3) delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Remove(name string) error {
+	if Clean(name) == "/" {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		if err := s.delete(s.key(name)); err != nil {
+			return pathError("remove", name, err)
+		}
+		return nil
+	}
+
+	prefix := s.dir(name)
+	empty := true
+	// the marker of the directory itself comes first, anything after it means it isn't empty
+	err = s.list(prefix, "/", 2, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			empty = empty && object.Key == prefix
+		}
+		empty = empty && len(result.CommonPrefixes) == 0
+		return empty
+	})
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	if !empty {
+		return pathError("remove", name, errNotEmpty)
+	}
+
+	if err := s.delete(prefix); err != nil {
+		return pathError("remove", name, err)
+	}
+	return nil
+}
+
+func (s *S3) copy(from, to string) error {
+	header := http.Header{"X-Amz-Copy-Source": {"/" + uriEncode(s.bucket, false) + "/" + uriEncode(from, true)}}
+	resp, err := s.do(http.MethodPut, to, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	return checked(resp, nil)
+}
+
+// Rename copies objects to their new keys before deleting the old ones, which
+// S3 has no way of doing atomically, directories are moved one object at a time
+func (s *S3) Rename(from, to string) error {
+	from, to = Clean(from), Clean(to)
+	info, err := s.Stat(from)
+	if err != nil || from == "/" {
+		return pathError("rename", from, fs.ErrNotExist)
+	}
+	if from == to {
+		return nil
+	}
+
+	if existing, err := s.Stat(to); err == nil && (existing.IsDir() || info.IsDir()) {
+		return pathError("rename", to, fs.ErrExist)
+	}
+
+	if !s.isDir(path.Dir(to)) {
+		return pathError("rename", to, fs.ErrNotExist)
+	}
+
+	if !info.IsDir() {
+		if err := s.copy(s.key(from), s.key(to)); err != nil {
+			return pathError("rename", from, err)
+		}
+		if err := s.delete(s.key(from)); err != nil {
+			return pathError("rename", from, err)
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(to, from+"/") {
+		return pathError("rename", to, fs.ErrInvalid)
+	}
+
+	var keys []string
+	err = s.list(s.dir(from), "", 0, func(result *listResult) bool {
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return pathError("rename", from, err)
+	}
+
+	for _, key := range keys {
+		if err := s.copy(key, s.dir(to)+strings.TrimPrefix(key, s.dir(from))); err != nil {
+			return pathError("rename", from, err)
+		}
+	}
+	for _, key := range keys {
+		if err := s.delete(key); err != nil {
+			return pathError("rename", from, err)
+		}
+	}
+
+	return nil
+}
+
+// Chtimes isn't supported, objects are stamped with the time they were uploaded
+func (s *S3) Chtimes(name string, atime, mtime time.Time) error {
+	return pathError("chtimes", name, ErrUnsupported)
+}
+
+func (s *S3) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, pathError("open", name, errIsDir)
+	}
+
+	return &s3Reader{s: s, name: name, key: s.key(name), size: info.Size()}, nil
+}
+
+func (s *S3) Create(name string) (File, error) {
+	if s.isDir(name) {
+		return nil, pathError("create", name, errIsDir)
+	}
+
+	if !s.isDir(path.Dir(Clean(name))) {
+		return nil, pathError("create", name, fs.ErrNotExist)
+	}
+
+	return &s3Writer{s: s, name: name, key: s.key(name)}, nil
+}
+
+// CreatesAtomically is always true, an object is only created by the request that completes its upload
+func (s *S3) CreatesAtomically(name string) bool {
+	return true
+}
+
+// Append isn't supported, objects can only be replaced as a whole
+func (s *S3) Append(name string) (File, error) {
+	return nil, pathError("append", name, ErrUnsupported)
+}
+
+// s3Reader reads an object through a GET starting at the current offset,
+// which is reissued after seeking
+type s3Reader struct {
+	s    *S3
+	name string
+	key  string
+	size int64
+
+	offset int64
+	body   io.ReadCloser
+	closed bool
+}
+
+func (r *s3Reader) Read(b []byte) (int, error) {
+	if r.closed {
+		return 0, pathError("read", r.name, fs.ErrClosed)
+	}
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		var header http.Header
+		if r.offset > 0 {
+			header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+		}
+
+		resp, err := r.s.do(http.MethodGet, r.key, nil, header, nil)
+		if err != nil {
+			return 0, pathError("read", r.name, err)
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(b)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Write(b []byte) (int, error) {
+	return 0, pathError("write", r.name, fs.ErrPermission)
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, pathError("seek", r.name, fs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, pathError("seek", r.name, fs.ErrInvalid)
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.closed {
+		return pathError("close", r.name, fs.ErrClosed)
+	}
+
+	r.closed = true
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3Writer buffers a part at a time, the object is only created once it's closed,
+// small objects are uploaded with a single PUT and larger ones as a multipart upload
+type s3Writer struct {
+	s    *S3
+	name string
+	key  string
+
+	buffer   []byte
+	written  int64
+	uploadID string
+	parts    []completedPart
+
+	// set once anything fails, the upload is aborted and can't be recovered
+	err    error
+	closed bool
+}
+
+func (w *s3Writer) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, pathError("write", w.name, fs.ErrClosed)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buffer = append(w.buffer, b...)
+	for int64(len(w.buffer)) >= w.s.partSize {
+		if err := w.upload(w.buffer[:w.s.partSize]); err != nil {
+			w.abort(err)
+			return 0, w.err
+		}
+		w.buffer = append([]byte(nil), w.buffer[w.s.partSize:]...)
+	}
+
+	w.written += int64(len(b))
+	return len(b), nil
+}
+
+// upload sends the next part, starting the multipart upload with the first one
+func (w *s3Writer) upload(part []byte) error {
+	if w.uploadID == "" {
+		resp, err := w.s.do(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		var initiated struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := checked(resp, &initiated); err != nil {
+			return err
+		}
+		w.uploadID = initiated.UploadID
+	}
+
+	number := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+	resp, err := w.s.do(http.MethodPut, w.key, query, nil, part)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (w *s3Writer) abort(err error) {
+	w.err = pathError("write", w.name, err)
+	if w.uploadID == "" {
+		return
+	}
+
+	resp, err := w.s.do(http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Abort throws away everything written, leaving whatever object was at the key as it was
+func (w *s3Writer) Abort() error {
+	if w.closed {
+		return pathError("abort", w.name, fs.ErrClosed)
+	}
+	w.closed = true
+	w.buffer = nil
+
+	if w.err == nil {
+		w.abort(fs.ErrClosed)
+	}
+	return nil
+}
+
+func (w *s3Writer) Read(b []byte) (int, error) {
+	return 0, pathError("read", w.name, fs.ErrPermission)
+}
+
+// Seek can only tell how much has been written, uploads are strictly sequential
+func (w *s3Writer) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return w.written, nil
+	}
+
+	return 0, pathError("seek", w.name, ErrUnsupported)
+}
+
+// Close creates the object from everything written
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return pathError("close", w.name, fs.ErrClosed)
+	}
+	w.closed = true
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.uploadID == "" {
+		resp, err := w.s.do(http.MethodPut, w.key, nil, nil, w.buffer)
+		if err != nil {
+			return pathError("close", w.name, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if len(w.buffer) > 0 {
+		if err := w.upload(w.buffer); err != nil {
+			w.abort(err)
+			return w.err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return pathError("close", w.name, err)
+	}
+
+	resp, err := w.s.do(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, nil, body)
+	if err == nil {
+		err = checked(resp, nil)
+	}
+	if err != nil {
+		w.abort(err)
+		return w.err
+	}
+
+	return nil
+}
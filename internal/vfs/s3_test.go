@@ -0,0 +1,412 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is just enough of S3 for a single bucket, it records the requests it's sent
+type fakeS3 struct {
+	mutex    sync.Mutex
+	bucket   string
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []string
+}
+
+type fakeObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int       `xml:"Size"`
+}
+
+var fakeModified = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	fake := &fakeS3{bucket: "ftp", objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3(S3Config{Endpoint: server.URL, Bucket: "ftp", AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, s
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		f.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	query := r.URL.Query()
+	request := r.Method + " " + key
+	if rng := r.Header.Get("Range"); rng != "" {
+		request += " " + rng
+	}
+	if _, ok := query["uploads"]; ok {
+		request += " uploads"
+	}
+	if part := query.Get("partNumber"); part != "" {
+		request += " part " + part
+	}
+	f.requests = append(f.requests, request)
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"), query.Get("delimiter"), query.Get("continuation-token"), query.Get("max-keys"))
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var completed struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		xml.Unmarshal(body, &completed)
+
+		var object []byte
+		for i, part := range completed.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"%d"`, i+1) {
+				f.fail(w, http.StatusOK, "InvalidPart")
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := strings.CutPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"+f.bucket+"/")
+		object, ok := f.objects[source]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = object
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Last-Modified", fakeModified.Format(http.TimeFormat))
+		http.ServeContent(w, r, key, fakeModified, bytes.NewReader(object))
+
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// list pages through the keys, the continuation token is the last key of the previous page
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter, token, maxKeys string) {
+	max, err := strconv.Atoi(maxKeys)
+	if err != nil {
+		max = 2
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result struct {
+		XMLName               xml.Name     `xml:"ListBucketResult"`
+		IsTruncated           bool         `xml:"IsTruncated"`
+		NextContinuationToken string       `xml:"NextContinuationToken"`
+		Contents              []fakeObject `xml:"Contents"`
+		CommonPrefixes        []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if len(result.Contents)+len(result.CommonPrefixes) == max {
+			result.IsTruncated = true
+			break
+		}
+		result.NextContinuationToken = key
+
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			common := key[:len(prefix)+i+1]
+			if !seen[common] {
+				seen[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{common})
+			}
+			continue
+		}
+
+		result.Contents = append(result.Contents, fakeObject{Key: key, LastModified: fakeModified, Size: len(f.objects[key])})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) requested(prefix string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var matched []string
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, prefix) {
+			matched = append(matched, request)
+		}
+	}
+	return matched
+}
+
+func TestS3Directories(t *testing.T) {
+	fake, s := newFakeS3(t)
+	fake.objects["implied/file.txt"] = []byte("hello")
+
+	if err := s.Mkdir("/docs"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	if err := s.Mkdir("/docs"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrExist, err)
+	}
+	if err := s.Mkdir("/missing/docs"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrNotExist, err)
+	}
+
+	write(t, s.Create, "/docs/a.txt", "a")
+	write(t, s.Create, "/docs/b.txt", "bb")
+	write(t, s.Create, "/top.txt", "top")
+
+	// pages of two entries make sure continuation tokens are followed
+	infos, err := s.ReadDir("/")
+	var names []string
+	for _, info := range infos {
+		names = append(names, fmt.Sprintf("%s:%t", info.Name(), info.IsDir()))
+	}
+	if err != nil || strings.Join(names, " ") != "docs:true implied:true top.txt:false" {
+		t.Errorf("Expected docs, implied and top.txt, but got %v (%v)", names, err)
+	}
+
+	infos, err = s.ReadDir("/docs")
+	if err != nil || len(infos) != 2 || infos[1].Name() != "b.txt" || infos[1].Size() != 2 {
+		t.Errorf("Expected a.txt and b.txt, but got %v (%v)", infos, err)
+	}
+
+	if err := s.Remove("/docs"); err == nil {
+		t.Errorf("Expected a non empty directory not to be removed")
+	}
+
+	if err := s.Rename("/docs", "/archive"); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	if _, ok := fake.objects["archive/b.txt"]; !ok || fake.objects["docs/"] != nil {
+		t.Errorf("Expected every object to move under archive/, but got %v", fake.objects)
+	}
+
+	for _, name := range []string{"/archive/a.txt", "/archive/b.txt", "/archive"} {
+		if err := s.Remove(name); err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+	}
+
+	if _, err := s.Stat("/archive"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	fake, s := newFakeS3(t)
+
+	write(t, s.Create, "/small.txt", "small")
+	if string(fake.objects["small.txt"]) != "small" || len(fake.requested("POST")) != 0 {
+		t.Errorf("Expected a single PUT, but got %v", fake.requests)
+	}
+
+	// two full parts and a short one, written in pieces that don't line up with them
+	s.partSize = 4
+	write(t, s.Create, "/large.txt", "0123456789")
+	if string(fake.objects["large.txt"]) != "0123456789" {
+		t.Errorf("Expected: %s, but got %s", "0123456789", fake.objects["large.txt"])
+	}
+	if parts := fake.requested("PUT large.txt part"); len(parts) != 3 {
+		t.Errorf("Expected 3 parts, but got %v", parts)
+	}
+
+	if _, err := s.Append("/large.txt"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected: %v, but got %v", ErrUnsupported, err)
+	}
+
+	if _, err := s.Create("/missing/file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestS3UploadAborted(t *testing.T) {
+	fake, s := newFakeS3(t)
+	s.partSize = 4
+
+	file, err := s.Create("/large.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "012345")
+
+	// the upload is lost from underneath the writer
+	fake.mutex.Lock()
+	clear(fake.uploads)
+	fake.mutex.Unlock()
+
+	if _, err := io.WriteString(file, "6789"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the failed part to be reported, but got %v", err)
+	}
+	if err := file.Close(); err == nil {
+		t.Errorf("Expected Close to report the failed upload")
+	}
+	if _, ok := fake.objects["large.txt"]; ok {
+		t.Errorf("Expected no object to be created")
+	}
+}
+
+func TestS3Abort(t *testing.T) {
+	fake, s := newFakeS3(t)
+	fake.objects["small.txt"] = []byte("old")
+	fake.objects["large.txt"] = []byte("old")
+	s.partSize = 4
+
+	for name, contents := range map[string]string{"/small.txt": "012", "/large.txt": "0123456789"} {
+		file, err := s.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(file, contents)
+
+		if err := Abort(file); err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		if err := file.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("Expected: %v, but got %v", fs.ErrClosed, err)
+		}
+	}
+
+	if string(fake.objects["small.txt"]) != "old" || string(fake.objects["large.txt"]) != "old" {
+		t.Errorf("Expected the objects to be left as they were, but got %v", fake.objects)
+	}
+	if len(fake.uploads) != 0 || len(fake.requested("DELETE large.txt")) != 1 {
+		t.Errorf("Expected the multipart upload to be aborted, but got %v", fake.requests)
+	}
+	if len(fake.requested("PUT small.txt")) != 0 {
+		t.Errorf("Expected nothing to be uploaded, but got %v", fake.requests)
+	}
+}
+
+func TestS3RangedRead(t *testing.T) {
+	fake, s := newFakeS3(t)
+	fake.objects["file.txt"] = []byte("0123456789")
+
+	file, err := s.Open("/file.txt")
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+
+	contents, err := io.ReadAll(file)
+	if err != nil || string(contents) != "6789" {
+		t.Errorf("Expected: %s, but got %s (%v)", "6789", contents, err)
+	}
+
+	if gets := fake.requested("GET file.txt"); len(gets) != 1 || gets[0] != "GET file.txt bytes=6-" {
+		t.Errorf("Expected a single ranged GET, but got %v", gets)
+	}
+
+	info, err := s.Stat("/file.txt")
+	if err != nil || info.Size() != 10 || !info.ModTime().Equal(fakeModified) {
+		t.Errorf("Expected a 10 byte file modified at %v, but got %v (%v)", fakeModified, info, err)
+	}
+
+	if _, err := s.Open("/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected: %v, but got %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestS3Prefix(t *testing.T) {
+	fake, s := newFakeS3(t)
+	s.prefix = "tenant/"
+	fake.objects["other.txt"] = []byte("not served")
+
+	write(t, s.Create, "/file.txt", "served")
+	if _, ok := fake.objects["tenant/file.txt"]; !ok {
+		t.Errorf("Expected tenant/file.txt, but got %v", fake.objects)
+	}
+
+	infos, err := s.ReadDir("/")
+	if err != nil || len(infos) != 1 || infos[0].Name() != "file.txt" {
+		t.Errorf("Expected only file.txt, but got %v (%v)", infos, err)
+	}
+}
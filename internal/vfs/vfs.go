@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// ErrUnsupported is returned for operations a Filesystem can't perform, such as appending to an object
+var ErrUnsupported = errors.New("operation not supported by this filesystem")
+
+// File is an open file, whether it can be read from or written to depends on how it was opened
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+// Syncer is implemented by files that can flush what was written to stable storage
+type Syncer interface {
+	Sync() error
+}
+
+// Aborter is implemented by files that only store what was written once they're closed,
+// Abort closes them throwing it away instead, for uploads that failed part way through
+type Aborter interface {
+	Abort() error
+}
+
+// Abort closes f throwing away what was written when it's an Aborter, it's closed otherwise
+func Abort(f File) error {
+	if aborter, ok := f.(Aborter); ok {
+		return aborter.Abort()
+	}
+	return f.Close()
+}
+
+// AtomicCreator is implemented by filesystems whose files only show up once what was
+// written to them is complete, there's no need for a temporary file to hide them until then
+type AtomicCreator interface {
+	CreatesAtomically(name string) bool
+}
+
+// CreatesAtomically reports whether a file created at name on fsys only shows up once it's complete
+func CreatesAtomically(fsys Filesystem, name string) bool {
+	creator, ok := fsys.(AtomicCreator)
+	return ok && creator.CreatesAtomically(name)
+}
+
+// TempCreator is implemented by filesystems that keep count of what's stored in them, a file
+// created at temp to be renamed over name once it's complete is counted as name from the start
+type TempCreator interface {
+	CreateTemp(name, temp string) (File, error)
+}
+
+// CreateTemp creates temp on fsys, to be renamed over name once what's written to it is complete
+func CreateTemp(fsys Filesystem, name, temp string) (File, error) {
+	if creator, ok := fsys.(TempCreator); ok {
+		return creator.CreateTemp(name, temp)
+	}
+	return fsys.Create(temp)
+}
+
+// Filesystem is the storage served to clients, every name is an absolute,
+// slash separated, virtual path such as /incoming/report.csv and it's up
+// to the implementation to keep clients within whatever it's serving
+type Filesystem interface {
+	// Open opens name for reading
+	Open(name string) (File, error)
+
+	// Create opens name for writing, truncating it when it already exists
+	Create(name string) (File, error)
+
+	// Append opens name for writing at its end, creating it when it doesn't exist
+	Append(name string) (File, error)
+
+	Stat(name string) (fs.FileInfo, error)
+
+	// ReadDir lists the entries of a directory sorted by name
+	ReadDir(name string) ([]fs.FileInfo, error)
+
+	Mkdir(name string) error
+	Remove(name string) error
+	Rename(from, to string) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// Clean turns name into the absolute virtual path it refers to, climbing
+// above / is not possible
+func Clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func sortByName(infos []fs.FileInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+}
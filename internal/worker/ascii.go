@@ -0,0 +1,66 @@
+package worker
+
+import "io"
+
+// asciiReader converts line endings for TYPE A transfers, files keep bare
+// line feeds while the data connection carries CRLF
+// https://www.rfc-editor.org/rfc/rfc959#section-3.1.1.1
+type asciiReader struct {
+	r io.Reader
+
+	// set for uploads, which turn CRLF into LF, downloads turn LF into CRLF
+	upload bool
+
+	// the last byte read was a CR, which uploads hold back until the next one is known
+	cr bool
+
+	scratch []byte
+	out     []byte
+	pending []byte
+	err     error
+}
+
+func newASCIIReader(r io.Reader, upload bool) *asciiReader {
+	return &asciiReader{r: r, upload: upload, scratch: make([]byte, 32*1024)}
+}
+
+func (a *asciiReader) Read(p []byte) (int, error) {
+	for len(a.pending) == 0 && a.err == nil {
+		n, err := a.r.Read(a.scratch)
+		a.out = a.out[:0]
+		for _, b := range a.scratch[:n] {
+			a.out = a.convert(a.out, b)
+		}
+		if err != nil && a.upload && a.cr {
+			// a CR at the very end isn't followed by anything
+			a.out, a.cr = append(a.out, '\r'), false
+		}
+		a.pending, a.err = a.out, err
+	}
+
+	n := copy(p, a.pending)
+	a.pending = a.pending[n:]
+	if len(a.pending) == 0 {
+		return n, a.err
+	}
+	return n, nil
+}
+
+func (a *asciiReader) convert(out []byte, b byte) []byte {
+	if !a.upload {
+		if b == '\n' && !a.cr {
+			out = append(out, '\r')
+		}
+		a.cr = b == '\r'
+		return append(out, b)
+	}
+
+	if a.cr && b != '\n' {
+		out = append(out, '\r')
+	}
+	a.cr = b == '\r'
+	if a.cr {
+		return out
+	}
+	return append(out, b)
+}
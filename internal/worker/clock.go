@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// Timeouts bound how long a session waits on its client, a zero value disables that timeout
+type Timeouts struct {
+	// how long the control connection may go without a command, outside of
+	// transfers, before the session is closed with a 421
+	Idle time.Duration
+
+	// how long after PASV/PORT the data connection has to be established
+	// and then taken up by a transfer before it's dropped
+	DataConnect time.Duration
+
+	// how long a transfer may go without any data moving before it's aborted
+	Stall time.Duration
+}
+
+// DefaultTimeouts only keeps unused data connections from lingering
+var DefaultTimeouts = Timeouts{DataConnect: 3 * time.Minute}
+
+// clock is how sessions tell the time, so that tests can move it along
+type clock interface {
+	Now() time.Time
+
+	// After closes the returned channel once d has passed, unless stopped first
+	After(d time.Duration) (<-chan struct{}, func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) (<-chan struct{}, func()) {
+	expired := make(chan struct{})
+	timer := time.AfterFunc(d, func() { close(expired) })
+	return expired, func() { timer.Stop() }
+}
+
+// timeout is clock.After for an optional timeout, a disabled one never expires
+func timeout(c clock, d time.Duration) (<-chan struct{}, func()) {
+	if d <= 0 {
+		return nil, func() {}
+	}
+
+	return c.After(d)
+}
+
+// stallConn pushes its deadlines out on every read and write, so that it
+// only times out when the peer stops sending or receiving altogether
+type stallConn struct {
+	net.Conn
+	clock clock
+	stall time.Duration
+}
+
+func (s *stallConn) Read(b []byte) (int, error) {
+	if err := s.Conn.SetReadDeadline(s.clock.Now().Add(s.stall)); err != nil {
+		return 0, err
+	}
+
+	return s.Conn.Read(b)
+}
+
+func (s *stallConn) Write(b []byte) (int, error) {
+	if err := s.Conn.SetWriteDeadline(s.clock.Now().Add(s.stall)); err != nil {
+		return 0, err
+	}
+
+	return s.Conn.Write(b)
+}
+
+// stallChunk is the most the kernel is left to move on its own between deadlines being
+// pushed out, see ReadFrom and WriteTo, a client has to take longer than stall over it
+// to be cut off, so it's kept to about what a socket buffers
+const stallChunk = 256 * 1024
+
+// ReadFrom keeps sendfile(2) within reach of io.Copy, which only uses it when
+// it can get hold of the connection underneath, files are sent a chunk at a time
+// so that the deadline is still pushed out as data moves
+func (s *stallConn) ReadFrom(r io.Reader) (int64, error) {
+	from, ok := s.Conn.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{s}, r)
+	}
+
+	// a reader that's already limited is cut down in place rather than wrapped
+	// again, sendfile(2) only sees the file beneath a single io.LimitedReader
+	limited, ok := r.(*io.LimitedReader)
+	if !ok {
+		limited = &io.LimitedReader{R: r, N: math.MaxInt64}
+	}
+
+	var written int64
+	for limited.N > 0 {
+		if err := s.Conn.SetWriteDeadline(s.clock.Now().Add(s.stall)); err != nil {
+			return written, err
+		}
+
+		remain, n := limited.N, min(limited.N, stallChunk)
+		limited.N = n
+		moved, err := from.ReadFrom(limited)
+		written += moved
+		limited.N = remain - moved
+		if err != nil || moved < n {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// WriteTo is ReadFrom the other way around, it lets files splice(2) straight
+// from the connection underneath
+func (s *stallConn) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	limited := &io.LimitedReader{R: s.Conn}
+	for {
+		if err := s.Conn.SetReadDeadline(s.clock.Now().Add(s.stall)); err != nil {
+			return written, err
+		}
+
+		limited.N = stallChunk
+		n, err := io.Copy(w, limited)
+		written += n
+		if err != nil || n < stallChunk {
+			return written, err
+		}
+	}
+}
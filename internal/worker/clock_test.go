@@ -0,0 +1,244 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"goftp/internal/logger"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters map[*fakeTimer]struct{}
+}
+
+type fakeTimer struct {
+	at      time.Time
+	expired chan struct{}
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waiters: make(map[*fakeTimer]struct{})}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) (<-chan struct{}, func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	timer := &fakeTimer{at: f.now.Add(d), expired: make(chan struct{})}
+	f.waiters[timer] = struct{}{}
+	return timer.expired, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.waiters, timer)
+	}
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = f.now.Add(d)
+	for timer := range f.waiters {
+		if !f.now.Before(timer.at) {
+			close(timer.expired)
+			delete(f.waiters, timer)
+		}
+	}
+}
+
+// waitForTimers blocks until n timers are pending, for timers started in the background
+func (f *fakeClock) waitForTimers(t *testing.T, n int) {
+	for i := 0; i < 100; i++ {
+		f.mutex.Lock()
+		pending := len(f.waiters)
+		f.mutex.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected %d pending timers", n)
+}
+
+func Test_Idle_Timeout(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	client, server := net.Pipe()
+	worker := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithTimeouts(Timeouts{Idle: time.Minute}))
+	worker.clock = clock
+	go worker.Start()
+
+	scanner := bufio.NewScanner(client)
+	scanner.Scan()
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	scanner.Scan()
+	if resp := scanner.Text(); resp != string(IdleTimeout) {
+		t.Errorf("Expected: %s, but got %s", string(IdleTimeout), resp)
+	}
+}
+
+func Test_Idle_Timeout_Not_During_Transfer(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	client, server := net.Pipe()
+	worker := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithTimeouts(Timeouts{Idle: time.Minute}))
+	worker.clock = clock
+	worker.dataWorker = scriptedDataWorker{NewDataWorker(context.Background(), logger.NewStdStreamClient()), make(chan Response)}
+	worker.loggedIn = true
+	worker.currentUser = "hkhan"
+	go worker.Start()
+
+	scanner := bufio.NewScanner(client)
+	writer := bufio.NewWriter(client)
+	scanner.Scan()
+
+	for _, command := range []string{"PASV\r\n", "RETR hello.txt\r\n"} {
+		writer.WriteString(command)
+		writer.Flush()
+		scanner.Scan()
+	}
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	// the session outlived the timeout as its transfer is still running
+	writer.WriteString("NOOP\r\n")
+	writer.Flush()
+	scanner.Scan()
+	if resp := scanner.Text(); resp != string(CommandOK) {
+		t.Errorf("Expected: %s, but got %s", string(CommandOK), resp)
+	}
+}
+
+func Test_Data_Connect_Timeout(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataTimeouts(Timeouts{DataConnect: time.Minute}),
+	)
+	d.clock = clock
+	defer d.Stop()
+
+	if resp := d.Connect(&Request{Cmd: "EPSV"}); resp[:3] != "229" {
+		t.Fatalf("Expected a 229, but got %s", resp)
+	}
+
+	// the client never connects
+	clock.Advance(time.Minute)
+
+	if socket, resp := d.socket(); socket != nil || resp != CannotOpenDataConnection {
+		t.Errorf("Expected Response: %s, but got %s", CannotOpenDataConnection, resp)
+	}
+}
+
+func Test_Transfer_Stall(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// deadlines are taken from the clock, one that has fallen behind makes them expire straight away
+	stalled := &stallConn{Conn: server, clock: newFakeClock(time.Now().Add(-time.Hour)), stall: time.Minute}
+	if _, err := stalled.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected: %v, but got %v", os.ErrDeadlineExceeded, err)
+	}
+
+	moving := &stallConn{Conn: server, clock: newFakeClock(time.Now()), stall: time.Minute}
+	go client.Write([]byte("x"))
+	if _, err := moving.Read(make([]byte, 1)); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func Test_Transfer_Stall_Zero_Copy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// a few chunks and then some, so that every chunk is picked up where the last left off
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*stallChunk+stallChunk/2)/16+1)
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/download", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &stallConn{Conn: server, clock: newFakeClock(time.Now()), stall: time.Minute}
+
+	// download, the file is sent by the connection's ReadFrom
+	fd, err := os.Open(dir + "/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	received := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(io.LimitReader(client, int64(len(data))))
+		received <- b
+	}()
+	if n, err := io.Copy(conn, fd); err != nil || n != int64(len(data)) {
+		t.Errorf("Expected: %v, but got %v (%v)", len(data), n, err)
+	}
+	if b := <-received; !bytes.Equal(b, data) {
+		t.Errorf("Expected: %v bytes, but got %v", len(data), len(b))
+	}
+
+	// upload, the file is written by the connection's WriteTo
+	up, err := os.Create(dir + "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	go func() {
+		client.Write(data)
+		client.(*net.TCPConn).CloseWrite()
+	}()
+	if n, err := io.Copy(up, conn); err != nil || n != int64(len(data)) {
+		t.Errorf("Expected: %v, but got %v (%v)", len(data), n, err)
+	}
+	if b, _ := os.ReadFile(dir + "/upload"); !bytes.Equal(b, data) {
+		t.Errorf("Expected: %v bytes, but got %v", len(data), len(b))
+	}
+
+	// the deadline is still kept to
+	stalled := &stallConn{Conn: server, clock: newFakeClock(time.Now().Add(-time.Hour)), stall: time.Minute}
+	if _, err := io.Copy(io.Discard, stalled); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected: %v, but got %v", os.ErrDeadlineExceeded, err)
+	}
+}
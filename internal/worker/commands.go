@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"fmt"
+	"goftp/internal/auth"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Command is how the server handles a verb, built in commands are described the same way
+// as those embedders add, see Commands
+type Command struct {
+	// verb clients send, such as "STOR", matched regardless of case
+	Name string
+
+	// runs the request on the session it was received on, after the Middleware
+	Handler func(Session, *Request) (Response, error)
+
+	// set for the commands clients can send before logging in, such as USER
+	Public bool
+
+	// needed by the user on the path the argument resolves to, none when left out
+	Permission auth.Permission
+
+	// where the command can fall while a data connection is set up and used
+	Sequence Sequence
+
+	// syntax HELP describes the command with, such as "STOR <SP> <pathname>"
+	Help string
+
+	// line FEAT advertises the command with, such as "REST STREAM", commands
+	// that are part of RFC 959 leave it out
+	Feature string
+}
+
+// Commands maps the verbs a server understands onto how they're handled, it's safe for concurrent use
+type Commands struct {
+	mutex    sync.RWMutex
+	commands map[string]Command
+}
+
+func NewCommands(commands ...Command) *Commands {
+	r := &Commands{commands: make(map[string]Command, len(commands))}
+	for _, cmd := range commands {
+		r.Register(cmd)
+	}
+
+	return r
+}
+
+// Register adds cmd, in place of any command of the same name
+func (r *Commands) Register(cmd Command) {
+	cmd.Name = strings.ToUpper(cmd.Name)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands[cmd.Name] = cmd
+}
+
+// Lookup returns the command of name
+func (r *Commands) Lookup(name string) (Command, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	cmd, ok := r.commands[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+// All returns every command, ordered by name
+func (r *Commands) All() []Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	commands := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+
+	return commands
+}
+
+// DefaultCommands returns the built in commands, in a Commands of their own that further ones can be registered with
+func DefaultCommands() *Commands {
+	return NewCommands(
+		Command{Name: "USER", Handler: builtin((*ControlWorker).handleUserLogin), Public: true, Help: "USER <SP> <username>"},
+		Command{Name: "PASS", Handler: builtin((*ControlWorker).handleUserPassword), Public: true, Help: "PASS <SP> <password>"},
+		Command{Name: "QUIT", Handler: builtin((*ControlWorker).handleQuit), Public: true, Help: "QUIT"},
+		Command{Name: "REIN", Handler: builtin((*ControlWorker).handleReinitialize), Public: true, Sequence: Idle, Help: "REIN"},
+		Command{Name: "FEAT", Handler: builtin((*ControlWorker).handleFeatures), Public: true, Help: "FEAT"},
+		Command{Name: "HELP", Handler: builtin((*ControlWorker).handleHelp), Public: true, Help: "HELP [<SP> <command>]"},
+		Command{Name: "NOOP", Handler: builtin((*ControlWorker).handleNoop), Help: "NOOP"},
+		Command{Name: "PWD", Handler: builtin((*ControlWorker).handlePWD), Help: "PWD"},
+		Command{Name: "TYPE", Handler: builtin((*ControlWorker).handleType), Help: "TYPE <SP> A | I"},
+		Command{Name: "MODE", Handler: builtin((*ControlWorker).handleMode), Help: "MODE <SP> S"},
+		Command{Name: "PASV", Handler: builtin((*ControlWorker).handlePassive), Sequence: Idle, Help: "PASV"},
+		Command{Name: "LPSV", Handler: builtin((*ControlWorker).handlePassive), Sequence: Idle, Help: "LPSV"},
+		Command{Name: "EPSV", Handler: builtin((*ControlWorker).handleExtendedPassive), Sequence: Idle,
+			Help: "EPSV [<SP> <net-prt> | ALL]", Feature: "EPSV"},
+		Command{Name: "PORT", Handler: builtin((*ControlWorker).handlePort), Sequence: Idle, Help: "PORT <SP> <host-port>"},
+		Command{Name: "LPRT", Handler: builtin((*ControlWorker).handlePort), Sequence: Idle, Help: "LPRT <SP> <long-host-port>"},
+		Command{Name: "EPRT", Handler: builtin((*ControlWorker).handlePort), Sequence: Idle,
+			Help: "EPRT <SP> <d><net-prt><d><net-addr><d><tcp-port><d>", Feature: "EPRT"},
+		Command{Name: "RETR", Handler: builtin((*ControlWorker).handleRetrieve), Permission: auth.Download, Sequence: Transfer,
+			Help: "RETR <SP> <pathname>"},
+		Command{Name: "STOR", Handler: builtin((*ControlWorker).handleStore), Sequence: Transfer, Help: "STOR <SP> <pathname>"},
+		Command{Name: "APPE", Handler: builtin((*ControlWorker).handleAppend), Permission: auth.Append, Sequence: Transfer,
+			Help: "APPE <SP> <pathname>"},
+		Command{Name: "LIST", Handler: builtin((*ControlWorker).handleList), Sequence: Transfer, Help: "LIST [<SP> <pathname>]"},
+		Command{Name: "NLST", Handler: builtin((*ControlWorker).handleList), Sequence: Transfer, Help: "NLST [<SP> <pathname>]"},
+		Command{Name: "REST", Handler: builtin((*ControlWorker).handleRestart), Help: "REST <SP> <marker>", Feature: "REST STREAM"},
+		Command{Name: "DELE", Handler: builtin((*ControlWorker).handleDelete), Permission: auth.Delete, Sequence: Idle,
+			Help: "DELE <SP> <pathname>"},
+		Command{Name: "RNFR", Handler: builtin((*ControlWorker).handleRenameFrom), Permission: auth.Rename, Help: "RNFR <SP> <pathname>"},
+		Command{Name: "RNTO", Handler: builtin((*ControlWorker).handleRenameTo), Permission: auth.Rename, Help: "RNTO <SP> <pathname>"},
+		Command{Name: "MKD", Handler: builtin((*ControlWorker).handleMakeDirectory), Permission: auth.Mkdir, Help: "MKD <SP> <pathname>"},
+		Command{Name: "RMD", Handler: builtin((*ControlWorker).handleRemoveDirectory), Permission: auth.Rmd, Help: "RMD <SP> <pathname>"},
+		Command{Name: "PBSZ", Handler: builtin((*ControlWorker).handleProtectionBuffer), Help: "PBSZ <SP> 0", Feature: "PBSZ"},
+		Command{Name: "PROT", Handler: builtin((*ControlWorker).handleProtection), Help: "PROT <SP> C | P", Feature: "PROT"},
+		Command{Name: "SITE", Handler: builtin((*ControlWorker).handleSite), Help: "SITE <SP> <string>"},
+	)
+}
+
+// builtin runs h on the ControlWorker behind the Session, built in commands reach
+// into more of the session than Session offers embedders
+func builtin(h func(*ControlWorker, *Request) (Response, error)) func(Session, *Request) (Response, error) {
+	return func(s Session, req *Request) (Response, error) {
+		return h(s.(*ControlWorker), req)
+	}
+}
+
+// FEAT lists the extensions to RFC 959 that are supported
+// https://www.rfc-editor.org/rfc/rfc2389#section-3
+//
+//	211
+//	500, 502
+func (c *ControlWorker) handleFeatures(req *Request) (Response, error) {
+	var features []string
+	for _, cmd := range c.commands.All() {
+		if cmd.Feature != "" && !contains(features, " "+cmd.Feature) {
+			features = append(features, " "+cmd.Feature)
+		}
+	}
+	sort.Strings(features)
+
+	return Response(fmt.Sprintf(string(FeatureList), strings.Join(features, string(CRLF)))), nil
+}
+
+// HELP lists every command, or describes the syntax of the one given
+//
+//	211, 214
+//	500, 501, 502, 421
+func (c *ControlWorker) handleHelp(req *Request) (Response, error) {
+	if req.Arg != "" {
+		cmd, ok := c.commands.Lookup(req.Arg)
+		if !ok {
+			return CmdNotImplemented, nil
+		}
+		help := cmd.Help
+		if help == "" {
+			help = cmd.Name
+		}
+		return Response(fmt.Sprintf(string(HelpMessage), "Syntax: "+help)), nil
+	}
+
+	var names []string
+	for _, cmd := range c.commands.All() {
+		names = append(names, cmd.Name)
+	}
+
+	// a handful of names per line, the way most servers lay them out
+	var lines []string
+	for len(names) > 0 {
+		n := min(len(names), 8)
+		lines = append(lines, " "+strings.Join(names[:n], " "))
+		names = names[n:]
+	}
+
+	return Response(fmt.Sprintf(string(HelpCommands), strings.Join(lines, string(CRLF)))), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
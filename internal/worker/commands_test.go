@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"goftp/internal/logger"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_Features(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+
+	// sent before logging in, to find out what the server can do
+	handler, req, err := w.Parse("FEAT\r\n")
+	if err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	expected := Response("211-Features:\r\n EPRT\r\n EPSV\r\n PBSZ\r\n PROT\r\n REST STREAM\r\n211 End")
+	if resp, _ := handler(req); resp != expected {
+		t.Errorf("Expected Response: %s, but got %s", expected, resp)
+	}
+}
+
+func Test_Help(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+
+	for _, step := range []struct {
+		Command  string
+		Expected string
+	}{
+		{"HELP\r\n", "214-The following commands are recognized:\r\n APPE DELE EPRT EPSV FEAT HELP LIST LPRT\r\n"},
+		{"HELP stor\r\n", "214 Syntax: STOR <SP> <pathname>"},
+		{"HELP SMNT\r\n", string(CmdNotImplemented)},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		if resp, _ := handler(req); !strings.HasPrefix(string(resp), step.Expected) {
+			t.Errorf("Expected Response: %s, but got %s", step.Expected, resp)
+		}
+	}
+}
+
+func Test_Register_Command(t *testing.T) {
+	commands := DefaultCommands()
+	commands.Register(Command{
+		Name:     "xsum",
+		Sequence: Transfer,
+		Help:     "XSUM <SP> <pathname>",
+		Feature:  "XSUM",
+		Handler: func(s Session, req *Request) (Response, error) {
+			return Response("213 " + req.Path()), nil
+		},
+	})
+	commands.Register(Command{
+		Name:   "NOOP",
+		Public: true,
+		Handler: func(s Session, req *Request) (Response, error) {
+			return "200 Still here", nil
+		},
+	})
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server, WithCommands(commands))
+
+	for _, step := range []struct {
+		Command  string
+		LoggedIn bool
+		State    CMD
+		Expected Response
+	}{
+		// built in commands can be replaced, along with what they require
+		{"NOOP\r\n", false, None, "200 Still here"},
+		// the registered command is held to the same checks as the built in ones
+		{"XSUM hello.txt\r\n", false, None, NotLoggedIn},
+		{"XSUM hello.txt\r\n", true, None, BadSequence},
+		{"XSUM hello.txt\r\n", true, Pasv, "213 /temp/hello.txt"},
+		{"HELP XSUM\r\n", false, None, "214 Syntax: XSUM <SP> <pathname>"},
+	} {
+		w.loggedIn = step.LoggedIn
+		w.state.Set(step.State)
+		handler, req, err := w.Parse(step.Command)
+		if err != nil {
+			t.Errorf("Expected nil error, but got %v", err)
+		}
+		if resp, _ := handler(req); resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+
+	handler, req, _ := w.Parse("FEAT\r\n")
+	if resp, _ := handler(req); !strings.Contains(string(resp), " XSUM\r\n") {
+		t.Errorf("Expected XSUM among the features, but got %s", resp)
+	}
+
+	// the default commands are left as they were
+	if cmd, _ := DefaultCommands().Lookup("NOOP"); cmd.Public {
+		t.Errorf("Expected NOOP to need a login")
+	}
+	if _, ok := DefaultCommands().Lookup("XSUM"); ok {
+		t.Errorf("Expected XSUM to only be registered with commands")
+	}
+}
+
+func Test_Commands_Not_Shared(t *testing.T) {
+	_, first := net.Pipe()
+	_, second := net.Pipe()
+	a := NewControlWorker(context.Background(), logger.NewStdStreamClient(), first)
+	b := NewControlWorker(context.Background(), logger.NewStdStreamClient(), second)
+
+	// registering with the commands of one session leaves those of any other as they were
+	a.commands.Register(Command{Name: "XSUM", Public: true, Handler: builtin((*ControlWorker).handleNoop)})
+
+	handler, req, _ := b.Parse("XSUM hello.txt\r\n")
+	if resp, _ := handler(req); resp != SyntaxError1 {
+		t.Errorf("Expected Response: %s, but got %s", SyntaxError1, resp)
+	}
+}
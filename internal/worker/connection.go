@@ -3,11 +3,14 @@ package worker
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"net"
 )
 
 type Connection struct {
-	ctx context.Context
+	// done once the session has ended, so the reader no longer waits to hand anything over
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	conn net.Conn
 	read interface {
@@ -27,27 +30,31 @@ type payload struct {
 }
 
 func NewConnection(ctx context.Context, conn net.Conn) *Connection {
+	ctx, cancel := context.WithCancel(ctx)
 	c := &Connection{
-		ctx:   ctx,
-		conn:  conn,
-		read:  bufio.NewReader(conn),
-		write: bufio.NewWriter(conn),
-		pipe:  make(chan payload),
+		ctx:    ctx,
+		cancel: cancel,
+		conn:   conn,
+		read:   bufio.NewReader(conn),
+		write:  bufio.NewWriter(conn),
+		pipe:   make(chan payload),
 	}
 
 	go func() {
+		// hand the panic over to the ControlWorker as a read error, which ends the session
+		defer func() {
+			if r := recover(); r != nil {
+				c.send(payload{Err: fmt.Errorf("control connection reader panic: %v", r)})
+			}
+		}()
+
 		for {
 			select {
 			case <-c.ctx.Done():
 				return
 			default:
 				buffer, err := c.read.ReadBytes('\n')
-				c.pipe <- payload{
-					Data: buffer,
-					Err:  err,
-				}
-
-				if err != nil {
+				if !c.send(payload{Data: buffer, Err: err}) || err != nil {
 					return
 				}
 			}
@@ -57,7 +64,37 @@ func NewConnection(ctx context.Context, conn net.Conn) *Connection {
 	return c
 }
 
+// send hands p over to the ControlWorker, reporting false when the session ended first
+func (c *Connection) send(p payload) bool {
+	select {
+	case c.pipe <- p:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// RemoteIP is the address of the ftp client, without the port
+func (c *Connection) RemoteIP() string {
+	return hostOf(c.conn.RemoteAddr())
+}
+
+// LocalIP is the address of this server the ftp client connected to, without the port
+func (c *Connection) LocalIP() string {
+	return hostOf(c.conn.LocalAddr())
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
 func (c *Connection) Stop() {
+	c.cancel()
 	c.conn.Close()
 }
 
@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_Connection_Reader_Stops(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	before := runtime.NumGoroutine()
+	c := NewConnection(context.Background(), server)
+
+	// a command the session never gets round to reading
+	client.Write([]byte("NOOP\r\n"))
+	c.Stop()
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected the reader to stop, but got %d goroutines, up from %d", after, before)
+	}
+}
@@ -2,10 +2,20 @@ package worker
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
 	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
+	"io"
 	"net"
+	"net/netip"
 )
 
 // Each FTP request will have a corresponding handler
@@ -25,12 +35,65 @@ type ControlWorker struct {
 	ctx    context.Context
 	logger logger.Client
 
-	// keeps track of currently logged in users
-	// TODO: create dedicated struct that couples this information
-	users       map[string]string
+	// identifies the session in the events it raises
+	session string
+
+	// where events are raised, shared across every ControlWorker, nil when they aren't
+	events *events.Bus
+
+	// verifies credentials and resolves what the current user is permitted to do
+	auth        auth.Authenticator
 	currentUser string
 	loggedIn    bool
 
+	// throttles and bans clients that keep failing to log in,
+	// shared across every ControlWorker
+	guard        *guard.Guard
+	failedLogins int
+
+	// caps concurrent logins per user, shared across every ControlWorker
+	limits *limits.Tracker
+
+	// counts what the user stores against their quotas once logged in, shared
+	// across every ControlWorker, nil when there are none
+	quotas *quota.Tracker
+
+	// limits how fast the user's transfers go, shared across every ControlWorker,
+	// along with the limits of this session once logged in
+	throttle *throttle.Throttle
+	rate     *throttle.Session
+
+	// configures the DataWorker, see DataOptions
+	dataOptions []DataOptions
+
+	// runs after the built in middleware on every request, see Middleware
+	middleware []Middleware
+
+	// commands the client can send, shared across every ControlWorker of a server,
+	// the built in ones unless WithCommands says otherwise
+	commands *Commands
+
+	// storage served to the client, and the working directory sessions start off in,
+	// base is fs as it was given, before it was wrapped for the user that logged in
+	fs   vfs.Filesystem
+	base vfs.Filesystem
+	home string
+
+	// first reply sent on the control connection
+	greeting Response
+
+	// closed when the server starts draining, see Start
+	drain <-chan struct{}
+
+	clock    clock
+	timeouts Timeouts
+
+	// source path of a pending rename, set by RNFR and consumed by RNTO
+	renameFrom string
+
+	// offset set by REST, consumed by the next transfer
+	restart int64
+
 	// connection with FTP Client (Control Connection)
 	// TODO: wrap this in another object that keeps track of more information
 	// control worker and data worker on not responsible for ensuring connection close
@@ -44,6 +107,9 @@ type ControlWorker struct {
 	// also protects against malicious FTP Clients
 	state *State
 
+	// set by EPSV ALL, after which EPSV is the only way to set up a data connection
+	epsvAll bool
+
 	// There is a 1-to-1 relation with a DataWorker which handles all
 	// the data transfer interactions, the ControlWorker signals to the
 	// DataWorker when/what transfer should be done
@@ -53,9 +119,15 @@ type ControlWorker struct {
 		Start()
 		Stop()
 		Connect(*Request) Response
+		Protect(rune) Response
+		Run(func(io.ReadWriter) error)
 
 		// configures the type of transfer
 		SetTransferRequest(*Request)
+		SetRestart(int64)
+		SetFilesystem(vfs.Filesystem)
+		SetThrottle(*throttle.Session)
+		SetUser(string)
 		SetPWD(string)
 		GetPWD() string
 		SetStructure(rune)
@@ -65,28 +137,146 @@ type ControlWorker struct {
 	}
 }
 
-func NewControlWorker(ctx context.Context, l logger.Client, conn net.Conn) *ControlWorker {
-	return &ControlWorker{
-		ctx:    ctx,
-		logger: l,
-		users: map[string]string{
-			"hkhan": "password",
-		},
+func WithAuthenticator(a auth.Authenticator) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.auth = a
+	}
+}
+
+func WithGuard(g *guard.Guard) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.guard = g
+	}
+}
+
+func WithLimits(t *limits.Tracker) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.limits = t
+	}
+}
+
+// WithQuotas counts what users store against the quotas of t
+func WithQuotas(t *quota.Tracker) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.quotas = t
+	}
+}
+
+// WithThrottle limits how fast transfers go, see throttle.Config
+func WithThrottle(t *throttle.Throttle) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.throttle = t
+	}
+}
+
+// WithEvents raises what the session does on bus
+func WithEvents(bus *events.Bus) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.events = bus
+	}
+}
+
+func WithDataOptions(options ...DataOptions) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.dataOptions = append(c.dataOptions, options...)
+	}
+}
+
+// WithFilesystem serves fs to the client, sessions start off at /
+func WithFilesystem(fs vfs.Filesystem) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.fs = fs
+		c.home = "/"
+	}
+}
+
+// WithBanner greets clients with text rather than the default "Service Ready"
+func WithBanner(text string) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.greeting = Response(fmt.Sprintf(string(Banner), text))
+	}
+}
+
+// WithDrain has the session wind down once drain is closed
+func WithDrain(drain <-chan struct{}) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.drain = drain
+	}
+}
+
+// WithTimeouts bounds how long the session, and its data connections, wait on the client
+func WithTimeouts(t Timeouts) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.timeouts = t
+		c.dataOptions = append(c.dataOptions, WithDataTimeouts(t))
+	}
+}
+
+// WithCommands replaces the commands the client can send, see DefaultCommands
+func WithCommands(commands *Commands) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.commands = commands
+	}
+}
+
+type Options func(*ControlWorker)
+
+func NewControlWorker(ctx context.Context, l logger.Client, conn net.Conn, options ...Options) *ControlWorker {
+	c := &ControlWorker{
+		ctx:     ctx,
+		logger:  l,
+		session: rand.Text()[:12],
+		auth: auth.NewStore(&auth.User{
+			Name:        "hkhan",
+			Password:    "password",
+			Permissions: auth.All,
+		}),
+		guard:             guard.New(guard.Config{}),
+		limits:            limits.New(limits.Config{}),
+		fs:                vfs.NewOS("."),
+		home:              "/temp",
+		greeting:          ServiceReady,
+		clock:             realClock{},
 		state:             NewState(),
-		dataWorker:        NewDataWorker(ctx, l),
 		controlConnection: NewConnection(ctx, conn),
 	}
+
+	for _, option := range options {
+		option(c)
+	}
+	c.base = c.fs
+	if c.commands == nil {
+		c.commands = DefaultCommands()
+	}
+
+	// unparsable addresses (net.Pipe) are left as the zero value, which never matches
+	peer, _ := netip.ParseAddr(c.controlConnection.RemoteIP())
+	local, _ := netip.ParseAddr(c.controlConnection.LocalIP())
+	c.dataWorker = NewDataWorker(ctx, l, append([]DataOptions{
+		WithPeer(peer),
+		WithLocalAddr(local),
+		WithDataFilesystem(c.fs),
+		WithDataEvents(c.events, c.session),
+	}, c.dataOptions...)...)
+	c.dataWorker.SetPWD(c.home)
+
+	return c
 }
 
 // start this workers processing of control connection
 // all write backs to the control connection happen here
 func (c *ControlWorker) Start() {
+	defer contain(c.logger, "ControlWorker")
 	defer func() {
+		c.logout()
 		c.controlConnection.Stop()
 		c.dataWorker.Stop()
 	}()
 
-	c.controlConnection.Write(ServiceReady)
+	c.controlConnection.Write(c.greeting)
+	drain := c.drain
+	idle, stop := timeout(c.clock, c.timeouts.Idle)
+	defer func() { stop() }()
 	for {
 		var payload payload
 		select {
@@ -94,9 +284,31 @@ func (c *ControlWorker) Start() {
 			// received shutdown signal
 			c.controlConnection.Write(ServiceNotAvailable)
 			return
+		case <-drain:
+			// idle sessions are closed straight away, the others once their transfer completes
+			if !c.state.Transferring() {
+				c.controlConnection.Write(ServiceNotAvailable)
+				return
+			}
+			drain = nil
+			continue
+		case <-idle:
+			// the control connection is expected to be quiet while a transfer runs
+			if !c.state.Transferring() {
+				c.controlConnection.Write(IdleTimeout)
+				return
+			}
+			idle, stop = timeout(c.clock, c.timeouts.Idle)
+			continue
 		case dataConnectionResponse := <-c.dataWorker.Read():
 			c.controlConnection.Write(dataConnectionResponse)
 			c.state.Set(None)
+			if c.draining() {
+				c.controlConnection.Write(ServiceNotAvailable)
+				return
+			}
+			stop()
+			idle, stop = timeout(c.clock, c.timeouts.Idle)
 			continue
 		case payload = <-c.controlConnection.Read():
 			if payload.Err != nil {
@@ -108,20 +320,26 @@ func (c *ControlWorker) Start() {
 			}
 		}
 
+		stop()
+		idle, stop = timeout(c.clock, c.timeouts.Idle)
+
 		handler, req, err := c.Parse(string(payload.Data))
 		if err != nil {
 			c.logger.Info(fmt.Sprintf("Receiver: parsing error: %v", err))
 		}
 
-		// TODO: move this to the parse code
-		handler = c.state.Check(req, handler)
 		response, err := handler(req)
 		if err != nil {
 			c.logger.Info(fmt.Sprintf("Receiver: handler error: %v", err))
 		}
 
+		// RNTO has to immediately follow RNFR
+		if req.Cmd != "RNFR" {
+			c.renameFrom = ""
+		}
+
 		c.controlConnection.Write(response)
-		if response == UserQuit {
+		if response == UserQuit || response == ServiceNotAvailable {
 			// exit
 			return
 		}
@@ -129,3 +347,23 @@ func (c *ControlWorker) Start() {
 }
 
 func (c *ControlWorker) Stop() {}
+
+func (c *ControlWorker) draining() bool {
+	select {
+	case <-c.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// publish raises e on behalf of the current user, when events are being raised
+func (c ControlWorker) publish(e events.Event) {
+	if c.events == nil {
+		return
+	}
+
+	e.Session = c.session
+	e.User = c.currentUser
+	c.events.Publish(e)
+}
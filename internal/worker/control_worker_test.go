@@ -106,3 +106,176 @@ func Test_User_Login(t *testing.T) {
 		return
 	}
 }
+
+func Test_Epsv_All(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+	w.loggedIn = true
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{Command: "EPSV ALL\r\n", Expected: EpsvAllOK},
+		{Command: "PASV\r\n", Expected: BadSequence},
+		{Command: "PORT 127,0,0,1,7,208\r\n", Expected: BadSequence},
+		{Command: "EPRT |1|127.0.0.1|2000|\r\n", Expected: BadSequence},
+		{Command: "LPSV\r\n", Expected: BadSequence},
+		{Command: "LPRT 4,4,127,0,0,1,2,7,208\r\n", Expected: BadSequence},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		resp, _ := w.state.Check(req, handler)(req)
+		if resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+}
+
+func Test_Protection(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithDataOptions(WithTLS(selfSigned(t))))
+	w.loggedIn = true
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{Command: "PBSZ\r\n", Expected: SyntaxError2},
+		{Command: "PBSZ 0\r\n", Expected: ProtectionBuffer},
+		{Command: "PROT\r\n", Expected: SyntaxError2},
+		{Command: "PROT X\r\n", Expected: CmdNotImplementedForParam},
+		{Command: "PROT c\r\n", Expected: CommandOK},
+		{Command: "PROT P\r\n", Expected: CommandOK},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		resp, _ := w.state.Check(req, handler)(req)
+		if resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+}
+
+// panickingDataWorker blows up as soon as a data connection is requested
+type panickingDataWorker struct {
+	*DataWorker
+}
+
+func (p panickingDataWorker) Connect(*Request) Response {
+	panic("boom")
+}
+
+func Test_Panic_Contained_To_Session(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, server := net.Pipe()
+	worker := NewControlWorker(ctx, logger.NewStdStreamClient(), server)
+	worker.dataWorker = panickingDataWorker{NewDataWorker(ctx, logger.NewStdStreamClient())}
+	worker.loggedIn = true
+
+	done := make(chan struct{})
+	go func() {
+		worker.Start()
+		close(done)
+	}()
+
+	scanner := bufio.NewScanner(client)
+	writer := bufio.NewWriter(client)
+
+	scanner.Scan()
+	writer.WriteString("PORT 127,0,0,1,7,208\r\n")
+	writer.Flush()
+
+	// the session is torn down, closing the control connection
+	if scanner.Scan() {
+		t.Errorf("Expected control connection to be closed, but got %s", scanner.Text())
+	}
+	<-done
+}
+
+// scriptedDataWorker hands back whatever the test sends on resp once a transfer is started
+type scriptedDataWorker struct {
+	*DataWorker
+	resp chan Response
+}
+
+func (s scriptedDataWorker) Connect(*Request) Response {
+	return CommandOK
+}
+
+func (s scriptedDataWorker) Start() {}
+
+func (s scriptedDataWorker) Read() <-chan Response {
+	return s.resp
+}
+
+func Test_Drain_Idle_Session(t *testing.T) {
+	drain := make(chan struct{})
+	client, server := net.Pipe()
+	worker := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server, WithDrain(drain))
+	go worker.Start()
+
+	scanner := bufio.NewScanner(client)
+	scanner.Scan()
+
+	close(drain)
+	scanner.Scan()
+	if resp := scanner.Text(); resp != string(ServiceNotAvailable) {
+		t.Errorf("Expected: %s, but got %s", string(ServiceNotAvailable), resp)
+	}
+}
+
+func Test_Drain_Waits_For_Transfer(t *testing.T) {
+	drain := make(chan struct{})
+	client, server := net.Pipe()
+	worker := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server, WithDrain(drain))
+	data := scriptedDataWorker{NewDataWorker(context.Background(), logger.NewStdStreamClient()), make(chan Response)}
+	worker.dataWorker = data
+	worker.loggedIn = true
+	worker.currentUser = "hkhan"
+
+	done := make(chan struct{})
+	go func() {
+		worker.Start()
+		close(done)
+	}()
+
+	scanner := bufio.NewScanner(client)
+	writer := bufio.NewWriter(client)
+	scanner.Scan()
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{Command: "PASV\r\n", Expected: CommandOK},
+		{Command: "RETR hello.txt\r\n", Expected: StartTransfer},
+	} {
+		writer.WriteString(step.Command)
+		writer.Flush()
+		scanner.Scan()
+		if resp := scanner.Text(); resp != string(step.Expected) {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+
+	// the transfer is under way, so the session is kept
+	close(drain)
+	writer.WriteString("NOOP\r\n")
+	writer.Flush()
+	scanner.Scan()
+	if resp := scanner.Text(); resp != string(CommandOK) {
+		t.Errorf("Expected: %s, but got %s", string(CommandOK), resp)
+	}
+
+	// and closed once it completes
+	data.resp <- TransferComplete
+	for _, expected := range []Response{TransferComplete, ServiceNotAvailable} {
+		scanner.Scan()
+		if resp := scanner.Text(); resp != string(expected) {
+			t.Errorf("Expected: %s, but got %s", string(expected), resp)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DataPolicy restricts where data connections may be made to or accepted from
+type DataPolicy struct {
+	// PORT is refused whenever it names an address other than the one the
+	// control connection came from, unless that address falls within
+	// ActiveAllowList (server to server transfers, also known as FXP)
+	ActiveAllowList []netip.Prefix
+
+	// refuse PORT to ports below 1024
+	DenyPrivilegedPorts bool
+
+	// PASV data connections are only accepted from the address the control
+	// connection came from, this turns that check off for deployments behind
+	// NAT'd load balancers that don't preserve client addresses
+	AllowForeignPassive bool
+}
+
+// allowActive guards against the server being used to bounce traffic
+// (port scanning, smuggling commands to third parties, ..etc)
+// https://www.rfc-editor.org/rfc/rfc2577#section-3
+func (p DataPolicy) allowActive(peer netip.Addr, host netip.Addr, port uint16) error {
+	if p.DenyPrivilegedPorts && port < 1024 {
+		return fmt.Errorf("privileged port %d", port)
+	}
+
+	host = host.Unmap()
+	if peer.IsValid() && host == peer.Unmap() {
+		return nil
+	}
+
+	for _, prefix := range p.ActiveAllowList {
+		if prefix.Contains(host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("address %s differs from control connection %s", host, peer)
+}
+
+// allowPassive guards against another host racing the client to the
+// listening port and hijacking the data connection
+func (p DataPolicy) allowPassive(peer netip.Addr, remote net.Addr) error {
+	if p.AllowForeignPassive {
+		return nil
+	}
+
+	addr, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return err
+	}
+
+	if !peer.IsValid() || addr.Addr().Unmap() != peer.Unmap() {
+		return fmt.Errorf("address %s differs from control connection %s", addr.Addr(), peer)
+	}
+
+	return nil
+}
@@ -2,13 +2,19 @@ package worker
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"goftp/internal/events"
 	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
 	"io"
-	"math/rand"
+	"io/fs"
 	"net"
-	"os"
-	"strconv"
+	"net/netip"
+	"slices"
 	"strings"
 	"time"
 )
@@ -37,23 +43,145 @@ type DataWorker struct {
 	port uint16
 	pasv bool
 
+	// addresses of the ftp client and of this server on the control
+	// connection, and the rules data connections are checked against
+	peer   netip.Addr
+	local  netip.Addr
+	policy DataPolicy
+
+	// where passive ports are taken from, and the address advertised
+	// in place of local when the server sits behind NAT
+	ports      *PortPool
+	masquerade netip.Addr
+
+	// data connections are wrapped in TLS while protected, which
+	// is the default for sessions on an implicit TLS listener
+	tls       *tls.Config
+	protected bool
+
+	clock    clock
+	timeouts Timeouts
+
+	// where files are read from and written to, set by the ControlWorker
+	fs vfs.Filesystem
+
 	// data worker is configured to work with s specific
 	// transfer request ~ Store, Retrieve, List, ... etc
 	transferReq  *Request
 	transferType string
 
+	// offset RETR starts reading from, see REST
+	restart int64
+
+	// limits how fast transfers go, nil until the user has logged in
+	throttle *throttle.Session
+
+	// STOR writes to a hidden file that's renamed into place once the
+	// transfer completes, rather than truncating the file straight away
+	atomic bool
+
+	// where the outcome of transfers is raised, nil when it isn't, along
+	// with the session and user they're raised on behalf of
+	events  *events.Bus
+	session string
+	user    string
+
 	// unused at the moment, idea is the generate a r/w based off of configurations
 	// to be used in Pipe(..)
 	*TransferFactory
 }
 
-func NewDataWorker(ctx context.Context, logger logger.Client) *DataWorker {
-	return &DataWorker{
+func WithPeer(addr netip.Addr) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.peer = addr
+	}
+}
+
+func WithLocalAddr(addr netip.Addr) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.local = addr
+	}
+}
+
+func WithPolicy(p DataPolicy) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.policy = p
+	}
+}
+
+func WithPassivePorts(p *PortPool) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.ports = p
+	}
+}
+
+// WithMasquerade advertises addr in PASV replies instead of the
+// address the client connected to
+func WithMasquerade(addr netip.Addr) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.masquerade = addr
+	}
+}
+
+// WithTLS protects data connections using cfg, PROT C can still opt a session out
+func WithTLS(cfg *tls.Config) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.tls = cfg
+		d.protected = cfg != nil
+	}
+}
+
+// WithDataTimeouts sets how long data connections wait on the client, only
+// DataConnect and Stall apply to the DataWorker
+func WithDataTimeouts(t Timeouts) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.timeouts = t
+	}
+}
+
+// WithDataFilesystem transfers files to and from fs
+func WithDataFilesystem(fs vfs.Filesystem) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.fs = fs
+	}
+}
+
+// WithAtomicUploads decides whether STOR goes through a hidden file that's only renamed into
+// place once the transfer completes, which is the default, or writes to the file directly
+func WithAtomicUploads(on bool) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.atomic = on
+	}
+}
+
+// WithDataEvents raises the outcome of transfers on bus, on behalf of session
+func WithDataEvents(bus *events.Bus, session string) func(*DataWorker) {
+	return func(d *DataWorker) {
+		d.events = bus
+		d.session = session
+	}
+}
+
+type DataOptions func(*DataWorker)
+
+func NewDataWorker(ctx context.Context, logger logger.Client, options ...DataOptions) *DataWorker {
+	d := &DataWorker{
 		ctx:             ctx,
 		resp:            make(chan Response),
 		logger:          logger,
+		ports:           NewPortPool(0, 0),
+		clock:           realClock{},
+		timeouts:        DefaultTimeouts,
+		fs:              vfs.NewOS("."),
+		atomic:          true,
 		TransferFactory: NewDefaultTransferFactory(),
 	}
+
+	for _, option := range options {
+		option(d)
+	}
+
+	return d
 }
 
 func (d *DataWorker) SetTransferRequest(req *Request) {
@@ -61,6 +189,25 @@ func (d *DataWorker) SetTransferRequest(req *Request) {
 	d.transferReq = req
 }
 
+func (d *DataWorker) SetRestart(offset int64) {
+	d.restart = offset
+}
+
+// SetFilesystem changes the filesystem subsequent transfers use
+func (d *DataWorker) SetFilesystem(fs vfs.Filesystem) {
+	d.fs = fs
+}
+
+// SetThrottle limits how fast subsequent transfers go
+func (d *DataWorker) SetThrottle(s *throttle.Session) {
+	d.throttle = s
+}
+
+// SetUser changes who subsequent transfers are raised on behalf of
+func (d *DataWorker) SetUser(user string) {
+	d.user = user
+}
+
 func (d *DataWorker) Read() <-chan Response {
 	return d.resp
 }
@@ -71,24 +218,81 @@ func (d *DataWorker) Stop() {
 }
 
 func (d *DataWorker) Start() {
-	if d.transferType == "RETR" {
-		d.Pipe(d.resp, os.Open)
-	} else if d.transferType == "STOR" {
-		d.Pipe(d.resp, os.Create)
+	switch d.transferType {
+	case "RETR":
+		d.Pipe(d.resp, d.fs.Open)
+	case "STOR":
+		// filesystems that only create files once they're complete need no hidden file
+		if d.atomic && !vfs.CreatesAtomically(d.fs, d.transferReq.Arg) {
+			d.Pipe(d.resp, d.createUpload)
+		} else {
+			d.Pipe(d.resp, d.fs.Create)
+		}
+	case "APPE":
+		d.Pipe(d.resp, d.fs.Append)
+	case "LIST", "NLST":
+		d.list(d.resp)
 	}
 }
 
+// Protect sets the protection level of subsequent data connections
+// https://www.rfc-editor.org/rfc/rfc4217#section-9
+func (d *DataWorker) Protect(level rune) Response {
+	switch level {
+	case 'C':
+		d.protected = false
+	case 'P':
+		if d.tls == nil {
+			return ProtectionNotSupported
+		}
+		d.protected = true
+	default:
+		return CmdNotImplementedForParam
+	}
+
+	return CommandOK
+}
+
 func (d *DataWorker) Connect(req *Request) Response {
-	var response Response
-	if req.Cmd == "PASV" {
+	switch req.Cmd {
+	case "PASV":
+		d.pasv = true
+		return d.passive()
+	case "EPSV":
 		d.pasv = true
-		response = d.passive()
-	} else {
+		return d.extendedPassive(req.Arg)
+	case "LPSV":
+		d.pasv = true
+		return d.longPassive()
+	case "LPRT":
 		d.pasv = false
-		response = d.active(req)
-	}
+		addr, err := parseLongPort(req.Arg)
+		if errors.Is(err, errUnsupportedFamily) {
+			return AddressFamilyNotSupported
+		} else if err != nil {
+			return SyntaxError2
+		}
+
+		return d.active(req, addr)
+	case "EPRT":
+		d.pasv = false
+		addr, err := parseExtendedPort(req.Arg)
+		if errors.Is(err, errUnsupportedFamily) {
+			return Response(fmt.Sprintf(string(NetworkProtocolNotSupported), d.family()))
+		} else if err != nil {
+			return SyntaxError2
+		}
 
-	return response
+		return d.active(req, addr)
+	default:
+		d.pasv = false
+		addr, err := parsePort(req.Arg)
+		if err != nil {
+			return SyntaxError2
+		}
+
+		return d.active(req, addr)
+	}
 }
 
 // clean up conn
@@ -102,8 +306,9 @@ func (d *DataWorker) disconnect() {
 	}
 }
 
-func (d *DataWorker) Pipe(resp chan Response, file func(string) (*os.File, error)) {
+func (d *DataWorker) Pipe(resp chan Response, file func(string) (vfs.File, error)) {
 	go func() {
+		defer contain(d.logger, "DataWorker", func() { resp <- TransferAborted })
 		defer func() {
 			d.disconnect()
 			d.logger.Info("DataWorker: Closing Data Connection")
@@ -114,35 +319,194 @@ func (d *DataWorker) Pipe(resp chan Response, file func(string) (*os.File, error
 			return
 		}
 
+		started := d.clock.Now()
+		var copied int64
+		reply := func(r Response) {
+			d.publish(r, copied, d.clock.Now().Sub(started))
+			resp <- r
+		}
+
 		// TODO: eventually use TransferFactory.Create(..)
-		fd, err := file("." + d.GetPWD() + "/" + d.transferReq.Arg)
+		fd, err := file(d.transferReq.Arg)
+		if errors.Is(err, quota.ErrExceeded) {
+			reply(ExceededStorage)
+			return
+		}
 		if err != nil {
-			resp <- FileNotFound
+			reply(FileNotFound)
 			return
 		}
-		defer fd.Close()
+		// a transfer that doesn't complete throws away what was written, where the filesystem
+		// can, before the client is told
+		closed := false
+		fail := func(r Response) {
+			closed = true
+			vfs.Abort(fd)
+			reply(r)
+		}
+		defer func() {
+			if !closed {
+				vfs.Abort(fd)
+			}
+		}()
 
-		conn := <-d.connection
-		if conn.err != nil {
-			resp <- CannotOpenDataConnection
-			return
+		if d.transferReq.Cmd == "RETR" && d.restart > 0 {
+			if _, err := fd.Seek(d.restart, io.SeekStart); err != nil {
+				fail(FileActionNotTaken)
+				return
+			}
 		}
 
-		if conn.socket == nil {
-			resp <- CannotOpenDataConnection
+		socket, response := d.socket()
+		if socket == nil {
+			fail(response)
 			return
 		}
+		started = d.clock.Now()
 
 		var dst io.Writer
 		var src io.Reader
-		if d.transferReq.Cmd == "STOR" {
-			dst, src = fd, conn.socket
+		if d.transferReq.Cmd == "STOR" || d.transferReq.Cmd == "APPE" {
+			dst, src = fd, socket
 		} else {
-			dst, src = conn.socket, fd
+			dst, src = socket, fd
+		}
+		if d.GetType() == 'A' {
+			src = newASCIIReader(src, dst == fd)
+		}
+		if d.throttle != nil {
+			if dst == fd {
+				src = d.throttle.Reader(d.ctx, src, throttle.Upload)
+			} else {
+				dst = d.throttle.Writer(d.ctx, dst, throttle.Download)
+			}
+		}
+
+		// running out of quota cuts the upload off part way through
+		copied, err = forward(dst, src, dst == fd)
+		if errors.Is(err, quota.ErrExceeded) {
+			fail(ExceededStorage)
+			return
+		}
+		if err != nil {
+			fail(TransferAborted)
+			return
+		}
+
+		// some filesystems only store what was written once it's closed,
+		// the transfer isn't complete until they have
+		if upload, ok := fd.(*upload); ok {
+			err = upload.Commit()
+		} else {
+			err = fd.Close()
+		}
+		closed = true
+		if err != nil {
+			d.logger.Info(fmt.Sprintf("DataWorker: unable to close %s: %v", d.transferReq.Arg, err))
+			reply(LocalError)
+			return
+		}
+
+		reply(TransferComplete)
+	}()
+}
+
+// forward copies src to dst, leaving it to the data connection, which is src for uploads, where it
+// can hand the copy to the kernel, io.Copy would favour the WriteTo of a file, which has no way of
+// getting to the socket beneath a data connection's deadlines
+func forward(dst io.Writer, src io.Reader, upload bool) (int64, error) {
+	if to, ok := src.(io.WriterTo); ok && upload {
+		return to.WriteTo(dst)
+	}
+	if from, ok := dst.(io.ReaderFrom); ok && !upload {
+		return from.ReadFrom(src)
+	}
+
+	return io.Copy(dst, src)
+}
+
+// publish raises the outcome of the transfer, which is over once r is sent back
+func (d *DataWorker) publish(r Response, size int64, took time.Duration) {
+	if d.events == nil {
+		return
+	}
+
+	e := events.Event{
+		Session:  d.session,
+		User:     d.user,
+		Path:     d.transferReq.Arg,
+		Size:     size,
+		Duration: took,
+	}
+	switch cmd := d.transferReq.Cmd; {
+	case cmd == "RETR" && r == TransferComplete:
+		e.Type = events.DownloadComplete
+	case cmd == "STOR" || cmd == "APPE":
+		e.Type = events.UploadComplete
+		if r != TransferComplete {
+			e.Type, e.Error = events.UploadFailed, string(r)
+		}
+	default:
+		return
+	}
+
+	d.events.Publish(e)
+}
+
+// socket blocks until the data connection set up by PASV/PORT is usable,
+// the returned Response is what should be sent back when it isn't
+func (d *DataWorker) socket() (net.Conn, Response) {
+	conn := <-d.connection
+	if conn.err != nil || conn.socket == nil {
+		return nil, CannotOpenDataConnection
+	}
+
+	if d.timeouts.Stall > 0 {
+		conn.socket = &stallConn{Conn: conn.socket, clock: d.clock, stall: d.timeouts.Stall}
+	}
+
+	if d.protected {
+		// the server end of the data connection always acts as the TLS server
+		secured := tls.Server(conn.socket, d.tls)
+		if err := secured.HandshakeContext(d.ctx); err != nil {
+			d.logger.Info(fmt.Sprintf("DataWorker: TLS handshake failed: %v", err))
+			return nil, CannotOpenDataConnection
+		}
+		d.conn = secured
+		return secured, TransferComplete
+	}
+
+	return conn.socket, TransferComplete
+}
+
+// list writes the contents of a directory (or the single file named) to the
+// data connection, LIST uses a format similar to ls -l while NLST only sends names
+func (d *DataWorker) list(resp chan Response) {
+	go func() {
+		defer contain(d.logger, "DataWorker", func() { resp <- TransferAborted })
+		defer func() {
+			d.disconnect()
+			d.logger.Info("DataWorker: Closing Data Connection")
+		}()
+
+		if d.transferReq == nil {
+			resp <- SyntaxError2
+			return
 		}
 
-		_, err = io.Copy(dst, src)
+		listing, err := d.listing(d.transferReq.Arg, d.transferReq.Cmd == "NLST")
 		if err != nil {
+			resp <- FileNotFound
+			return
+		}
+
+		socket, response := d.socket()
+		if socket == nil {
+			resp <- response
+			return
+		}
+
+		if _, err = io.WriteString(socket, listing); err != nil {
 			resp <- TransferAborted
 			return
 		}
@@ -151,105 +515,245 @@ func (d *DataWorker) Pipe(resp chan Response, file func(string) (*os.File, error
 	}()
 }
 
-func (d *DataWorker) list() {}
+// Run hands the data connection to fn, for the transfers of commands that aren't built in,
+// it's throttled the way the built in transfers are
+func (d *DataWorker) Run(fn func(io.ReadWriter) error) {
+	go func() {
+		defer contain(d.logger, "DataWorker", func() { d.resp <- TransferAborted })
+		defer func() {
+			d.disconnect()
+			d.logger.Info("DataWorker: Closing Data Connection")
+		}()
+
+		socket, response := d.socket()
+		if socket == nil {
+			d.resp <- response
+			return
+		}
+
+		var conn io.ReadWriter = socket
+		if d.throttle != nil {
+			conn = struct {
+				io.Reader
+				io.Writer
+			}{d.throttle.Reader(d.ctx, socket, throttle.Upload), d.throttle.Writer(d.ctx, socket, throttle.Download)}
+		}
 
-func (d *DataWorker) delete() {}
+		err := fn(conn)
+		if errors.Is(err, quota.ErrExceeded) {
+			d.resp <- ExceededStorage
+			return
+		}
+		if err != nil {
+			d.logger.Info(fmt.Sprintf("DataWorker: transfer failed: %v", err))
+			d.resp <- TransferAborted
+			return
+		}
 
-func (d *DataWorker) passive() Response {
-	var err error
-	var counter uint
-retry:
-	port := uint16(rand.Uint32())
-	d.server, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+		d.resp <- TransferComplete
+	}()
+}
+
+func (d *DataWorker) listing(name string, namesOnly bool) (string, error) {
+	info, err := d.fs.Stat(name)
 	if err != nil {
-		counter++
-		if counter > 5 {
-			return CannotOpenDataConnection
+		return "", err
+	}
+
+	infos := []fs.FileInfo{info}
+	if info.IsDir() {
+		if infos, err = d.fs.ReadDir(name); err != nil {
+			return "", err
 		}
 
-		goto retry
+		// uploads under way stay out of sight until they're complete
+		infos = slices.DeleteFunc(infos, func(info fs.FileInfo) bool {
+			return isUpload(info.Name())
+		})
 	}
 
-	err = d.server.(*net.TCPListener).SetDeadline(time.Now().Add(3 * time.Minute))
+	var builder strings.Builder
+	for _, info := range infos {
+		if namesOnly {
+			builder.WriteString(info.Name() + string(CRLF))
+			continue
+		}
+		builder.WriteString(formatListLine(info))
+	}
+
+	return builder.String(), nil
+}
+
+// formatListLine renders a single entry the way ls -l would, which
+// is what most ftp clients expect to parse out of LIST
+func formatListLine(info fs.FileInfo) string {
+	modified := info.ModTime().Format("Jan _2 15:04")
+	if time.Since(info.ModTime()) > 180*24*time.Hour {
+		modified = info.ModTime().Format("Jan _2  2006")
+	}
+
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s%s",
+		info.Mode().String(), info.Size(), modified, info.Name(), string(CRLF))
+}
+
+func (d *DataWorker) passive() Response {
+	host := d.local
+	if d.masquerade.IsValid() {
+		host = d.masquerade
+	}
+
+	// PASV can only describe IPv4 addresses, IPv6 clients have to use EPSV
+	if host = host.Unmap(); !host.Is4() {
+		d.logger.Info(fmt.Sprintf("DataWorker: unable to advertise %v in a PASV reply", host))
+		return CannotOpenDataConnection
+	}
+
+	port, err := d.listen()
 	if err != nil {
+		d.logger.Info(fmt.Sprintf("DataWorker: unable to listen for passive connection: %v", err))
+		return CannotOpenDataConnection
+	}
+
+	return GeneratePassiveResponse(host, port)
+}
+
+// longPassive is PASV for any address family
+// https://www.rfc-editor.org/rfc/rfc1639#section-2
+func (d *DataWorker) longPassive() Response {
+	host := d.local
+	if d.masquerade.IsValid() {
+		host = d.masquerade
+	}
+
+	if !host.IsValid() {
 		return CannotOpenDataConnection
 	}
 
+	port, err := d.listen()
+	if err != nil {
+		d.logger.Info(fmt.Sprintf("DataWorker: unable to listen for passive connection: %v", err))
+		return CannotOpenDataConnection
+	}
+
+	return GenerateLongPassiveResponse(host, port)
+}
+
+// extendedPassive only hands back a port, the client reuses the address of the
+// control connection, which is what makes it work for both IPv4 and IPv6
+// https://www.rfc-editor.org/rfc/rfc2428#section-3
+func (d *DataWorker) extendedPassive(family string) Response {
+	if family != "" && family != d.family() {
+		return Response(fmt.Sprintf(string(NetworkProtocolNotSupported), d.family()))
+	}
+
+	port, err := d.listen()
+	if err != nil {
+		d.logger.Info(fmt.Sprintf("DataWorker: unable to listen for passive connection: %v", err))
+		return CannotOpenDataConnection
+	}
+
+	return GenerateExtendedPassiveResponse(port)
+}
+
+// family is the RFC 2428 network protocol number of the control connection
+func (d *DataWorker) family() string {
+	if d.local.Unmap().Is4() {
+		return familyIPv4
+	}
+
+	return familyIPv6
+}
+
+// listen opens a passive port and waits, in the background, for the client to connect to it
+func (d *DataWorker) listen() (uint16, error) {
+	var err error
+	var port uint16
+	d.server, port, err = d.ports.Listen()
+	if err != nil {
+		return 0, err
+	}
+
 	d.connection = make(chan struct {
 		socket net.Conn
 		err    error
 	})
+
+	// started before returning so that the client's time starts with the reply
+	expired, stop := timeout(d.clock, d.timeouts.DataConnect)
 	ready := make(chan struct{})
 	go func() {
+		defer contain(d.logger, "DataWorker")
 		defer close(d.connection)
-		var err error
+		defer stop()
+
+		// the passive port is given up on when the client doesn't connect in time
+		accepted := make(chan struct{})
+		go func() {
+			select {
+			case <-expired:
+				d.server.Close()
+			case <-accepted:
+			}
+		}()
 
+		var err error
 		ready <- struct{}{}
-		d.conn, err = d.server.Accept()
-
-		timeout := make(chan struct{})
-		defer close(timeout)
-		go func() { time.Sleep(3 * time.Minute); <-timeout }()
-		select {
-		case d.connection <- struct {
-			socket net.Conn
-			err    error
-		}{d.conn, err}:
-		case timeout <- struct{}{}:
-			d.logger.Info("DataWorker: Timout waiting for data connection to be used, shutting down")
-			d.disconnect()
+		for {
+			var conn net.Conn
+			conn, err = d.server.Accept()
+			if err != nil {
+				break
+			}
+
+			// keep listening for the legitimate client when someone else got here first
+			if err := d.policy.allowPassive(d.peer, conn.RemoteAddr()); err != nil {
+				d.logger.Info(fmt.Sprintf("DataWorker: rejecting passive data connection: %v", err))
+				conn.Close()
+				continue
+			}
+
+			d.conn = conn
+			break
 		}
+		close(accepted)
+
+		d.handOff(d.conn, err, expired)
 	}()
 
 	<-ready
-	return GeneratePassiveResponse(port)
+	return port, nil
 }
 
-func (d *DataWorker) active(req *Request) Response {
+func (d *DataWorker) active(req *Request, addr netip.AddrPort) Response {
+	host, port := addr.Addr(), addr.Port()
+	if err := d.policy.allowActive(d.peer, host, port); err != nil {
+		d.logger.Info(fmt.Sprintf("DataWorker: refusing %s from %s: %v", req, d.peer, err))
+		return CmdNotImplementedForParam
+	}
+
 	ready := make(chan error)
 	d.connection = make(chan struct {
 		socket net.Conn
 		err    error
 	})
+
+	expired, stop := timeout(d.clock, d.timeouts.DataConnect)
 	defer close(ready)
 	go func() {
+		defer contain(d.logger, "DataWorker")
 		defer close(d.connection)
+		defer stop()
 		var err error
 
-		strs := strings.Split(req.Arg, ",")
-		MSB, err := strconv.Atoi(strs[4])
-		if err != nil {
-			ready <- err
-			return
-		}
-
-		LSB, err := strconv.Atoi(strs[5])
-		if err != nil {
-			ready <- err
-			return
-		}
-
-		port := uint16(MSB)<<8 + uint16(LSB)
-		d.conn, err = net.Dial("tcp", strings.Join(strs[:4], ".")+":"+fmt.Sprintf("%d", port))
+		dialer := net.Dialer{Timeout: d.timeouts.DataConnect}
+		d.conn, err = dialer.DialContext(d.ctx, "tcp", addr.String())
 		if err != nil {
 			ready <- err
 			return
 		}
 
 		ready <- nil
-
-		timeout := make(chan struct{})
-		defer close(timeout)
-		go func() { time.Sleep(3 * time.Minute); <-timeout }()
-		select {
-		case d.connection <- struct {
-			socket net.Conn
-			err    error
-		}{d.conn, nil}:
-		case timeout <- struct{}{}:
-			d.logger.Info("DataWorker: Timout waiting for data connection to be used, shutting down")
-			d.disconnect()
-		}
+		d.handOff(d.conn, nil, expired)
 	}()
 
 	if err := <-ready; err != nil {
@@ -258,3 +762,19 @@ func (d *DataWorker) active(req *Request) Response {
 
 	return CommandOK
 }
+
+// handOff waits for a transfer to take up the data connection, dropping it
+// if none has by the time expired is closed
+func (d *DataWorker) handOff(conn net.Conn, err error, expired <-chan struct{}) {
+	select {
+	case d.connection <- struct {
+		socket net.Conn
+		err    error
+	}{conn, err}:
+	case <-expired:
+		d.logger.Info("DataWorker: Timeout waiting for data connection to be used, shutting down")
+		d.disconnect()
+	case <-d.ctx.Done():
+		d.disconnect()
+	}
+}
@@ -1 +1,695 @@
 package worker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"goftp/internal/events"
+	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/vfs"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var activePolicyTestCases = []struct {
+	TestName string
+	Policy   DataPolicy
+	Peer     string
+	Host     string
+	Port     uint16
+	Allowed  bool
+}{
+	{
+		TestName: "Test_Active_Same_Host",
+		Peer:     "10.0.0.1",
+		Host:     "10.0.0.1",
+		Port:     2000,
+		Allowed:  true,
+	},
+	{
+		TestName: "Test_Active_Same_Host_IPv4_Mapped",
+		Peer:     "::ffff:10.0.0.1",
+		Host:     "10.0.0.1",
+		Port:     2000,
+		Allowed:  true,
+	},
+	{
+		TestName: "Test_Active_Bounce_Refused",
+		Peer:     "10.0.0.1",
+		Host:     "10.0.0.2",
+		Port:     2000,
+		Allowed:  false,
+	},
+	{
+		TestName: "Test_Active_Bounce_Allow_Listed",
+		Policy:   DataPolicy{ActiveAllowList: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+		Peer:     "10.0.0.1",
+		Host:     "10.0.0.2",
+		Port:     2000,
+		Allowed:  true,
+	},
+	{
+		TestName: "Test_Active_Privileged_Port_Allowed",
+		Peer:     "10.0.0.1",
+		Host:     "10.0.0.1",
+		Port:     22,
+		Allowed:  true,
+	},
+	{
+		TestName: "Test_Active_Privileged_Port_Refused",
+		Policy:   DataPolicy{DenyPrivilegedPorts: true},
+		Peer:     "10.0.0.1",
+		Host:     "10.0.0.1",
+		Port:     22,
+		Allowed:  false,
+	},
+}
+
+func TestAllowActive(t *testing.T) {
+	for _, testcase := range activePolicyTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			err := testcase.Policy.allowActive(
+				netip.MustParseAddr(testcase.Peer),
+				netip.MustParseAddr(testcase.Host),
+				testcase.Port,
+			)
+
+			if allowed := err == nil; allowed != testcase.Allowed {
+				t.Errorf("Expected: %v, but got %v (%v)", testcase.Allowed, allowed, err)
+			}
+		})
+	}
+}
+
+func Test_Active_Bounce_Response(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+	)
+	defer d.Stop()
+
+	if resp := d.Connect(&Request{Cmd: "PORT", Arg: "10,0,0,1,7,208"}); resp != CmdNotImplementedForParam {
+		t.Errorf("Expected Response: %s, but got %s", CmdNotImplementedForParam, resp)
+	}
+}
+
+func Test_Active_Connect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+	)
+	defer d.Stop()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	arg := fmt.Sprintf("127,0,0,1,%d,%d", port>>8, port&0xFF)
+	if resp := d.Connect(&Request{Cmd: "PORT", Arg: arg}); resp != CommandOK {
+		t.Errorf("Expected Response: %s, but got %s", CommandOK, resp)
+	}
+}
+
+func TestAllowPassive(t *testing.T) {
+	peer := netip.MustParseAddr("10.0.0.1")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2000}
+
+	if err := (DataPolicy{}).allowPassive(peer, remote); err == nil {
+		t.Errorf("Expected passive connection from a foreign address to be rejected")
+	}
+
+	if err := (DataPolicy{AllowForeignPassive: true}).allowPassive(peer, remote); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+
+	remote.IP = net.ParseIP("::ffff:10.0.0.1")
+	if err := (DataPolicy{}).allowPassive(peer, remote); err != nil {
+		t.Errorf("Expected nil error, but got %v", err)
+	}
+}
+
+func Test_Passive_Rejects_Foreign_Peer(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+	)
+	defer d.Stop()
+
+	resp := d.Connect(&Request{Cmd: "PASV"})
+	var h1, h2, h3, h4, p1, p2 int
+	if _, err := fmt.Sscanf(string(resp), "227 Entering Passive Mode (%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", p1<<8|p2)
+
+	// a different host racing the client for the data port
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	foreign, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+
+	if _, err := foreign.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected foreign data connection to be closed")
+	}
+
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	socket, resp := d.socket()
+	if socket == nil {
+		t.Fatalf("Expected data connection, but got %s", resp)
+	}
+
+	if socket.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("Expected: %s, but got %s", client.LocalAddr(), socket.RemoteAddr())
+	}
+}
+
+func Test_Passive_Masquerade(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithMasquerade(netip.MustParseAddr("203.0.113.7")),
+		WithPassivePorts(NewPortPool(50000, 50100)),
+	)
+	defer d.Stop()
+
+	var h1, h2, h3, h4, p1, p2 int
+	resp := d.Connect(&Request{Cmd: "PASV"})
+	if _, err := fmt.Sscanf(string(resp), "227 Entering Passive Mode (%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	if host := fmt.Sprintf("%d.%d.%d.%d", h1, h2, h3, h4); host != "203.0.113.7" {
+		t.Errorf("Expected: %s, but got %s", "203.0.113.7", host)
+	}
+
+	if port := p1<<8 | p2; port < 50000 || port > 50100 {
+		t.Errorf("Expected port within [50000, 50100], but got %d", port)
+	}
+}
+
+func Test_Extended_Passive_IPv6(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("::1")),
+		WithLocalAddr(netip.MustParseAddr("::1")),
+	)
+	defer d.Stop()
+
+	if resp := d.Connect(&Request{Cmd: "EPSV", Arg: "1"}); resp != "522 Network protocol not supported, use (2)" {
+		t.Errorf("Expected Response: %s, but got %s", "522 Network protocol not supported, use (2)", resp)
+	}
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp6", fmt.Sprintf("[::1]:%d", port))
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer client.Close()
+
+	if socket, resp := d.socket(); socket == nil {
+		t.Errorf("Expected data connection, but got %s", resp)
+	}
+}
+
+func Test_Extended_Port_IPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer listener.Close()
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("::1")),
+		WithLocalAddr(netip.MustParseAddr("::1")),
+	)
+	defer d.Stop()
+
+	arg := fmt.Sprintf("|2|::1|%d|", listener.Addr().(*net.TCPAddr).Port)
+	if resp := d.Connect(&Request{Cmd: "EPRT", Arg: arg}); resp != CommandOK {
+		t.Errorf("Expected Response: %s, but got %s", CommandOK, resp)
+	}
+
+	if resp := d.Connect(&Request{Cmd: "EPRT", Arg: "|2|::2|2000|"}); resp != CmdNotImplementedForParam {
+		t.Errorf("Expected Response: %s, but got %s", CmdNotImplementedForParam, resp)
+	}
+}
+
+func Test_Long_Passive_And_Port(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+	)
+	defer d.Stop()
+
+	var p1, p2 int
+	resp := d.Connect(&Request{Cmd: "LPSV"})
+	if _, err := fmt.Sscanf(string(resp), "228 Entering Long Passive Mode (4,4,127,0,0,1,2,%d,%d)", &p1, &p2); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", p1<<8|p2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if socket, resp := d.socket(); socket == nil {
+		t.Errorf("Expected data connection, but got %s", resp)
+	}
+
+	if resp := d.Connect(&Request{Cmd: "LPRT", Arg: "5,4,127,0,0,1,2,7,208"}); resp != AddressFamilyNotSupported {
+		t.Errorf("Expected Response: %s, but got %s", AddressFamilyNotSupported, resp)
+	}
+
+	if resp := d.Connect(&Request{Cmd: "LPRT", Arg: "4,4,10,0,0,1,2,7,208"}); resp != CmdNotImplementedForParam {
+		t.Errorf("Expected Response: %s, but got %s", CmdNotImplementedForParam, resp)
+	}
+}
+
+// selfSigned is a throwaway certificate for 127.0.0.1
+func selfSigned(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func Test_Protect(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient())
+
+	if resp := d.Protect('P'); resp != ProtectionNotSupported {
+		t.Errorf("Expected Response: %s, but got %s", ProtectionNotSupported, resp)
+	}
+
+	if resp := d.Protect('C'); resp != CommandOK {
+		t.Errorf("Expected Response: %s, but got %s", CommandOK, resp)
+	}
+
+	if resp := d.Protect('S'); resp != CmdNotImplementedForParam {
+		t.Errorf("Expected Response: %s, but got %s", CmdNotImplementedForParam, resp)
+	}
+}
+
+func Test_Passive_Protected(t *testing.T) {
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithTLS(selfSigned(t)),
+	)
+	defer d.Stop()
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	// the handshake only completes once the DataWorker takes up the connection
+	handshake := make(chan error, 1)
+	go func() {
+		client, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			defer client.Close()
+		}
+		handshake <- err
+	}()
+
+	socket, resp := d.socket()
+	if socket == nil {
+		t.Fatalf("Expected data connection, but got %s", resp)
+	}
+
+	if err := <-handshake; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := socket.(*tls.Conn); !ok {
+		t.Errorf("Expected a TLS data connection, but got %T", socket)
+	}
+}
+
+func Test_List_Line_Ends_With_Single_CRLF(t *testing.T) {
+	info, err := os.Stat(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := formatListLine(info)
+	if !strings.HasSuffix(line, " .\r\n") {
+		t.Errorf("Expected a single CRLF, but got %q", line)
+	}
+}
+
+func Test_Retrieve_Restart(t *testing.T) {
+	fs := vfs.NewMemory()
+	file, _ := fs.Create("/file.txt")
+	file.Write([]byte("0123456789"))
+	file.Close()
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(fs),
+	)
+	defer d.Stop()
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	d.SetTransferRequest(&Request{Cmd: "RETR", Arg: "/file.txt"})
+	d.SetRestart(6)
+	d.Start()
+
+	// the data connection is only closed once the reply has been taken
+	if resp := <-d.Read(); resp != TransferComplete {
+		t.Errorf("Expected Response: %s, but got %s", TransferComplete, resp)
+	}
+
+	received, _ := io.ReadAll(client)
+	if string(received) != "6789" {
+		t.Errorf("Expected: %s, but got %s", "6789", received)
+	}
+}
+
+func Test_Store_Atomic(t *testing.T) {
+	fs := vfs.NewMemory()
+	file, _ := fs.Create("/file.txt")
+	file.Write([]byte("previous"))
+	file.Close()
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(fs),
+	)
+	defer d.Stop()
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetTransferRequest(&Request{Cmd: "STOR", Arg: "/file.txt"})
+	d.Start()
+
+	client.Write([]byte("uploaded"))
+	client.Close()
+	if resp := <-d.Read(); resp != TransferComplete {
+		t.Errorf("Expected Response: %s, but got %s", TransferComplete, resp)
+	}
+
+	if contents := readFile(t, fs, "/file.txt"); contents != "uploaded" {
+		t.Errorf("Expected: %s, but got %s", "uploaded", contents)
+	}
+	if infos, _ := fs.ReadDir("/"); len(infos) != 1 {
+		t.Errorf("Expected only file.txt to be left, but got %v", infos)
+	}
+}
+
+func Test_Upload_Aborted(t *testing.T) {
+	fs := vfs.NewMemory()
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(), WithDataFilesystem(fs))
+
+	fd, err := d.createUpload("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write([]byte("truncat"))
+
+	// the file only shows up, hidden, next to where it's going
+	infos, _ := fs.ReadDir("/")
+	if len(infos) != 1 || !strings.HasPrefix(infos[0].Name(), ".file.txt.") {
+		t.Errorf("Expected a hidden file next to file.txt, but got %v", infos)
+	}
+	if listing, err := d.listing("/", false); listing != "" {
+		t.Errorf("Expected the upload to be left out of listings, but got %q (%v)", listing, err)
+	}
+
+	fd.Close()
+	if infos, _ := fs.ReadDir("/"); len(infos) != 0 {
+		t.Errorf("Expected nothing to be left behind, but got %v", infos)
+	}
+}
+
+// atomically creates files that only show up once they're complete, whose writes all fail
+type atomically struct {
+	*vfs.Memory
+
+	created []string
+	aborted bool
+}
+
+func (a *atomically) CreatesAtomically(name string) bool {
+	return true
+}
+
+func (a *atomically) Create(name string) (vfs.File, error) {
+	fd, err := a.Memory.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a.created = append(a.created, name)
+	return &failing{File: fd, fs: a}, nil
+}
+
+type failing struct {
+	vfs.File
+	fs *atomically
+}
+
+func (f *failing) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func (f *failing) Abort() error {
+	f.fs.aborted = true
+	return f.File.Close()
+}
+
+func Test_Store_Atomically_Aborted(t *testing.T) {
+	fs := &atomically{Memory: vfs.NewMemory()}
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(fs),
+	)
+	defer d.Stop()
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetTransferRequest(&Request{Cmd: "STOR", Arg: "/file.txt"})
+	d.Start()
+
+	client.Write([]byte("uploaded"))
+	client.Close()
+	if resp := <-d.Read(); resp != TransferAborted {
+		t.Errorf("Expected Response: %s, but got %s", TransferAborted, resp)
+	}
+
+	// written straight to where it's going, and thrown away rather than closed
+	if len(fs.created) != 1 || fs.created[0] != "/file.txt" {
+		t.Errorf("Expected: %v, but got %v", []string{"/file.txt"}, fs.created)
+	}
+	if !fs.aborted {
+		t.Errorf("Expected the upload to be aborted")
+	}
+}
+
+func readFile(t *testing.T, fs vfs.Filesystem, name string) string {
+	file, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	contents, _ := io.ReadAll(file)
+	return string(contents)
+}
+
+func Test_Store_Exceeds_Quota(t *testing.T) {
+	memory := vfs.NewMemory()
+	tracker := quota.New(quota.Config{Paths: map[string]quota.Limit{"/": {Bytes: 4}}}, memory)
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(tracker.Filesystem(memory, "partner")),
+	)
+	defer d.Stop()
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	d.SetTransferRequest(&Request{Cmd: "STOR", Arg: "/file.txt"})
+	d.Start()
+
+	client.Write([]byte("0123456789"))
+	if resp := <-d.Read(); resp != ExceededStorage {
+		t.Errorf("Expected Response: %s, but got %s", ExceededStorage, resp)
+	}
+
+	if infos, _ := memory.ReadDir("/"); len(infos) != 0 {
+		t.Errorf("Expected nothing to be left behind, but got %v", infos)
+	}
+}
+
+// recorder keeps every event it's handed
+type recorder struct {
+	mutex  sync.Mutex
+	events []events.Event
+}
+
+func (r *recorder) Notify(e events.Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func Test_Store_Events(t *testing.T) {
+	fs := vfs.NewMemory()
+	recorded := &recorder{}
+	bus := events.New(logger.NewStdStreamClient(), events.Subscription{Subscriber: recorded})
+
+	d := NewDataWorker(context.Background(), logger.NewStdStreamClient(),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(fs),
+		WithDataEvents(bus, "session"),
+	)
+	defer d.Stop()
+	d.SetUser("hkhan")
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		t.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetTransferRequest(&Request{Cmd: "STOR", Arg: "/file.txt"})
+	d.Start()
+	client.Write([]byte("uploaded"))
+	client.Close()
+	<-d.Read()
+
+	// refused before a data connection is needed
+	d.SetTransferRequest(&Request{Cmd: "STOR", Arg: "/missing/file.txt"})
+	d.Start()
+	<-d.Read()
+	bus.Close()
+
+	if len(recorded.events) != 2 {
+		t.Fatalf("Expected: 2 events, but got %v", recorded.events)
+	}
+
+	complete := recorded.events[0]
+	expected := events.Event{Type: events.UploadComplete, Session: "session", User: "hkhan", Path: "/file.txt", Size: 8}
+	if complete.Type != expected.Type || complete.Session != expected.Session || complete.User != expected.User ||
+		complete.Path != expected.Path || complete.Size != expected.Size {
+		t.Errorf("Expected: %v, but got %v", expected, complete)
+	}
+
+	if failed := recorded.events[1]; failed.Type != events.UploadFailed || failed.Error != string(FileNotFound) {
+		t.Errorf("Expected: %s with %s, but got %v", events.UploadFailed, FileNotFound, failed)
+	}
+}
+
+func Test_ASCII_Line_Endings(t *testing.T) {
+	for _, test := range []struct {
+		Upload   bool
+		Data     string
+		Expected string
+	}{
+		{false, "one\ntwo\n", "one\r\ntwo\r\n"},
+		{false, "one\r\ntwo", "one\r\ntwo"},
+		{true, "one\r\ntwo\r\n", "one\ntwo\n"},
+		{true, "one\rtwo\r", "one\rtwo\r"},
+		{true, "one\r\r\n", "one\r\n"},
+	} {
+		// a byte at a time, so that line endings are split across reads
+		b, err := io.ReadAll(newASCIIReader(iotest.OneByteReader(strings.NewReader(test.Data)), test.Upload))
+		if err != nil || string(b) != test.Expected {
+			t.Errorf("Expected: %q, but got %q (%v)", test.Expected, b, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/quota"
+	"path"
+	"sort"
+	"strings"
+)
+
+// resolve maps the argument of a request onto an absolute virtual path,
+// relative arguments are taken from the current working directory and
+// cleaning the result keeps clients from climbing above the root
+func (c ControlWorker) resolve(arg string) string {
+	if strings.HasPrefix(arg, "/") {
+		return path.Clean(arg)
+	}
+
+	return path.Join("/", c.dataWorker.GetPWD(), arg)
+}
+
+// DELE
+//
+//	250
+//	450, 550
+//	500, 501, 502, 421, 530
+func (c *ControlWorker) handleDelete(req *Request) (Response, error) {
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	pth := c.resolve(req.Arg)
+	info, err := c.fs.Stat(pth)
+	if err != nil || info.IsDir() {
+		return FileNotFound, nil
+	}
+
+	if err := c.fs.Remove(pth); err != nil {
+		return FileActionNotTaken, err
+	}
+
+	c.publish(events.Event{Type: events.Delete, Path: pth, Size: info.Size()})
+	return TransferComplete, nil
+}
+
+// MKD
+//
+//	257
+//	500, 501, 502, 421, 530, 550
+func (c *ControlWorker) handleMakeDirectory(req *Request) (Response, error) {
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	pth := c.resolve(req.Arg)
+	if err := c.fs.Mkdir(pth); err != nil {
+		return FileNotFound, err
+	}
+
+	c.publish(events.Event{Type: events.Mkdir, Path: pth})
+	return Response(fmt.Sprintf(string(DirectoryResponse), pth)), nil
+}
+
+// RMD
+//
+//	250
+//	500, 501, 502, 421, 530, 550
+func (c *ControlWorker) handleRemoveDirectory(req *Request) (Response, error) {
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	pth := c.resolve(req.Arg)
+	if info, err := c.fs.Stat(pth); err != nil || !info.IsDir() {
+		return FileNotFound, nil
+	}
+
+	if err := c.fs.Remove(pth); err != nil {
+		return FileNotFound, err
+	}
+
+	return TransferComplete, nil
+}
+
+// RNFR
+//
+//	450, 550
+//	500, 501, 502, 421, 530
+//	350
+func (c *ControlWorker) handleRenameFrom(req *Request) (Response, error) {
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	pth := c.resolve(req.Arg)
+	if _, err := c.fs.Stat(pth); err != nil {
+		return FileNotFound, nil
+	}
+
+	c.renameFrom = pth
+	return PendingFurtherInfo, nil
+}
+
+// RNTO
+//
+//	250
+//	532, 552, 553
+//	500, 501, 502, 503, 421, 530
+func (c *ControlWorker) handleRenameTo(req *Request) (Response, error) {
+	if c.renameFrom == "" {
+		return BadSequence, nil
+	}
+
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	pth := c.resolve(req.Arg)
+
+	// replacing an existing file takes the same grant STOR needs to
+	if _, err := c.fs.Stat(pth); err == nil && !c.permitted(auth.Overwrite, pth) {
+		return FileNameNotAllowed, nil
+	}
+
+	if err := c.fs.Rename(c.renameFrom, pth); err != nil {
+		if errors.Is(err, quota.ErrExceeded) {
+			return ExceededStorage, err
+		}
+		return FileNameNotAllowed, err
+	}
+
+	c.publish(events.Event{Type: events.Rename, Path: c.renameFrom, To: pth})
+	return TransferComplete, nil
+}
+
+// SITE subcommands, each is only reachable by users holding the Site permission
+func (c *ControlWorker) siteCommands() map[string]Handler {
+	return map[string]Handler{
+		"HELP":  c.handleSiteHelp,
+		"QUOTA": c.handleSiteQuota,
+	}
+}
+
+// SITE
+//
+//	200
+//	202
+//	500, 501, 530
+func (c *ControlWorker) handleSite(req *Request) (Response, error) {
+	sub, arg, _ := strings.Cut(req.Arg, " ")
+	sub = strings.ToUpper(sub)
+
+	// QUOTA only reports on the user's own room, which drop box users need to see
+	if sub != "QUOTA" && !c.permitted(auth.Site, c.resolve("")) {
+		return FileNotFound, nil
+	}
+
+	handler, ok := c.siteCommands()[sub]
+	if !ok {
+		return CmdNotImplementedForParam, nil
+	}
+
+	return handler(&Request{Cmd: sub, Arg: arg})
+}
+
+func (c *ControlWorker) handleSiteHelp(req *Request) (Response, error) {
+	var names []string
+	for name := range c.siteCommands() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return Response(fmt.Sprintf(string(HelpMessage), "SITE "+strings.Join(names, " "))), nil
+}
+
+// SITE QUOTA reports the quota of the user and of the directories they can upload to
+func (c *ControlWorker) handleSiteQuota(req *Request) (Response, error) {
+	user, ok := c.auth.Lookup(c.currentUser)
+	if c.quotas == nil || !ok {
+		return Response(fmt.Sprintf(string(NoQuota), c.currentUser)), nil
+	}
+
+	var lines []string
+	for _, status := range c.quotas.Status(c.currentUser) {
+		name := "user " + status.Name
+		if !status.User {
+			if !user.Can(auth.Upload, status.Name) && !user.Can(auth.Append, status.Name) {
+				continue
+			}
+			name = status.Name
+		}
+		lines = append(lines, fmt.Sprintf(" %s: %s, %s", name,
+			formatQuota(status.Usage.Bytes, status.Limit.Bytes, "bytes"),
+			formatQuota(status.Usage.Files, status.Limit.Files, "files")))
+	}
+
+	if len(lines) == 0 {
+		return Response(fmt.Sprintf(string(NoQuota), c.currentUser)), nil
+	}
+
+	return Response(fmt.Sprintf(string(QuotaReport), c.currentUser, strings.Join(lines, string(CRLF)))), nil
+}
+
+// formatQuota spells out what's used of limit, and what's left of it
+func formatQuota(used, limit int64, unit string) string {
+	if limit == 0 {
+		return fmt.Sprintf("%d %s used", used, unit)
+	}
+
+	return fmt.Sprintf("%d of %d %s used, %d remaining", used, limit, unit, max(limit-used, 0))
+}
@@ -0,0 +1,322 @@
+package worker
+
+import (
+	"context"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/vfs"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testUsers = auth.NewStore(
+	&auth.User{Name: "admin", Password: "password", Permissions: auth.All},
+	&auth.User{Name: "auditor", Password: "password", Permissions: auth.ReadOnly},
+	&auth.User{Name: "partner", Password: "password", Permissions: auth.DropBox},
+	&auth.User{Name: "editor", Password: "password", Permissions: auth.ReadOnly | auth.Rename},
+	&auth.User{
+		Name:        "mixed",
+		Password:    "password",
+		Permissions: auth.ReadOnly,
+		Paths:       map[string]auth.Permission{"/temp/incoming": auth.All},
+	},
+)
+
+// table-driven tests for handlers that act on the filesystem, each
+// one runs in parallel against a fresh tree laid out by setupTree
+var fileTestCases = []struct {
+	TestName         string
+	User             string
+	Commands         []string
+	HandlerRespValue Response
+}{
+	{
+		TestName:         "Test_Delete_Success",
+		User:             "admin",
+		Commands:         []string{"DELE hello.txt\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Delete_Not_Found",
+		User:             "admin",
+		Commands:         []string{"DELE missing.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Delete_Denied_ReadOnly",
+		User:             "auditor",
+		Commands:         []string{"DELE hello.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Delete_Allowed_By_Path_Override",
+		User:             "mixed",
+		Commands:         []string{"DELE incoming/upload.txt\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Make_Directory_Success",
+		User:             "admin",
+		Commands:         []string{"MKD reports\r\n"},
+		HandlerRespValue: `257 "/temp/reports"`,
+	},
+	{
+		TestName:         "Test_Make_Directory_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"MKD reports\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Remove_Directory_Success",
+		User:             "admin",
+		Commands:         []string{"RMD empty\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Remove_Directory_Denied_ReadOnly",
+		User:             "auditor",
+		Commands:         []string{"RMD empty\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Rename_Success",
+		User:             "admin",
+		Commands:         []string{"RNFR hello.txt\r\n", "RNTO world.txt\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Rename_Without_Overwrite",
+		User:             "editor",
+		Commands:         []string{"RNFR hello.txt\r\n", "RNTO world.txt\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Rename_Over_Existing",
+		User:             "admin",
+		Commands:         []string{"RNFR hello.txt\r\n", "RNTO incoming/upload.txt\r\n"},
+		HandlerRespValue: TransferComplete,
+	},
+	{
+		TestName:         "Test_Rename_Over_Existing_Denied",
+		User:             "editor",
+		Commands:         []string{"RNFR hello.txt\r\n", "RNTO incoming/upload.txt\r\n"},
+		HandlerRespValue: FileNameNotAllowed,
+	},
+	{
+		TestName:         "Test_Rename_To_Without_From",
+		User:             "admin",
+		Commands:         []string{"RNTO world.txt\r\n"},
+		HandlerRespValue: BadSequence,
+	},
+	{
+		TestName:         "Test_Rename_Across_Mounts",
+		User:             "admin",
+		Commands:         []string{"RNFR hello.txt\r\n", "RNTO archive/hello.txt\r\n"},
+		HandlerRespValue: FileNameNotAllowed,
+	},
+	{
+		TestName:         "Test_Rename_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"RNFR hello.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Retrieve_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"RETR hello.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Store_Denied_ReadOnly",
+		User:             "auditor",
+		Commands:         []string{"STOR new.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Store_Overwrite_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"STOR hello.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Append_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"APPE hello.txt\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_List_Denied_DropBox",
+		User:             "partner",
+		Commands:         []string{"LIST\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+	{
+		TestName:         "Test_Site_Help",
+		User:             "admin",
+		Commands:         []string{"SITE HELP\r\n"},
+		HandlerRespValue: "214 SITE HELP QUOTA",
+	},
+	{
+		TestName:         "Test_Site_Denied_ReadOnly",
+		User:             "auditor",
+		Commands:         []string{"SITE HELP\r\n"},
+		HandlerRespValue: FileNotFound,
+	},
+}
+
+func setupTree(t *testing.T) vfs.Filesystem {
+	fs := vfs.NewMemory()
+
+	for _, dir := range []string{"/temp", "/temp/empty", "/temp/incoming"} {
+		if err := fs.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range []string{"/temp/hello.txt", "/temp/incoming/upload.txt"} {
+		f, err := fs.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, "hello world!")
+		f.Close()
+	}
+
+	// /temp/archive is served by a filesystem of its own
+	mounts, err := vfs.NewMounts(vfs.Mount{Path: "/", FS: fs}, vfs.Mount{Path: "/temp/archive", FS: vfs.NewMemory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mounts
+}
+
+func TestFileDriver(t *testing.T) {
+	for _, testcase := range fileTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			t.Parallel()
+
+			_, server := net.Pipe()
+			w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+				WithAuthenticator(testUsers), WithFilesystem(setupTree(t)))
+			w.dataWorker.SetPWD("/temp")
+			w.currentUser = testcase.User
+			w.loggedIn = true
+
+			var resp Response
+			for _, command := range testcase.Commands {
+				handler, req, err := w.Parse(command)
+				if err != nil {
+					t.Errorf("Expected nil error from Parse, but got %v", err)
+				}
+
+				// transfers have to follow a data connection being set up
+				if _, transfer := transfers[CMD(req.Cmd)]; transfer {
+					w.state.Set(Pasv)
+				}
+				resp, _ = handler(req)
+			}
+
+			if resp != testcase.HandlerRespValue {
+				t.Errorf("Expected Response: %s, but got %s", testcase.HandlerRespValue, resp)
+			}
+		})
+	}
+}
+
+func Test_Site_Quota(t *testing.T) {
+	fs := setupTree(t)
+	tracker := quota.New(quota.Config{
+		Users: map[string]quota.Limit{"partner": {Bytes: 100}},
+		Paths: map[string]quota.Limit{"/temp": {Files: 5}, "/temp/incoming": {Bytes: 50}},
+	}, fs)
+	tracker.Reconcile()
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithAuthenticator(testUsers), WithFilesystem(fs), WithQuotas(tracker))
+	w.currentUser = "mixed"
+	w.loggedIn = true
+
+	// only the directories the user can upload to are reported
+	handler, req, _ := w.Parse("SITE QUOTA\r\n")
+	expected := Response("200-Quotas of mixed\r\n /temp/incoming: 12 of 50 bytes used, 38 remaining, 1 files used\r\n200 End")
+	if resp, _ := handler(req); resp != expected {
+		t.Errorf("Expected Response: %s, but got %s", expected, resp)
+	}
+
+	w.currentUser = "partner"
+	handler, req, _ = w.Parse("SITE QUOTA\r\n")
+	expected = Response("200-Quotas of partner\r\n user partner: 0 of 100 bytes used, 100 remaining, 0 files used\r\n" +
+		" /temp: 24 bytes used, 2 of 5 files used, 3 remaining\r\n" +
+		" /temp/incoming: 12 of 50 bytes used, 38 remaining, 1 files used\r\n200 End")
+	if resp, _ := handler(req); resp != expected {
+		t.Errorf("Expected Response: %s, but got %s", expected, resp)
+	}
+}
+
+func Test_Quota_Counted_Once(t *testing.T) {
+	fs := setupTree(t)
+	tracker := quota.New(quota.Config{Users: map[string]quota.Limit{"partner": {Bytes: 100}}}, fs)
+	tracker.Reconcile()
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithAuthenticator(testUsers), WithFilesystem(fs), WithQuotas(tracker))
+
+	// logging in a second time, the way a session does after REIN
+	for range 2 {
+		w.loggedIn = false
+		for _, command := range []string{"USER partner\r\n", "PASS password\r\n"} {
+			handler, req, _ := w.Parse(command)
+			handler(req)
+		}
+	}
+
+	fd, err := w.fs.Create("/temp/incoming/upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write(make([]byte, 10))
+	fd.Close()
+
+	if used := tracker.Status("partner")[0].Usage.Bytes; used != 10 {
+		t.Errorf("Expected: %d, but got %d", 10, used)
+	}
+}
+
+func Test_File_Events(t *testing.T) {
+	recorded := &recorder{}
+	bus := events.New(logger.NewStdStreamClient(), events.Subscription{Subscriber: recorded})
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithAuthenticator(testUsers), WithFilesystem(setupTree(t)), WithEvents(bus))
+	w.dataWorker.SetPWD("/temp")
+	w.currentUser = "admin"
+	w.loggedIn = true
+
+	// failures aren't raised
+	for _, command := range []string{"MKD reports\r\n", "RNFR hello.txt\r\n", "RNTO world.txt\r\n", "DELE world.txt\r\n", "DELE world.txt\r\n"} {
+		handler, req, _ := w.Parse(command)
+		handler(req)
+	}
+	bus.Close()
+
+	expected := []events.Event{
+		{Type: events.Mkdir, Session: w.session, User: "admin", Path: "/temp/reports"},
+		{Type: events.Rename, Session: w.session, User: "admin", Path: "/temp/hello.txt", To: "/temp/world.txt"},
+		{Type: events.Delete, Session: w.session, User: "admin", Path: "/temp/world.txt", Size: 12},
+	}
+	for i := range recorded.events {
+		recorded.events[i].Time = time.Time{}
+	}
+	if !slices.Equal(recorded.events, expected) {
+		t.Errorf("Expected: %v, but got %v", expected, recorded.events)
+	}
+}
@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// network protocol numbers used by EPRT/EPSV
+// https://www.rfc-editor.org/rfc/rfc2428#section-2
+const (
+	familyIPv4 = "1"
+	familyIPv6 = "2"
+)
+
+// address families used by LPRT/LPSV
+// https://www.rfc-editor.org/rfc/rfc1639#section-2
+const (
+	longFamilyIPv4 = 4
+	longFamilyIPv6 = 6
+)
+
+var errUnsupportedFamily = errors.New("unsupported network protocol")
+
+// parsePort parses the argument of PORT, h1,h2,h3,h4,p1,p2 where
+// each of the six fields is a decimal byte
+func parsePort(arg string) (netip.AddrPort, error) {
+	fields := strings.Split(arg, ",")
+	if len(fields) != 6 {
+		return netip.AddrPort{}, fmt.Errorf("malformed PORT argument: %q", arg)
+	}
+
+	var bytes [6]byte
+	for i, field := range fields {
+		b, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("malformed PORT argument: %q", arg)
+		}
+		bytes[i] = byte(b)
+	}
+
+	host := netip.AddrFrom4([4]byte(bytes[:4]))
+	return netip.AddrPortFrom(host, uint16(bytes[4])<<8|uint16(bytes[5])), nil
+}
+
+// formatPort is the inverse of parsePort, also used for PASV replies
+func formatPort(addr netip.AddrPort) string {
+	h := addr.Addr().As4()
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d", h[0], h[1], h[2], h[3], addr.Port()>>8, addr.Port()&0xFF)
+}
+
+// parseLongPort parses the argument of LPRT, every field is a decimal byte
+//
+//	LPRT af,hal,h1,h2,...,hn,pal,p1,p2
+//
+// where af is the address family (4 or 6), hal is the number of address
+// bytes that follow (4 or 16) and pal the number of port bytes (2)
+func parseLongPort(arg string) (netip.AddrPort, error) {
+	fields := strings.Split(arg, ",")
+	bytes := make([]byte, len(fields))
+	for i, field := range fields {
+		b, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("malformed LPRT argument: %q", arg)
+		}
+		bytes[i] = byte(b)
+	}
+
+	if len(bytes) < 2 {
+		return netip.AddrPort{}, fmt.Errorf("malformed LPRT argument: %q", arg)
+	}
+
+	af, hal := bytes[0], int(bytes[1])
+	switch {
+	case af == longFamilyIPv4 && hal == 4, af == longFamilyIPv6 && hal == 16:
+	case af != longFamilyIPv4 && af != longFamilyIPv6:
+		return netip.AddrPort{}, errUnsupportedFamily
+	default:
+		return netip.AddrPort{}, fmt.Errorf("malformed LPRT address length: %d", hal)
+	}
+
+	if len(bytes) != 2+hal+1+2 || bytes[2+hal] != 2 {
+		return netip.AddrPort{}, fmt.Errorf("malformed LPRT argument: %q", arg)
+	}
+
+	host, _ := netip.AddrFromSlice(bytes[2 : 2+hal])
+	port := uint16(bytes[3+hal])<<8 | uint16(bytes[4+hal])
+	return netip.AddrPortFrom(host, port), nil
+}
+
+// formatLongPort is the inverse of parseLongPort, also used for LPSV replies
+func formatLongPort(addr netip.AddrPort) string {
+	host := addr.Addr().Unmap()
+
+	fields := []string{strconv.Itoa(longFamilyIPv6), "16"}
+	if host.Is4() {
+		fields = []string{strconv.Itoa(longFamilyIPv4), "4"}
+	}
+
+	for _, b := range host.AsSlice() {
+		fields = append(fields, strconv.Itoa(int(b)))
+	}
+	fields = append(fields, "2", strconv.Itoa(int(addr.Port()>>8)), strconv.Itoa(int(addr.Port()&0xFF)))
+
+	return strings.Join(fields, ",")
+}
+
+// parseExtendedPort parses the argument of EPRT, <d><net-prt><d><net-addr><d><tcp-port><d>
+// where <d> is any printable ASCII character (usually |), for example
+//
+//	EPRT |1|132.235.1.2|6275|
+//	EPRT |2|1080::8:800:200C:417A|5282|
+func parseExtendedPort(arg string) (netip.AddrPort, error) {
+	if len(arg) < 2 || arg[0] < 33 || arg[0] > 126 {
+		return netip.AddrPort{}, fmt.Errorf("malformed EPRT argument: %q", arg)
+	}
+
+	fields := strings.Split(arg, string(arg[0]))
+	if len(fields) != 5 || fields[0] != "" || fields[4] != "" {
+		return netip.AddrPort{}, fmt.Errorf("malformed EPRT argument: %q", arg)
+	}
+
+	family, host, port := fields[1], fields[2], fields[3]
+	if family != familyIPv4 && family != familyIPv6 {
+		return netip.AddrPort{}, errUnsupportedFamily
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	if (family == familyIPv4) != addr.Is4() {
+		return netip.AddrPort{}, fmt.Errorf("address %s does not belong to network protocol %s", addr, family)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return netip.AddrPort{}, fmt.Errorf("malformed EPRT port: %q", port)
+	}
+
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+// formatExtendedPort is the inverse of parseExtendedPort
+func formatExtendedPort(addr netip.AddrPort) string {
+	family := familyIPv6
+	if addr.Addr().Is4() {
+		family = familyIPv4
+	}
+
+	return fmt.Sprintf("|%s|%s|%d|", family, addr.Addr(), addr.Port())
+}
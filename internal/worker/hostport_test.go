@@ -0,0 +1,232 @@
+package worker
+
+import (
+	"net/netip"
+	"testing"
+)
+
+var extendedPortTestCases = []struct {
+	TestName string
+	Arg      string
+	Expected string
+	Valid    bool
+}{
+	{TestName: "Test_EPRT_IPv4", Arg: "|1|132.235.1.2|6275|", Expected: "132.235.1.2:6275", Valid: true},
+	{TestName: "Test_EPRT_IPv6", Arg: "|2|1080::8:800:200C:417A|5282|", Expected: "[1080::8:800:200c:417a]:5282", Valid: true},
+	{TestName: "Test_EPRT_Other_Delimiter", Arg: "!1!10.0.0.1!21!", Expected: "10.0.0.1:21", Valid: true},
+	{TestName: "Test_EPRT_Unknown_Family", Arg: "|3|10.0.0.1|21|"},
+	{TestName: "Test_EPRT_Family_Mismatch", Arg: "|1|::1|21|"},
+	{TestName: "Test_EPRT_Missing_Field", Arg: "|1|10.0.0.1|"},
+	{TestName: "Test_EPRT_Trailing_Data", Arg: "|1|10.0.0.1|21|x"},
+	{TestName: "Test_EPRT_Port_Out_Of_Range", Arg: "|1|10.0.0.1|65536|"},
+	{TestName: "Test_EPRT_Port_Zero", Arg: "|1|10.0.0.1|0|"},
+	{TestName: "Test_EPRT_Empty", Arg: ""},
+	{TestName: "Test_EPRT_Space_Delimiter", Arg: " 1 10.0.0.1 21 "},
+}
+
+func TestParseExtendedPort(t *testing.T) {
+	for _, testcase := range extendedPortTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			addr, err := parseExtendedPort(testcase.Arg)
+			if !testcase.Valid {
+				if err == nil {
+					t.Errorf("Expected error parsing %q, but got %v", testcase.Arg, addr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected nil error, but got %v", err)
+			}
+
+			if addr.String() != testcase.Expected {
+				t.Errorf("Expected: %s, but got %s", testcase.Expected, addr)
+			}
+
+			// round trip
+			again, err := parseExtendedPort(formatExtendedPort(addr))
+			if err != nil || again != addr {
+				t.Errorf("Expected: %s, but got %s (%v)", addr, again, err)
+			}
+		})
+	}
+}
+
+func TestParseExtendedPortUnsupportedFamily(t *testing.T) {
+	if _, err := parseExtendedPort("|3|10.0.0.1|21|"); err != errUnsupportedFamily {
+		t.Errorf("Expected: %v, but got %v", errUnsupportedFamily, err)
+	}
+}
+
+func TestGenerateExtendedPassiveResponse(t *testing.T) {
+	expected := Response("229 Entering Extended Passive Mode (|||6446|)")
+	if resp := GenerateExtendedPassiveResponse(6446); resp != expected {
+		t.Errorf("Expected: %s, but got %s", expected, resp)
+	}
+}
+
+func TestGeneratePassiveResponse(t *testing.T) {
+	expected := Response("227 Entering Passive Mode (10,0,0,1,25,46)")
+	if resp := GeneratePassiveResponse(netip.MustParseAddr("10.0.0.1"), 6446); resp != expected {
+		t.Errorf("Expected: %s, but got %s", expected, resp)
+	}
+}
+
+var longPortTestCases = []struct {
+	TestName string
+	Arg      string
+	Expected string
+	Valid    bool
+}{
+	{TestName: "Test_LPRT_IPv4", Arg: "4,4,132,235,1,2,2,24,131", Expected: "132.235.1.2:6275", Valid: true},
+	{TestName: "Test_LPRT_IPv6", Arg: "6,16,16,128,0,0,0,0,0,0,0,8,8,0,32,12,65,122,2,20,162", Expected: "[1080::8:800:200c:417a]:5282", Valid: true},
+	{TestName: "Test_LPRT_Unknown_Family", Arg: "5,4,132,235,1,2,2,24,131"},
+	{TestName: "Test_LPRT_Wrong_Address_Length", Arg: "4,16,132,235,1,2,2,24,131"},
+	{TestName: "Test_LPRT_Wrong_Port_Length", Arg: "4,4,132,235,1,2,3,24,131"},
+	{TestName: "Test_LPRT_Missing_Port_Byte", Arg: "4,4,132,235,1,2,2,24"},
+	{TestName: "Test_LPRT_Byte_Out_Of_Range", Arg: "4,4,132,235,1,256,2,24,131"},
+	{TestName: "Test_LPRT_Empty", Arg: ""},
+}
+
+func TestParseLongPort(t *testing.T) {
+	for _, testcase := range longPortTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			addr, err := parseLongPort(testcase.Arg)
+			if !testcase.Valid {
+				if err == nil {
+					t.Errorf("Expected error parsing %q, but got %v", testcase.Arg, addr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected nil error, but got %v", err)
+			}
+
+			if addr.String() != testcase.Expected {
+				t.Errorf("Expected: %s, but got %s", testcase.Expected, addr)
+			}
+
+			if encoded := formatLongPort(addr); encoded != testcase.Arg {
+				t.Errorf("Expected: %s, but got %s", testcase.Arg, encoded)
+			}
+		})
+	}
+}
+
+func TestParseLongPortUnsupportedFamily(t *testing.T) {
+	if _, err := parseLongPort("5,4,132,235,1,2,2,24,131"); err != errUnsupportedFamily {
+		t.Errorf("Expected: %v, but got %v", errUnsupportedFamily, err)
+	}
+}
+
+var portTestCases = []struct {
+	TestName string
+	Arg      string
+	Expected string
+	Valid    bool
+}{
+	{TestName: "Test_PORT_Valid", Arg: "132,235,1,2,24,131", Expected: "132.235.1.2:6275", Valid: true},
+	{TestName: "Test_PORT_Zeroes", Arg: "0,0,0,0,0,0", Expected: "0.0.0.0:0", Valid: true},
+	{TestName: "Test_PORT_Max", Arg: "255,255,255,255,255,255", Expected: "255.255.255.255:65535", Valid: true},
+	{TestName: "Test_PORT_Too_Few_Fields", Arg: "1,2"},
+	{TestName: "Test_PORT_Too_Many_Fields", Arg: "1,2,3,4,5,6,7"},
+	{TestName: "Test_PORT_Field_Out_Of_Range", Arg: "1,2,3,4,5,256"},
+	{TestName: "Test_PORT_Negative_Field", Arg: "1,2,3,4,5,-6"},
+	{TestName: "Test_PORT_Empty_Field", Arg: "1,2,3,,5,6"},
+	{TestName: "Test_PORT_Non_Numeric", Arg: "a,b,c,d,e,f"},
+	{TestName: "Test_PORT_Spaces", Arg: "1, 2,3,4,5,6"},
+	{TestName: "Test_PORT_Empty", Arg: ""},
+}
+
+func TestParsePort(t *testing.T) {
+	for _, testcase := range portTestCases {
+		t.Run(testcase.TestName, func(t *testing.T) {
+			addr, err := parsePort(testcase.Arg)
+			if !testcase.Valid {
+				if err == nil {
+					t.Errorf("Expected error parsing %q, but got %v", testcase.Arg, addr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected nil error, but got %v", err)
+			}
+
+			if addr.String() != testcase.Expected {
+				t.Errorf("Expected: %s, but got %s", testcase.Expected, addr)
+			}
+
+			if encoded := formatPort(addr); encoded != testcase.Arg {
+				t.Errorf("Expected: %s, but got %s", testcase.Arg, encoded)
+			}
+		})
+	}
+}
+
+func FuzzParsePort(f *testing.F) {
+	for _, testcase := range portTestCases {
+		f.Add(testcase.Arg)
+	}
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		addr, err := parsePort(arg)
+		if err != nil {
+			return
+		}
+
+		again, err := parsePort(formatPort(addr))
+		if err != nil || again != addr {
+			t.Errorf("Expected: %s, but got %s (%v)", addr, again, err)
+		}
+	})
+}
+
+func FuzzParseExtendedPort(f *testing.F) {
+	for _, testcase := range extendedPortTestCases {
+		f.Add(testcase.Arg)
+	}
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		addr, err := parseExtendedPort(arg)
+		if err != nil {
+			return
+		}
+
+		again, err := parseExtendedPort(formatExtendedPort(addr))
+		if err != nil || again != addr {
+			t.Errorf("Expected: %s, but got %s (%v)", addr, again, err)
+		}
+	})
+}
+
+func FuzzParseLongPort(f *testing.F) {
+	for _, testcase := range longPortTestCases {
+		f.Add(testcase.Arg)
+	}
+
+	f.Fuzz(func(t *testing.T, arg string) {
+		addr, err := parseLongPort(arg)
+		if err != nil {
+			return
+		}
+
+		again, err := parseLongPort(formatLongPort(addr))
+		if err != nil || again != addr {
+			t.Errorf("Expected: %s, but got %s (%v)", addr, again, err)
+		}
+	})
+}
+
+func TestGenerateLongPassiveResponse(t *testing.T) {
+	expected := Response("228 Entering Long Passive Mode (4,4,10,0,0,1,2,25,46)")
+	if resp := GenerateLongPassiveResponse(netip.MustParseAddr("10.0.0.1"), 6446); resp != expected {
+		t.Errorf("Expected: %s, but got %s", expected, resp)
+	}
+
+	expected = Response("228 Entering Long Passive Mode (6,16,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,2,25,46)")
+	if resp := GenerateLongPassiveResponse(netip.MustParseAddr("::1"), 6446); resp != expected {
+		t.Errorf("Expected: %s, but got %s", expected, resp)
+	}
+}
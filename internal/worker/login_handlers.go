@@ -1,16 +1,22 @@
 package worker
 
-import "fmt"
+import (
+	"fmt"
+	"goftp/internal/auth"
+	"goftp/internal/events"
+	"time"
+)
 
-func (c ControlWorker) checkIfLoggedIn(fn Handler) Handler {
-	return func(req *Request) (Response, error) {
-		if c.loggedIn {
-			return fn(req)
-		}
-
-		c.logger.Info(fmt.Sprintf("client not authenticated to run CMD"))
-		return NotLoggedIn, nil
+// permitted looks the current user up on every call so that changes
+// to their account apply to sessions which are already logged in
+func (c ControlWorker) permitted(perm auth.Permission, pth string) bool {
+	user, ok := c.auth.Lookup(c.currentUser)
+	if !ok || !user.Can(perm, pth) {
+		c.logger.Info(fmt.Sprintf("user %s denied permission %d on %s", c.currentUser, perm, pth))
+		return false
 	}
+
+	return true
 }
 
 func (c *ControlWorker) handleUserLogin(req *Request) (Response, error) {
@@ -18,7 +24,7 @@ func (c *ControlWorker) handleUserLogin(req *Request) (Response, error) {
 		return UserLoggedIn, nil
 	}
 
-	if _, ok := c.users[req.Arg]; !ok {
+	if _, ok := c.auth.Lookup(req.Arg); !ok {
 		c.logger.Info(fmt.Sprintf("username: %s, not recognized", req.Arg))
 		return NotLoggedIn, nil
 	}
@@ -29,21 +35,92 @@ func (c *ControlWorker) handleUserLogin(req *Request) (Response, error) {
 }
 
 func (c *ControlWorker) handleUserPassword(req *Request) (Response, error) {
-	if pw, ok := c.users[c.currentUser]; ok {
-		if pw == req.Arg {
-			c.loggedIn = true
-			return UserLoggedIn, nil
+	// logging in again would reserve another login, which is only given back once
+	if c.loggedIn {
+		return UserLoggedIn, nil
+	}
+
+	ip := c.controlConnection.RemoteIP()
+	if _, err := c.auth.Authenticate(c.currentUser, req.Arg); err == nil {
+		c.guard.Succeed(ip)
+		if err := c.limits.Login(c.currentUser); err != nil {
+			c.logger.Info(fmt.Sprintf("refusing login for username %s: %v", c.currentUser, err))
+			return ServiceNotAvailable, nil
+		}
+
+		c.loggedIn = true
+		if c.quotas != nil {
+			c.fs = c.quotas.Filesystem(c.base, c.currentUser)
+			c.dataWorker.SetFilesystem(c.fs)
 		}
+		if c.throttle != nil {
+			// the session of an earlier login would otherwise be kept among the user's for good
+			if c.rate != nil {
+				c.rate.Close()
+			}
+			c.rate = c.throttle.Session(c.currentUser)
+			c.dataWorker.SetThrottle(c.rate)
+		}
+		c.dataWorker.SetUser(c.currentUser)
+		c.publish(events.Event{Type: events.Login})
+		return UserLoggedIn, nil
+	}
+
+	c.logger.Info(fmt.Sprintf("incorrect password received for username %s from %s", c.currentUser, ip))
+
+	c.failedLogins++
+	delay, banned := c.guard.Fail(ip)
+	select {
+	case <-time.After(delay):
+	case <-c.ctx.Done():
+	}
+
+	if banned {
+		c.logger.Info(fmt.Sprintf("banning %s after repeated failed logins", ip))
+		return ServiceNotAvailable, nil
+	}
+
+	if max := c.guard.MaxSessionAttempts(); max > 0 && c.failedLogins >= max {
+		c.logger.Info(fmt.Sprintf("closing session from %s after %d failed logins", ip, c.failedLogins))
+		return ServiceNotAvailable, nil
 	}
 
-	c.logger.Info(fmt.Sprintf("incorrect password received for username %s", c.currentUser))
 	return NotLoggedIn, nil
 }
 
+// REIN logs the user out and resets the session, leaving the control connection open for the next one
+// https://www.rfc-editor.org/rfc/rfc959#section-4.1.1
+//
+//	120, 220, 421
+//	500, 502
 func (c *ControlWorker) handleReinitialize(req *Request) (Response, error) {
-	c.currentUser = ""
+	c.logout()
+	c.currentUser, c.renameFrom, c.restart = "", "", 0
+
+	c.fs = c.base
+	c.dataWorker.SetFilesystem(c.base)
+	c.dataWorker.SetThrottle(nil)
+	c.dataWorker.SetUser("")
+	c.dataWorker.SetRestart(0)
+	c.dataWorker.SetPWD(c.home)
+	c.dataWorker.SetType('I')
+	c.dataWorker.SetMode('S')
+	c.dataWorker.SetStructure('F')
+
+	return c.greeting, nil
+}
+
+// logout gives back what the user was handed on logging in, for REIN and once the session ends
+func (c *ControlWorker) logout() {
+	if c.loggedIn {
+		c.limits.Logout(c.currentUser)
+		c.publish(events.Event{Type: events.Logout})
+	}
+	if c.rate != nil {
+		c.rate.Close()
+		c.rate = nil
+	}
 	c.loggedIn = false
-	return Response(fmt.Sprintf(string(DirectoryResponse), c.dataWorker.GetPWD())), nil
 }
 
 func (c ControlWorker) handleQuit(req *Request) (Response, error) {
@@ -1,10 +1,19 @@
 package worker
 
 import (
+	"bufio"
 	"context"
+	"goftp/internal/events"
+	"goftp/internal/guard"
+	"goftp/internal/limits"
 	"goftp/internal/logger"
+	"goftp/internal/quota"
+	"goftp/internal/throttle"
+	"io"
 	"net"
+	"slices"
 	"testing"
+	"time"
 )
 
 // table-driven tests for individual handlers
@@ -55,6 +64,50 @@ var accessControlTestCases = []struct {
 		HandlerErrCheck:  expectNilErr,
 		HandlerRespValue: UserLoggedIn,
 	},
+	{
+		TestName: "Test_User_Password_Session_Attempts_Exceeded",
+		Command:  "PASS password123\r\n",
+		MutationFunc: func(w *ControlWorker) {
+			w.currentUser = "hkhan"
+			w.guard = guard.New(guard.Config{MaxSessionAttempts: 1})
+		},
+		HandlerErrCheck:  expectNilErr,
+		HandlerRespValue: ServiceNotAvailable,
+	},
+	{
+		TestName: "Test_User_Password_Banned",
+		Command:  "PASS password123\r\n",
+		MutationFunc: func(w *ControlWorker) {
+			w.currentUser = "hkhan"
+			w.guard = guard.New(guard.Config{MaxAttempts: 1, BanDuration: time.Minute})
+		},
+		HandlerErrCheck:  expectNilErr,
+		HandlerRespValue: ServiceNotAvailable,
+	},
+	{
+		TestName: "Test_User_Password_Too_Many_Logins",
+		Command:  "PASS password\r\n",
+		MutationFunc: func(w *ControlWorker) {
+			w.currentUser = "hkhan"
+			w.limits = limits.New(limits.Config{MaxLoginsPerUser: 1})
+			w.limits.Login("hkhan")
+		},
+		HandlerErrCheck:  expectNilErr,
+		HandlerRespValue: ServiceNotAvailable,
+	},
+	{
+		TestName: "Test_User_Password_Already_Logged_In",
+		Command:  "PASS password123\r\n",
+		MutationFunc: func(w *ControlWorker) {
+			w.currentUser = "hkhan"
+			w.loggedIn = true
+			w.limits = limits.New(limits.Config{MaxLoginsPerUser: 1})
+			w.limits.Login("hkhan")
+			w.guard = guard.New(guard.Config{MaxAttempts: 1, BanDuration: time.Minute})
+		},
+		HandlerErrCheck:  expectNilErr,
+		HandlerRespValue: UserLoggedIn,
+	},
 }
 
 func expectNilErr(e error, t *testing.T) {
@@ -89,3 +142,76 @@ func TestDriver(t *testing.T) {
 		})
 	}
 }
+
+func Test_Login_Events(t *testing.T) {
+	recorded := &recorder{}
+	bus := events.New(logger.NewStdStreamClient(), events.Subscription{Subscriber: recorded})
+
+	client, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server, WithEvents(bus))
+	done := make(chan struct{})
+	go func() {
+		w.Start()
+		close(done)
+	}()
+
+	// logging out is raised by REIN, and as the session ends
+	scanner := bufio.NewScanner(client)
+	scanner.Scan()
+	for _, command := range []string{
+		"USER hkhan\r\n", "PASS password\r\n", "REIN\r\n", "USER hkhan\r\n", "PASS password\r\n", "QUIT\r\n",
+	} {
+		io.WriteString(client, command)
+		scanner.Scan()
+		if command == "REIN\r\n" && scanner.Text() != string(ServiceReady) {
+			t.Errorf("Expected: %s, but got %s", ServiceReady, scanner.Text())
+		}
+	}
+	<-done
+	bus.Close()
+
+	var types []events.Type
+	for _, e := range recorded.events {
+		if e.User != "hkhan" || e.Session != w.session {
+			t.Errorf("Expected events of hkhan in session %s, but got %v", w.session, e)
+		}
+		types = append(types, e.Type)
+	}
+	if expected := []events.Type{events.Login, events.Logout, events.Login, events.Logout}; !slices.Equal(types, expected) {
+		t.Errorf("Expected: %v, but got %v", expected, types)
+	}
+}
+
+func Test_Reinitialize(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithFilesystem(setupTree(t)),
+		WithQuotas(quota.New(quota.Config{}, nil)),
+		WithThrottle(throttle.New(throttle.Config{})),
+		WithLimits(limits.New(limits.Config{MaxLoginsPerUser: 1})),
+	)
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{"USER hkhan\r\n", UserOkNeedPW},
+		{"PASS password\r\n", UserLoggedIn},
+		{"TYPE A\r\n", CommandOK},
+		{"REIN\r\n", ServiceReady},
+		{"NOOP\r\n", NotLoggedIn},
+		// the login given back by REIN can be taken again
+		{"USER hkhan\r\n", UserOkNeedPW},
+		{"PASS password\r\n", UserLoggedIn},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		if resp, _ := handler(req); resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+		if step.Command == "REIN\r\n" {
+			if w.fs != w.base || w.rate != nil || w.dataWorker.GetType() != 'I' {
+				t.Errorf("Expected the session to be reset, but got %T, %v and TYPE %c", w.fs, w.rate, w.dataWorker.GetType())
+			}
+		}
+	}
+}
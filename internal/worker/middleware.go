@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"fmt"
+	"goftp/internal/auth"
+)
+
+// Middleware wraps the Handler of every command with logic that cuts across them, it's handed
+// the next Handler of the chain and returns one that decides whether, and how, to call it
+//
+// requests go through the built in middleware first, logging, draining, login, permission and
+// sequence checks in that order, followed by whatever was added with WithMiddleware in the
+// order it was added. A Middleware that denies certain filenames could look like
+//
+//	func(next Handler) Handler {
+//		return func(req *Request) (Response, error) {
+//			if strings.HasSuffix(req.Path(), ".exe") {
+//				return FileNameNotAllowed, nil
+//			}
+//			return next(req)
+//		}
+//	}
+type Middleware func(Handler) Handler
+
+// WithMiddleware runs every request through middleware, after the built in middleware
+func WithMiddleware(middleware ...Middleware) func(*ControlWorker) {
+	return func(c *ControlWorker) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// Chain wraps h in middleware, so that requests go through the first one given first
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h
+}
+
+// chain wraps h in the built in middleware followed by the one added with WithMiddleware
+func (c *ControlWorker) chain(h Handler) Handler {
+	builtin := []Middleware{
+		c.logRequests,
+		c.refuseWhileDraining,
+		c.requireLogin,
+		c.requirePermission,
+		c.checkSequence,
+	}
+
+	return Chain(h, append(builtin, c.middleware...)...)
+}
+
+func (c *ControlWorker) logRequests(next Handler) Handler {
+	return func(req *Request) (Response, error) {
+		c.logger.Info(req.String())
+		return next(req)
+	}
+}
+
+// refuseWhileDraining turns away transfers once the server starts draining
+func (c *ControlWorker) refuseWhileDraining(next Handler) Handler {
+	return func(req *Request) (Response, error) {
+		if _, transfer := transfers[CMD(req.Cmd)]; transfer && c.draining() {
+			return ServiceNotAvailable, nil
+		}
+
+		return next(req)
+	}
+}
+
+// checkSequence refuses requests that don't fit the current state, see State.Check
+func (c *ControlWorker) checkSequence(next Handler) Handler {
+	return func(req *Request) (Response, error) {
+		return c.state.Check(req, next)(req)
+	}
+}
+
+// requireLogin refuses requests for commands that need the client to have logged in, until it has
+func (c *ControlWorker) requireLogin(next Handler) Handler {
+	return func(req *Request) (Response, error) {
+		if !req.login || c.loggedIn {
+			return next(req)
+		}
+
+		c.logger.Info(fmt.Sprintf("client not authenticated to run %s", req.Cmd))
+		return NotLoggedIn, nil
+	}
+}
+
+// requirePermission rejects the request with 550 unless the current user holds the
+// permission its command needs for the virtual path the request resolves to
+func (c *ControlWorker) requirePermission(next Handler) Handler {
+	return func(req *Request) (Response, error) {
+		if req.permission == auth.NoPermissions || c.permitted(req.permission, c.resolve(req.Arg)) {
+			return next(req)
+		}
+
+		return FileNotFound, nil
+	}
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"goftp/internal/logger"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_Middleware_Order(t *testing.T) {
+	var ran []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(req *Request) (Response, error) {
+				ran = append(ran, name+" "+req.Cmd)
+				return next(req)
+			}
+		}
+	}
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithMiddleware(record("first"), record("second")))
+
+	// the built in middleware turns the request away before it gets to the others
+	handler, req, _ := w.Parse("NOOP\r\n")
+	if resp, _ := handler(req); resp != NotLoggedIn {
+		t.Errorf("Expected Response: %s, but got %s", NotLoggedIn, resp)
+	}
+
+	w.loggedIn = true
+	handler, req, _ = w.Parse("NOOP\r\n")
+	if resp, _ := handler(req); resp != CommandOK {
+		t.Errorf("Expected Response: %s, but got %s", CommandOK, resp)
+	}
+
+	if expected := []string{"first NOOP", "second NOOP"}; !slices.Equal(ran, expected) {
+		t.Errorf("Expected: %v, but got %v", expected, ran)
+	}
+}
+
+func Test_Middleware_Deny_Filename(t *testing.T) {
+	var users []string
+	deny := func(next Handler) Handler {
+		return func(req *Request) (Response, error) {
+			users = append(users, req.User())
+			if strings.HasSuffix(req.Path(), ".exe") {
+				return FileNameNotAllowed, nil
+			}
+			return next(req)
+		}
+	}
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server,
+		WithAuthenticator(testUsers), WithFilesystem(setupTree(t)), WithMiddleware(deny))
+	w.dataWorker.SetPWD("/temp")
+	w.currentUser = "admin"
+	w.loggedIn = true
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{"MKD setup.exe\r\n", FileNameNotAllowed},
+		{"MKD setup\r\n", `257 "/temp/setup"`},
+		{"RNFR hello.txt\r\n", PendingFurtherInfo},
+		{"RNTO hello.exe\r\n", FileNameNotAllowed},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		if resp, _ := handler(req); resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+
+	if users[0] != "admin" {
+		t.Errorf("Expected: %s, but got %s", "admin", users[0])
+	}
+}
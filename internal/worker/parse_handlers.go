@@ -2,6 +2,7 @@ package worker
 
 import (
 	"fmt"
+	"goftp/internal/auth"
 	"regexp"
 	"strings"
 )
@@ -10,24 +11,57 @@ var pattern *regexp.Regexp
 
 func init() {
 	// potentially match against CMDs, Args
-	pattern = regexp.MustCompile("\r\n")
+	pattern = regexp.MustCompile("\r\n$")
 }
 
 type Request struct {
 	Cmd string
 	Arg string
+
+	// session the request was received on, nil when it wasn't parsed off a control connection
+	session *ControlWorker
+
+	// what the command needs before its handler runs, checked by the built in middleware
+	login      bool
+	permission auth.Permission
+	sequence   Sequence
 }
 
 func (r *Request) String() string {
 	return fmt.Sprintf("%s %s", r.Cmd, r.Arg)
 }
 
+// User is who sent the request, "" until the client has logged in
+func (r *Request) User() string {
+	if r.session == nil || !r.session.loggedIn {
+		return ""
+	}
+	return r.session.currentUser
+}
+
+// Session identifies the session the request was received on
+func (r *Request) Session() string {
+	if r.session == nil {
+		return ""
+	}
+	return r.session.session
+}
+
+// Path is the argument of the request resolved to an absolute virtual path
+func (r *Request) Path() string {
+	if r.session == nil {
+		return r.Arg
+	}
+	return r.session.resolve(r.Arg)
+}
+
 func (c *ControlWorker) Parse(request string) (Handler, *Request, error) {
-	var req *Request
 	if !pattern.Match([]byte(request)) {
-		return c.handleSyntaxErrorParams, req, fmt.Errorf("request format is incorrect")
+		return c.handleSyntaxErrorParams, &Request{}, fmt.Errorf("request format is incorrect")
 	}
 
+	var req *Request
+
 	parsed := strings.Split(request, " ")
 	switch len(parsed) {
 	case 2:
@@ -37,47 +71,30 @@ func (c *ControlWorker) Parse(request string) (Handler, *Request, error) {
 		}
 	case 1:
 		req = &Request{
-			Cmd: string(parsed[0][:len(parsed[0])-2]),
+			Cmd: strings.ToUpper(string(parsed[0][:len(parsed[0])-2])),
 		}
 	default:
 		return c.handleSyntaxErrorParams, &Request{}, fmt.Errorf("unable to parse request")
 	}
 
-	c.logger.Info(req.String())
-
-	var handler Handler
-	switch req.Cmd {
-	case "USER":
-		return c.handleUserLogin, req, nil
-	case "PASS":
-		return c.handleUserPassword, req, nil
-	case "PWD":
-		handler = c.handlePWD
-	case "TYPE":
-		handler = c.handleType
-	case "MODE":
-		handler = c.handleMode
-	case "PASV":
-		handler = c.handlePassive
-	case "PORT":
-		handler = c.handlePort
-	case "STOR":
-		handler = c.handleStore
-	case "RETR":
-		handler = c.handleRetrieve
-	case "NOOP":
-		handler = c.handleNoop
-	case "QUIT":
-		return c.handleQuit, req, nil
-	case "LIST", "ACCT", "CWD", "CDUP", "SMNT", "REIN", "HELP",
-		"STRU", "STOU", "APPE", "ALLO", "REST", "RNFR", "RNTO",
-		"ABOR", "RMD", "MKD", "NLST", "SITE", "SYST", "STAT", "DELE":
-		return c.handleCmdNotImplemented, req, fmt.Errorf("CMD Not Implementd: %v", req.Cmd)
+	req.session = c
+
+	cmd, ok := c.commands.Lookup(req.Cmd)
+	switch {
+	case ok:
+		req.login, req.permission, req.sequence = !cmd.Public, cmd.Permission, cmd.Sequence
+		return c.chain(func(r *Request) (Response, error) { return cmd.Handler(c, r) }), req, nil
+	case notImplemented[req.Cmd]:
+		return c.chain(c.handleCmdNotImplemented), req, fmt.Errorf("CMD Not Implementd: %v", req.Cmd)
 	default:
-		return c.handleSyntaxErrorInvalidCmd, req, fmt.Errorf("invalid CMD: %s", req.Cmd)
+		return c.chain(c.handleSyntaxErrorInvalidCmd), req, fmt.Errorf("invalid CMD: %s", req.Cmd)
 	}
+}
 
-	return c.checkIfLoggedIn(handler), req, nil
+// recognized by RFC 959, but not implemented unless registered, see Commands
+var notImplemented = map[string]bool{
+	"ACCT": true, "CWD": true, "CDUP": true, "SMNT": true, "STRU": true,
+	"STOU": true, "ALLO": true, "ABOR": true, "SYST": true, "STAT": true,
 }
 
 func (c ControlWorker) handleSyntaxErrorParams(req *Request) (Response, error) {
@@ -8,8 +8,6 @@ import (
 	"testing"
 )
 
-// TODO: add fuzzing
-
 func Test_Parse_Format_Error(t *testing.T) {
 	_, server := net.Pipe()
 	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
@@ -56,7 +54,7 @@ func Test_Parse_Invalid_Cmd(t *testing.T) {
 	_, server := net.Pipe()
 	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
 
-	handler, req, err := w.Parse("EPSV\r\n")
+	handler, req, err := w.Parse("XEPS\r\n")
 	if err == nil {
 		t.Errorf("Expected not nil error")
 	}
@@ -75,3 +73,108 @@ func Test_Parse_Invalid_Cmd(t *testing.T) {
 		t.Errorf("Expected Response: %s, but got %s", SyntaxError1, resp)
 	}
 }
+
+func Test_Parse_Missing_CRLF(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+
+	handler, req, err := w.Parse("QUIT\n")
+	if err == nil {
+		t.Errorf("Expected not nil error")
+	}
+
+	// the request is checked against the current state before the handler runs
+	resp, _ := w.state.Check(req, handler)(req)
+	if resp != SyntaxError2 {
+		t.Errorf("Expected Response: %s, but got %s", SyntaxError2, resp)
+	}
+}
+
+func Test_Parse_Port_Malformed(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+	w.loggedIn = true
+
+	handler, req, err := w.Parse("PORT 1,2\r\n")
+	if err != nil {
+		t.Errorf("Expected nil error from Parse, but got %v", err)
+	}
+
+	resp, _ := handler(req)
+	if resp != SyntaxError2 {
+		t.Errorf("Expected Response: %s, but got %s", SyntaxError2, resp)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"USER hkhan\r\n", "PASS password\r\n", "QUIT\r\n", "PORT 1,2\r\n",
+		"abcd efg nhijk lmnop\r\n", "\r\n", " \r\n", "NOOP",
+	} {
+		f.Add(seed)
+	}
+
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+
+	f.Fuzz(func(t *testing.T, request string) {
+		_, req, _ := w.Parse(request)
+		if req == nil {
+			t.Errorf("Expected a non nil request for %q", request)
+		}
+	})
+}
+
+func Test_Restart(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+	w.loggedIn = true
+	w.currentUser = "hkhan"
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{"REST -1\r\n", SyntaxError2},
+		{"REST 1024\r\n", "350 Restarting at 1024. Send STORE or RETRIEVE to initiate transfer"},
+		{"STOR upload.txt\r\n", InvalidRestart},
+	} {
+		// as though a data connection had been set up, which STOR has to follow
+		w.state.Set(Pasv)
+		handler, req, _ := w.Parse(step.Command)
+		if resp, _ := handler(req); resp != step.Expected {
+			t.Errorf("Expected Response: %s, but got %s", step.Expected, resp)
+		}
+	}
+
+	// the marker was used up by the refused STOR
+	if w.restart != 0 {
+		t.Errorf("Expected: %d, but got %d", 0, w.restart)
+	}
+}
+
+func Test_Restart_ASCII(t *testing.T) {
+	_, server := net.Pipe()
+	w := NewControlWorker(context.Background(), logger.NewStdStreamClient(), server)
+	w.loggedIn = true
+
+	for _, step := range []struct {
+		Command  string
+		Expected Response
+	}{
+		{"REST 1024\r\n", "350 Restarting at 1024. Send STORE or RETRIEVE to initiate transfer"},
+		{"TYPE A\r\n", CommandOK},
+		{"REST 1024\r\n", CmdNotImplementedForParam},
+		{"TYPE I\r\n", CommandOK},
+	} {
+		handler, req, _ := w.Parse(step.Command)
+		if resp, _ := handler(req); resp != step.Expected {
+			t.Errorf("%s: Expected Response: %s, but got %s", step.Command, step.Expected, resp)
+		}
+	}
+
+	// switching to TYPE A dropped the marker set before it
+	if w.restart != 0 {
+		t.Errorf("Expected: %d, but got %d", 0, w.restart)
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var ErrNoPassivePorts = errors.New("no passive ports available")
+
+// PortPool hands out ports for passive data connections from a fixed range,
+// so that they can be opened up on firewalls and exposed from containers
+//
+// ports are handed out round robin, rather than always starting from the
+// bottom of the range, so that a port that was just released isn't
+// immediately reused while a slow client might still be trying to reach it
+type PortPool struct {
+	mutex sync.Mutex
+
+	// an empty range (0, 0) leaves the choice of port to the OS
+	min  int
+	max  int
+	next int
+
+	inUse map[int]struct{}
+}
+
+func NewPortPool(min, max uint16) *PortPool {
+	return &PortPool{
+		min:   int(min),
+		max:   int(max),
+		next:  int(min),
+		inUse: make(map[int]struct{}),
+	}
+}
+
+// poolListener returns its port to the pool once closed
+type poolListener struct {
+	*net.TCPListener
+	once    sync.Once
+	release func()
+}
+
+func (l *poolListener) Close() error {
+	l.once.Do(l.release)
+	return l.TCPListener.Close()
+}
+
+// Listen opens a listener on the next free port in the range, the port is
+// returned to the pool when the listener is closed
+func (p *PortPool) Listen() (net.Listener, uint16, error) {
+	if p.min == 0 && p.max == 0 {
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return listener, uint16(listener.Addr().(*net.TCPAddr).Port), nil
+	}
+
+	for attempt := 0; attempt < p.size(); attempt++ {
+		port, ok := p.reserve()
+		if !ok {
+			break
+		}
+
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			// most likely bound by some other process, move on to the next one
+			p.release(port)
+			continue
+		}
+
+		return &poolListener{
+			TCPListener: listener.(*net.TCPListener),
+			release:     func() { p.release(port) },
+		}, uint16(port), nil
+	}
+
+	return nil, 0, ErrNoPassivePorts
+}
+
+// InUse is the number of ports currently handed out
+func (p *PortPool) InUse() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.inUse)
+}
+
+func (p *PortPool) size() int {
+	return p.max - p.min + 1
+}
+
+func (p *PortPool) reserve() (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := 0; i < p.size(); i++ {
+		port := p.next
+		p.next++
+		if p.next > p.max {
+			p.next = p.min
+		}
+
+		if _, ok := p.inUse[port]; !ok {
+			p.inUse[port] = struct{}{}
+			return port, true
+		}
+	}
+
+	return 0, false
+}
+
+func (p *PortPool) release(port int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.inUse, port)
+}
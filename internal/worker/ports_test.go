@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// freeRange finds a range of ports that nothing else on the host is bound to
+func freeRange(t *testing.T, size int) (uint16, uint16) {
+	for min := 40000; min+size < 60000; min += size {
+		free := true
+		for port := min; port < min+size; port++ {
+			listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				free = false
+				break
+			}
+			listener.Close()
+		}
+
+		if free {
+			return uint16(min), uint16(min + size - 1)
+		}
+	}
+
+	t.Skip("no free port range available")
+	return 0, 0
+}
+
+func TestPortPoolRoundRobin(t *testing.T) {
+	min, max := freeRange(t, 3)
+	pool := NewPortPool(min, max)
+
+	var ports []uint16
+	for i := 0; i < 4; i++ {
+		listener, port, err := pool.Listen()
+		if err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		listener.Close()
+		ports = append(ports, port)
+	}
+
+	expected := []uint16{min, min + 1, max, min}
+	for i := range expected {
+		if ports[i] != expected[i] {
+			t.Errorf("Expected: %v, but got %v", expected, ports)
+			break
+		}
+	}
+}
+
+func TestPortPoolExhausted(t *testing.T) {
+	min, max := freeRange(t, 2)
+	pool := NewPortPool(min, max)
+
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		listener, _, err := pool.Listen()
+		if err != nil {
+			t.Fatalf("Expected nil error, but got %v", err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if _, _, err := pool.Listen(); err != ErrNoPassivePorts {
+		t.Errorf("Expected: %v, but got %v", ErrNoPassivePorts, err)
+	}
+
+	if pool.InUse() != 2 {
+		t.Errorf("Expected: %d, but got %d", 2, pool.InUse())
+	}
+
+	// closing a listener hands its port back
+	listeners[0].Close()
+	listener, port, err := pool.Listen()
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer listener.Close()
+	defer listeners[1].Close()
+
+	if port != min {
+		t.Errorf("Expected: %d, but got %d", min, port)
+	}
+}
+
+func TestPortPoolEphemeral(t *testing.T) {
+	listener, port, err := NewPortPool(0, 0).Listen()
+	if err != nil {
+		t.Fatalf("Expected nil error, but got %v", err)
+	}
+	defer listener.Close()
+
+	if port < 1024 {
+		t.Errorf("Expected an unprivileged port, but got %d", port)
+	}
+}
@@ -0,0 +1,22 @@
+package worker
+
+import (
+	"fmt"
+	"goftp/internal/logger"
+	"runtime/debug"
+)
+
+// contain is deferred at the top of every go routine spawned for a session, so
+// that a panic only takes down the session it happened in rather than the
+// whole process, then is run once the panic has been recovered from
+func contain(l logger.Client, where string, then ...func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	l.Info(fmt.Sprintf("%s: recovered from panic: %v\n%s", where, r, debug.Stack()))
+	for _, fn := range then {
+		fn()
+	}
+}
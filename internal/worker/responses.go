@@ -1,6 +1,9 @@
 package worker
 
-import "fmt"
+import (
+	"fmt"
+	"net/netip"
+)
 
 type Response string
 
@@ -12,15 +15,16 @@ func (r Response) String() string {
 	return string(r.Byte())
 }
 
-// TODO: eventually take in host name
-func GeneratePassiveResponse(port uint16) Response {
-	var MSB uint16
-	var LSB uint16
+func GeneratePassiveResponse(host netip.Addr, port uint16) Response {
+	return Response(fmt.Sprintf("227 Entering Passive Mode (%s)", formatPort(netip.AddrPortFrom(host, port))))
+}
 
-	LSB = port & uint16(0x00FF)
-	MSB = (port >> 8) & uint16(0x00FF)
+func GenerateLongPassiveResponse(host netip.Addr, port uint16) Response {
+	return Response(fmt.Sprintf("228 Entering Long Passive Mode (%s)", formatLongPort(netip.AddrPortFrom(host, port))))
+}
 
-	return Response(fmt.Sprintf("227 Entering Passive Mode (127,0,0,1,%d,%d)", MSB, LSB))
+func GenerateExtendedPassiveResponse(port uint16) Response {
+	return Response(fmt.Sprintf("229 Entering Extended Passive Mode (|||%d|)", port))
 }
 
 const (
@@ -42,7 +46,15 @@ const (
 // 200s
 const (
 	CommandOK         Response = "200 Command okay"
+	EpsvAllOK         Response = "200 EPSV ALL command successful"
+	NoQuota           Response = "200 No quota applies to %s"
+	QuotaReport       Response = "200-Quotas of %s\r\n%s\r\n200 End"
+	ProtectionBuffer  Response = "200 PBSZ=0"
+	FeatureList       Response = "211-Features:\r\n%s\r\n211 End"
+	HelpCommands      Response = "214-The following commands are recognized:\r\n%s\r\n214 Help OK"
+	HelpMessage       Response = "214 %s"
 	ServiceReady      Response = "220 Service Ready"
+	Banner            Response = "220 %s"
 	UserQuit          Response = "221 Service closing control connection"
 	UserLoggedIn      Response = "230 User logged in, proceed"
 	TransferComplete  Response = "250 Requested file action okay, completed"
@@ -51,24 +63,34 @@ const (
 
 // 300s
 const (
-	UserOkNeedPW Response = "331 User name okay, need password"
+	UserOkNeedPW       Response = "331 User name okay, need password"
+	PendingFurtherInfo Response = "350 Requested file action pending further information"
+	RestartMarker      Response = "350 Restarting at %d. Send STORE or RETRIEVE to initiate transfer"
 )
 
 // 400s
 const (
 	CannotOpenDataConnection Response = "425 Can't open data connection"
 	ServiceNotAvailable      Response = "421 Service not available, closing control connection"
+	IdleTimeout              Response = "421 Idle timeout, closing control connection"
 	TransferAborted          Response = "426 Connection closed; transfer aborted"
 	FileActionNotTaken       Response = "450 Requested file action not taken"
+	LocalError               Response = "451 Requested action aborted: local error in processing"
 )
 
 // 500s
 const (
-	SyntaxError1              Response = "500 Syntax error, command unrecognized"
-	SyntaxError2              Response = "501 Syntax error in parameters or arguments"
-	CmdNotImplemented         Response = "502 Command not implemented"
-	BadSequence               Response = "503 Bad sequence of commands"
-	CmdNotImplementedForParam Response = "504 Command not implemented for that parameter"
-	NotLoggedIn               Response = "530 Not logged in"
-	FileNotFound              Response = "550 Requested action not taken"
+	SyntaxError1                Response = "500 Syntax error, command unrecognized"
+	SyntaxError2                Response = "501 Syntax error in parameters or arguments"
+	CmdNotImplemented           Response = "502 Command not implemented"
+	BadSequence                 Response = "503 Bad sequence of commands"
+	CmdNotImplementedForParam   Response = "504 Command not implemented for that parameter"
+	AddressFamilyNotSupported   Response = "521 Supported address families are (4, 6)"
+	NetworkProtocolNotSupported Response = "522 Network protocol not supported, use (%s)"
+	NotLoggedIn                 Response = "530 Not logged in"
+	ProtectionNotSupported      Response = "536 Requested PROT level not supported by mechanism"
+	FileNotFound                Response = "550 Requested action not taken"
+	ExceededStorage             Response = "552 Requested file action aborted, exceeded storage allocation"
+	FileNameNotAllowed          Response = "553 Requested action not taken, file name not allowed"
+	InvalidRestart              Response = "554 Requested action not taken: invalid REST parameter"
 )
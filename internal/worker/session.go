@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"goftp/internal/auth"
+	"goftp/internal/vfs"
+	"io"
+)
+
+// Session is what the Handler of a Command can do with the session it's run on, along with
+// what the Request carries (User, Path), the built in commands go through the same
+type Session interface {
+	Filesystem() vfs.Filesystem
+	Permitted(perm auth.Permission, pth string) bool
+	Transfer(fn func(conn io.ReadWriter) error) Response
+}
+
+// Filesystem is the storage the user sees, with their quotas applied
+func (c *ControlWorker) Filesystem() vfs.Filesystem {
+	return c.fs
+}
+
+// Permitted reports whether the user that's logged in holds perm on the virtual path pth
+func (c *ControlWorker) Permitted(perm auth.Permission, pth string) bool {
+	return c.loggedIn && c.permitted(perm, pth)
+}
+
+// Transfer hands the data connection set up ahead of the request, by PASV, PORT or the like,
+// to fn, for commands of Sequence Transfer. The Response it returns tells the client the transfer
+// is starting, its outcome follows once fn returns, an error reports the transfer as aborted
+func (c *ControlWorker) Transfer(fn func(conn io.ReadWriter) error) Response {
+	switch c.state.Get() {
+	case Pasv, Port, Epsv, Eprt, Lpsv, Lprt:
+	default:
+		return BadSequence
+	}
+
+	if c.draining() {
+		return ServiceNotAvailable
+	}
+
+	c.state.Set(Custom)
+	c.dataWorker.Run(fn)
+	return StartTransfer
+}
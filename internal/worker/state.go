@@ -7,62 +7,67 @@ type CMD string
 const (
 	None     CMD = "NONE"
 	Store    CMD = "STOR"
+	Append   CMD = "APPE"
 	Retrieve CMD = "RETR"
+	List     CMD = "LIST"
+	NameList CMD = "NLST"
 	Delete   CMD = "DELE"
 	Port     CMD = "PORT"
 	Pasv     CMD = "PASV"
+	Eprt     CMD = "EPRT"
+	Epsv     CMD = "EPSV"
+	Lprt     CMD = "LPRT"
+	Lpsv     CMD = "LPSV"
+
+	// a transfer of a command that isn't built in, see ControlWorker.Transfer
+	Custom CMD = "CUSTOM"
 )
 
-// If a command appears here, that implies that it
-// should be rejected
-var baseReject = map[CMD]any{
+// commands that set up or make use of a data connection, refused while draining
+var transfers = map[CMD]any{
 	Retrieve: nil,
-	Delete:   nil,
+	List:     nil,
+	NameList: nil,
 	Store:    nil,
+	Append:   nil,
 	Pasv:     nil,
 	Port:     nil,
+	Epsv:     nil,
+	Eprt:     nil,
+	Lpsv:     nil,
+	Lprt:     nil,
 }
 
-// currentCMD -> requestedCMD --> Reject ~ true | false
+// Sequence is where a command can fall while a data connection is set up and used
 //
-//		NONE -> Delete -------------------------> NONE
+//		NONE -> Idle ---------------------------> NONE
 //	       \                                       ^
 //		    \                                     /
 //		     v                                   /
-//		     (PORT | PASV) -> (Store | Retrieve)
-var table = map[CMD]map[CMD]any{
-	None: {
-		Retrieve: nil,
-		Store:    nil,
-	},
-	Store:    baseReject,
-	Retrieve: baseReject,
-	Delete:   baseReject,
-	Pasv: {
-		Pasv:   nil,
-		Port:   nil,
-		Delete: nil,
-	},
-	Port: {
-		Port:   nil,
-		Pasv:   nil,
-		Delete: nil,
-	},
-}
+//		     (PORT | PASV | EPRT | EPSV | LPRT | LPSV) -> Transfer
+type Sequence int
+
+const (
+	// accepted whatever the state, such as configuration and other lcm commands
+	Anytime Sequence = iota
+
+	// only accepted while no data connection is set up or in use, PASV, PORT or DELE for instance
+	Idle
+
+	// only accepted right after a data connection has been set up, which it then makes use of
+	Transfer
+)
 
 type State struct {
 	// current executing state
 	cmd CMD
 
-	table map[CMD]map[CMD]any
-
 	mutex sync.Locker
 }
 
 func NewState() *State {
 	return &State{
 		cmd:   None,
-		table: table,
 		mutex: new(sync.Mutex),
 	}
 }
@@ -77,7 +82,21 @@ func NewState() *State {
 func (c *State) Check(requested *Request, handler Handler) Handler {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if _, reject := table[c.cmd][CMD(requested.Cmd)]; reject {
+
+	var allowed bool
+	switch requested.sequence {
+	case Idle:
+		allowed = c.cmd == None
+	case Transfer:
+		switch c.cmd {
+		case Pasv, Port, Epsv, Eprt, Lpsv, Lprt:
+			allowed = true
+		}
+	default:
+		allowed = true
+	}
+
+	if !allowed {
 		return func(r *Request) (Response, error) {
 			return BadSequence, nil
 		}
@@ -97,3 +116,16 @@ func (c *State) Get() CMD {
 	defer c.mutex.Unlock()
 	return c.cmd
 }
+
+// Transferring reports whether a data transfer is under way
+func (c *State) Transferring() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.cmd {
+	case Store, Append, Retrieve, List, NameList, Custom:
+		return true
+	}
+
+	return false
+}
@@ -1,8 +1,8 @@
 package worker
 
 import (
+	"goftp/internal/vfs"
 	"io"
-	"os"
 )
 
 // Transfer Parameters, only accepting a subset from spec
@@ -29,7 +29,7 @@ type TransferFactory struct {
 	PWD string
 }
 
-func (t *TransferFactory) Create(fd *os.File) (io.ReadWriteCloser, error) {
+func (t *TransferFactory) Create(fd vfs.File) (io.ReadWriteCloser, error) {
 	// TODO: use fields Mode, Type, Structure to
 	// generate specific to those params ReadWriter
 	// and return that to caller
@@ -75,7 +75,7 @@ func NewDefaultTransferFactory() *TransferFactory {
 	return &TransferFactory{
 		Mode:      'S', // Stream
 		Structure: 'F', // File
-		Type:      'A', // ASCII
+		Type:      'I', // Image
 		PWD:       "/temp",
 	}
 }
@@ -2,6 +2,9 @@ package worker
 
 import (
 	"fmt"
+	"goftp/internal/auth"
+	"strconv"
+	"strings"
 )
 
 func (c ControlWorker) handlePWD(req *Request) (Response, error) {
@@ -50,6 +53,11 @@ func (c *ControlWorker) handleType(req *Request) (Response, error) {
 		return CmdNotImplementedForParam, nil
 	}
 
+	// offsets don't line up with the file once line endings are converted
+	if symbol == 'A' {
+		c.restart = 0
+	}
+
 	c.dataWorker.SetType(symbol)
 	return CommandOK, nil
 }
@@ -118,16 +126,6 @@ func (c *ControlWorker) handleStrucure(req *Request) (Response, error) {
 
 //---------------------------------------------------------------------------
 
-// DELE
-//
-//	250
-//	450, 550
-//	500, 501, 502, 421, 530
-func (c *ControlWorker) handleDelete(req *Request) (Response, error) {
-	c.state.Set(Delete)
-	return TransferComplete, nil
-}
-
 /*
 DATA PORT (PORT)
 
@@ -149,10 +147,38 @@ DATA PORT (PORT)
 	address.
 */
 func (c *ControlWorker) handlePort(req *Request) (Response, error) {
-	c.state.Set(Port)
-	return c.dataWorker.Connect(req), nil
+	if c.epsvAll {
+		return BadSequence, nil
+	}
+
+	return c.connect(req), nil
 }
 
+/*
+EXTENDED PORT (EPRT)
+
+	The EPRT command allows for the specification of an extended address
+	for the data connection.  The extended address MUST consist of the
+	network protocol as well as the network and transport addresses.
+
+	   EPRT<space><d><net-prt><d><net-addr><d><tcp-port><d>
+
+	https://www.rfc-editor.org/rfc/rfc2428#section-2
+*/
+
+/*
+LONG PORT (LPRT), LONG PASSIVE (LPSV)
+
+	Versions of PORT and PASV that carry the address family and the
+	length of the address along with it, so that they aren't limited
+	to 32-bit internet addresses.
+
+	   LPRT af,hal,h1,h2,...,hn,pal,p1,p2
+	   228 Entering Long Passive Mode (af,hal,h1,h2,...,hn,pal,p1,p2)
+
+	https://www.rfc-editor.org/rfc/rfc1639
+*/
+
 /*
 PASSIVE (PASV)
 
@@ -168,8 +194,45 @@ PASSIVE (PASV)
 	character string representation).
 */
 func (c *ControlWorker) handlePassive(req *Request) (Response, error) {
-	c.state.Set(Pasv)
-	return c.dataWorker.Connect(req), nil
+	if c.epsvAll {
+		return BadSequence, nil
+	}
+
+	return c.connect(req), nil
+}
+
+/*
+EXTENDED PASSIVE (EPSV)
+
+	The EPSV command requests that a server listen on a data port and
+	wait for a connection.  The EPSV command takes an optional argument.
+	The response to this command includes only the TCP port number of the
+	listening connection.
+
+	When the EPSV command is issued with the argument "ALL", the server
+	MUST reject all data connection setup commands other than EPSV
+	(i.e., EPRT, PORT, PASV, et al.).
+
+	https://www.rfc-editor.org/rfc/rfc2428#section-3
+*/
+func (c *ControlWorker) handleExtendedPassive(req *Request) (Response, error) {
+	if strings.ToUpper(req.Arg) == "ALL" {
+		c.epsvAll = true
+		return EpsvAllOK, nil
+	}
+
+	return c.connect(req), nil
+}
+
+// connect has the DataWorker set up a data connection, only a successful
+// reply leaves one waiting to be used by a subsequent transfer
+func (c *ControlWorker) connect(req *Request) Response {
+	response := c.dataWorker.Connect(req)
+	if strings.HasPrefix(string(response), "2") {
+		c.state.Set(CMD(req.Cmd))
+	}
+
+	return response
 }
 
 // RETR
@@ -182,7 +245,9 @@ func (c *ControlWorker) handlePassive(req *Request) (Response, error) {
 //	500, 501, 421, 530
 func (c *ControlWorker) handleRetrieve(req *Request) (Response, error) {
 	c.state.Set(Retrieve)
-	c.dataWorker.SetTransferRequest(req)
+	c.dataWorker.SetTransferRequest(&Request{Cmd: req.Cmd, Arg: c.resolve(req.Arg)})
+	c.dataWorker.SetRestart(c.restart)
+	c.restart = 0
 	c.dataWorker.Start()
 	return StartTransfer, nil
 }
@@ -196,8 +261,138 @@ func (c *ControlWorker) handleRetrieve(req *Request) (Response, error) {
 //	532, 450, 452, 553
 //	500, 501, 421, 530
 func (c *ControlWorker) handleStore(req *Request) (Response, error) {
+	if c.restarted() {
+		return InvalidRestart, nil
+	}
+
+	pth := c.resolve(req.Arg)
+
+	// replacing an existing file is a separate grant from creating one
+	perm := auth.Upload
+	if _, err := c.fs.Stat(pth); err == nil {
+		perm = auth.Overwrite
+	}
+
+	if !c.permitted(perm, pth) {
+		return FileNotFound, nil
+	}
+
 	c.state.Set(Store)
-	c.dataWorker.SetTransferRequest(req)
+	c.dataWorker.SetTransferRequest(&Request{Cmd: req.Cmd, Arg: pth})
+	c.dataWorker.Start()
+	return StartTransfer, nil
+}
+
+// APPE
+//
+//	125, 150
+//	   (110)
+//	   226, 250
+//	   425, 426, 451, 551, 552
+//	532, 450, 550, 452, 553
+//	500, 501, 502, 421, 530
+func (c *ControlWorker) handleAppend(req *Request) (Response, error) {
+	if c.restarted() {
+		return InvalidRestart, nil
+	}
+
+	c.state.Set(Append)
+	c.dataWorker.SetTransferRequest(&Request{Cmd: req.Cmd, Arg: c.resolve(req.Arg)})
+	c.dataWorker.Start()
+	return StartTransfer, nil
+}
+
+// LIST, NLST
+//
+//	125, 150
+//	   226, 250
+//	   425, 426, 451
+//	450
+//	500, 501, 502, 421, 530
+func (c *ControlWorker) handleList(req *Request) (Response, error) {
+	// flags such as -la are sent by some clients, but are not part of the spec
+	arg := req.Arg
+	if strings.HasPrefix(arg, "-") {
+		arg = ""
+	}
+
+	pth := c.resolve(arg)
+	if !c.permitted(auth.List, pth) {
+		return FileNotFound, nil
+	}
+
+	c.state.Set(CMD(req.Cmd))
+	c.dataWorker.SetTransferRequest(&Request{Cmd: req.Cmd, Arg: pth})
 	c.dataWorker.Start()
 	return StartTransfer, nil
 }
+
+/*
+RESTART (REST)
+
+	Sets the offset the next RETR starts reading the file from, so that
+	interrupted downloads can be resumed, only stream mode is supported
+	and uploads can't be resumed, STOR and APPE refuse a marker with a 554
+
+	TYPE A transfers convert line endings, so an offset into what was sent
+	doesn't match one into the file and REST is refused while it's in use
+
+	https://www.rfc-editor.org/rfc/rfc3659#section-5
+
+	350
+	500, 501, 502, 421, 530
+*/
+func (c *ControlWorker) handleRestart(req *Request) (Response, error) {
+	offset, err := strconv.ParseInt(req.Arg, 10, 64)
+	if err != nil || offset < 0 {
+		return SyntaxError2, nil
+	}
+
+	if c.dataWorker.GetType() == 'A' {
+		return CmdNotImplementedForParam, nil
+	}
+
+	c.restart = offset
+	return Response(fmt.Sprintf(string(RestartMarker), offset)), nil
+}
+
+// restarted consumes the marker left by REST, reporting whether there was one
+func (c *ControlWorker) restarted() bool {
+	offset := c.restart
+	c.restart = 0
+	return offset != 0
+}
+
+/*
+PROTECTION BUFFER SIZE (PBSZ)
+
+	TLS is a streaming protocol, so the only buffer size that
+	makes sense is 0, which is what is replied with regardless
+
+	https://www.rfc-editor.org/rfc/rfc4217#section-8
+*/
+func (c ControlWorker) handleProtectionBuffer(req *Request) (Response, error) {
+	if req.Arg == "" {
+		return SyntaxError2, nil
+	}
+
+	return ProtectionBuffer, nil
+}
+
+/*
+DATA CHANNEL PROTECTION LEVEL (PROT)
+
+	C - Clear
+	P - Private
+
+	Private is only supported on sessions that are themselves over TLS
+
+	https://www.rfc-editor.org/rfc/rfc4217#section-9
+*/
+func (c *ControlWorker) handleProtection(req *Request) (Response, error) {
+	if len(req.Arg) != 1 {
+		return SyntaxError2, nil
+	}
+
+	return c.dataWorker.Protect(rune(strings.ToUpper(req.Arg)[0])), nil
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"crypto/rand"
+	"goftp/internal/vfs"
+	"io"
+	"path"
+	"strings"
+)
+
+// uploadSuffix ends the names of files uploads are written to until they're complete
+const uploadSuffix = ".part"
+
+// isUpload reports whether name is that of a file an upload is being written to
+func isUpload(name string) bool {
+	base := strings.TrimSuffix(name, uploadSuffix)
+	return strings.HasPrefix(name, ".") && len(base) > 10 && len(base) < len(name) && base[len(base)-9] == '.'
+}
+
+// upload is a STOR that's written to a hidden file next to name and only
+// takes the place of name once Commit is called, a transfer that fails or
+// is aborted is closed instead, which throws away what was written so far
+type upload struct {
+	vfs.File
+
+	fs        vfs.Filesystem
+	name      string
+	temp      string
+	committed bool
+}
+
+// createUpload opens a hidden file in the same directory as name, so it
+// can be renamed into place without moving it across filesystems
+func (d *DataWorker) createUpload(name string) (vfs.File, error) {
+	dir, base := path.Split(vfs.Clean(name))
+	temp := path.Join(dir, "."+base+"."+rand.Text()[:8]+uploadSuffix)
+
+	fd, err := vfs.CreateTemp(d.fs, name, temp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload{File: fd, fs: d.fs, name: name, temp: temp}, nil
+}
+
+// Commit flushes what was written to stable storage, when the filesystem
+// can, and renames it into place
+func (u *upload) Commit() error {
+	if syncer, ok := u.File.(vfs.Syncer); ok {
+		if err := syncer.Sync(); err != nil {
+			u.Close()
+			return err
+		}
+	}
+
+	if err := u.File.Close(); err != nil {
+		u.fs.Remove(u.temp)
+		return err
+	}
+
+	if err := u.fs.Rename(u.temp, u.name); err != nil {
+		u.fs.Remove(u.temp)
+		return err
+	}
+
+	u.committed = true
+	return nil
+}
+
+// ReadFrom lets the file underneath splice(2) straight from the data connection
+func (u *upload) ReadFrom(r io.Reader) (int64, error) {
+	if from, ok := u.File.(io.ReaderFrom); ok {
+		return from.ReadFrom(r)
+	}
+
+	return io.Copy(struct{ io.Writer }{u.File}, r)
+}
+
+// Close throws the upload away unless it's been committed
+func (u *upload) Close() error {
+	if u.committed {
+		return nil
+	}
+
+	err := vfs.Abort(u.File)
+	u.fs.Remove(u.temp)
+	return err
+}
@@ -0,0 +1,133 @@
+//go:build linux
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"goftp/internal/throttle"
+	"goftp/internal/vfs"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// benchmarkSize is large enough for the cost of the copy to outweigh everything else
+const benchmarkSize = 64 << 20
+
+// buffered serves files that hide ReadFrom and WriteTo, as files were before transfers
+// were forwarded to the kernel, leaving io.Copy to move everything through a buffer
+type buffered struct {
+	vfs.Filesystem
+}
+
+func (b buffered) Open(name string) (vfs.File, error) {
+	fd, err := b.Filesystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ vfs.File }{fd}, nil
+}
+
+func (b buffered) Create(name string) (vfs.File, error) {
+	fd, err := b.Filesystem.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ vfs.File }{fd}, nil
+}
+
+// cpuTime returns the CPU time used by the process so far, in user and kernel space,
+// which takes in the client's side of the benchmarks as well
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// transfer runs cmd through Pipe the way a logged in session does, binary, with the stall timeout
+// and an unlimited throttle, while client does the other end of it on the data connection
+func transfer(b *testing.B, fs vfs.Filesystem, cmd string, client func(net.Conn)) {
+	d := NewDataWorker(context.Background(), slog.New(slog.DiscardHandler),
+		WithPeer(netip.MustParseAddr("127.0.0.1")),
+		WithLocalAddr(netip.MustParseAddr("127.0.0.1")),
+		WithDataFilesystem(fs),
+		WithDataTimeouts(Timeouts{DataConnect: time.Minute, Stall: time.Minute}),
+		WithAtomicUploads(false),
+	)
+	defer d.Stop()
+
+	session := throttle.New(throttle.Config{}).Session("hkhan")
+	defer session.Close()
+	d.SetThrottle(session)
+	d.SetType('I')
+
+	var port int
+	resp := d.Connect(&Request{Cmd: "EPSV"})
+	if _, err := fmt.Sscanf(string(resp), "229 Entering Extended Passive Mode (|||%d|)", &port); err != nil {
+		b.Fatalf("Unable to parse %s: %v", resp, err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	d.SetTransferRequest(&Request{Cmd: cmd, Arg: "/data"})
+	d.Start()
+	go client(conn)
+	if resp := <-d.Read(); resp != TransferComplete {
+		b.Fatalf("Expected Response: %s, but got %s", TransferComplete, resp)
+	}
+}
+
+func Benchmark_Retrieve(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(dir+"/data", make([]byte, benchmarkSize), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for name, fs := range map[string]vfs.Filesystem{"sendfile": vfs.NewOS(dir), "buffered": buffered{vfs.NewOS(dir)}} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(benchmarkSize)
+			b.ReportAllocs()
+			start := cpuTime(b)
+			for b.Loop() {
+				transfer(b, fs, "RETR", func(conn net.Conn) {
+					io.Copy(io.Discard, conn)
+				})
+			}
+			b.ReportMetric(float64(cpuTime(b)-start)/float64(time.Millisecond)/float64(b.N), "cpu-ms/op")
+		})
+	}
+}
+
+func Benchmark_Store(b *testing.B) {
+	dir := b.TempDir()
+	chunk := make([]byte, 1<<20)
+
+	for name, fs := range map[string]vfs.Filesystem{"splice": vfs.NewOS(dir), "buffered": buffered{vfs.NewOS(dir)}} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(benchmarkSize)
+			b.ReportAllocs()
+			start := cpuTime(b)
+			for b.Loop() {
+				transfer(b, fs, "STOR", func(conn net.Conn) {
+					for range benchmarkSize / len(chunk) {
+						conn.Write(chunk)
+					}
+					conn.Close()
+				})
+			}
+			b.ReportMetric(float64(cpuTime(b)-start)/float64(time.Millisecond)/float64(b.N), "cpu-ms/op")
+		})
+	}
+}